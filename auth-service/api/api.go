@@ -3,35 +3,42 @@ package api
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/BearCloud/sp21-bearchat/common/auth"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/bcrypt"
 )
 
-const (
-	verifyTokenSize = 6
-	resetTokenSize  = 6
-)
-
 // RegisterRoutes initializes the api endpoints and maps the requests to specific functions. The API will
-// make use of the passed in Mailer and database connection. What HTTP methods would be most appropriate
+// make use of the passed in EmailQueue and database connection. What HTTP methods would be most appropriate
 // for each route?
-func RegisterRoutes(router *mux.Router, m Mailer, db *sql.DB) {
-	router.HandleFunc("/api/auth/signup", signup(m, db)).Methods(/*YOUR CODE HERE*/)
+//
+// Routes wrapped in authenticate only run for requests with a valid access token. links gives the
+// frontend pages that browsers following a verification link are sent on to.
+func RegisterRoutes(router *mux.Router, q EmailQueue, db *sql.DB, links Links) {
+	authenticate := auth.Middleware(newValidator(db))
+
+	router.HandleFunc("/api/auth/signup", signup(q, db)).Methods(/*YOUR CODE HERE*/)
 	router.HandleFunc("/api/auth/signin", signin(db)).Methods(/*YOUR CODE HERE*/)
-	router.HandleFunc("/api/auth/logout", logout).Methods(/*YOUR CODE HERE*/)
+	router.HandleFunc("/api/auth/logout", logout(db)).Methods(/*YOUR CODE HERE*/)
+	router.Handle("/api/auth/logout/all", authenticate(logoutEverywhere(db))).Methods(http.MethodPost)
+	// Verification links in emails are opened with GET, and redirect back to the frontend
+	router.HandleFunc("/api/auth/verify", verifyFromEmail(db, links)).Methods(http.MethodGet)
 	router.HandleFunc("/api/auth/verify", verify(db)).Methods(/*YOUR CODE HERE*/)
-	router.HandleFunc("/api/auth/sendreset", sendReset(m, db)).Methods(/*YOUR CODE HERE*/)
+	router.HandleFunc("/api/auth/sendreset", sendReset(q, db)).Methods(/*YOUR CODE HERE*/)
 	router.HandleFunc("/api/auth/resetpw", resetPassword(db)).Methods(/*YOUR CODE HERE*/t)
+	router.HandleFunc("/api/auth/refresh", refresh(db)).Methods(http.MethodPost)
+	router.HandleFunc("/.well-known/jwks.json", jwks).Methods(http.MethodGet)
 }
 
 // A function that handles signing a user up for Bearchat.
-func signup(m Mailer, DB *sql.DB) http.HandlerFunc {
+func signup(q EmailQueue, DB *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Obtain the credentials from the request body
 
@@ -57,80 +64,59 @@ func signup(m Mailer, DB *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Create a new user UUID, convert it to string, and store it within a variable
+		// Start a transaction, so the user and their verification email are stored together or not at all
+		tx, err := DB.Begin()
+		if err != nil {
+			http.Error(w, "error storing credentials", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		defer tx.Rollback()
 
-		// Create new verification token with the default token size (look at GetRandomBase62 and our constants)
+		// Create a new user UUID, convert it to string, and store it within a variable
 
-		// Store credentials in database
+		// Store credentials in database (use tx instead of DB so it's part of the transaction)
 
 		// Check for errors in storing the credentials
 
-		// Generate an access token, expiry dates are in Unix time
-		accessExpiresAt := time.Now().Add(DefaultAccessJWTExpiry)
-		var accessToken string
-		accessToken, err = setClaims(AuthClaims{
-			UserID: /*YOUR CODE HERE*/,
-			StandardClaims: jwt.StandardClaims{
-				Subject:   "access",
-				ExpiresAt: accessExpiresAt.Unix(),
-				Issuer:    defaultJWTIssuer,
-				IssuedAt:  time.Now().Unix(),
-			},
-		})
-
-		// Check for error in generating an access token
+		// Send the user's emails in the language their browser asks for
+		_, err = tx.Exec("UPDATE users SET locale=? WHERE userId=?", requestLocale(r), userID)
 		if err != nil {
-			http.Error(w, "error generating access token", http.StatusInternalServerError)
+			http.Error(w, "error storing credentials", http.StatusInternalServerError)
 			log.Print(err.Error())
 			return
 		}
 
-		// Set the cookie, name it "access_token"
-		http.SetCookie(w, &http.Cookie{
-			Name:    "access_token",
-			Value:   accessToken,
-			Expires: accessExpiresAt,
-			// Since our website does not use HTTPS, we have this commented out.
-			// However, in an actual service you would definitely want this so no
-			// cookies get stolen!
-			//Secure:   true,
-			HttpOnly: true,
-			SameSite: http.SameSiteNoneMode,
-			Path:     "/",
-		})
-
-		// Generate refresh token
-		var refreshExpiresAt = time.Now().Add(DefaultRefreshJWTExpiry)
-		var refreshToken string
-		refreshToken, err = setClaims(AuthClaims{
-			UserID: userID,
-			StandardClaims: jwt.StandardClaims{
-				Subject:   "refresh",
-				ExpiresAt: refreshExpiresAt.Unix(),
-				Issuer:    defaultJWTIssuer,
-				IssuedAt:  time.Now().Unix(),
-			},
-		})
-
+		// Create a verification token for the user (see tokens.go). Only its hash is stored, so the token
+		// itself only ever appears in the email
+		verifyToken, err := issueToken(tx, userID, verifyPurpose, verifyTokenTTL)
 		if err != nil {
-			http.Error(w, "error creating refreshToken", http.StatusInternalServerError)
+			http.Error(w, "error storing credentials", http.StatusInternalServerError)
 			log.Print(err.Error())
 			return
 		}
 
-		// Set the refresh token ("refresh_token") as a cookie
-		http.SetCookie(w, &http.Cookie{
-			Name:    "refresh_token",
-			Value:   refreshToken,
-			Expires: refreshExpiresAt,
-			Path:    "/",
-		})
-
-		// Send verification email. Fill in the blank with the email of the user.
-		err = m.SendEmail(/*YOUR CODE HERE*/, "Email Verification", "user-signup.html", map[string]interface{}{"Token": verifyToken})
+		// Queue the verification email, which is sent in the background once the transaction commits.
+		// Fill in the blank with the email of the user.
+		err = q.Enqueue(tx, /*YOUR CODE HERE*/, "user-signup", map[string]interface{}{"Token": verifyToken})
 		if err != nil {
 			http.Error(w, "error sending verification email", http.StatusInternalServerError)
 			log.Print(err.Error())
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			http.Error(w, "error storing credentials", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+
+		// Start a new session and set the access token and refresh token as cookies
+		err = setTokenCookies(w, DB, userID, uuid.New().String())
+		if err != nil {
+			http.Error(w, "error generating tokens", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
 		}
 
 		w.WriteHeader(http.StatusCreated)
@@ -156,64 +142,176 @@ func signin(DB *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Generate an access token and set it as a cookie
-		accessExpiresAt := time.Now().Add(DefaultAccessJWTExpiry)
-		var accessToken string
-		accessToken, err = setClaims(AuthClaims{
-			UserID: "",
-			StandardClaims: jwt.StandardClaims{
-				Subject:   "access",
-				ExpiresAt: accessExpiresAt.Unix(),
-				Issuer:    defaultJWTIssuer,
-				IssuedAt:  time.Now().Unix(),
-			},
-		})
-
-		//Check for error in generating an access token
-
-		//Set the cookie, name it "access_token"
-		http.SetCookie(w, &http.Cookie{
-			Name:     "access_token",
-			Value:    accessToken,
-			Expires:  accessExpiresAt,
-			HttpOnly: true,
-			SameSite: http.SameSiteNoneMode,
-			Path:     "/",
-		})
-
-		// Generate a refresh token and set it as a cookie
-		var refreshExpiresAt = time.Now().Add(DefaultRefreshJWTExpiry)
-		var refreshToken string
-		refreshToken, err = setClaims(AuthClaims{
-			UserID: userID,
-			StandardClaims: jwt.StandardClaims{
-				Subject:   "refresh",
-				ExpiresAt: refreshExpiresAt.Unix(),
-				Issuer:    defaultJWTIssuer,
-				IssuedAt:  time.Now().Unix(),
-			},
-		})
+		// Start a new session and set the access token and refresh token as cookies
+		err = setTokenCookies(w, DB, userID, uuid.New().String())
+		if err != nil {
+			http.Error(w, "error generating tokens", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+	}
+}
+
+// logout revokes the session that the request's cookies belong to, so any copies of its tokens stop
+// working, and then clears the cookies.
+func logout(DB *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, name := range []string{"access_token", "refresh_token"} {
+			cookie, err := r.Cookie(name)
+			if err != nil {
+				continue
+			}
+
+			// Tokens that are already expired or forged can't be used anyway
+			claims, err := parseClaims(cookie.Value)
+			if err != nil || claims.SessionID == "" {
+				continue
+			}
+
+			err = revokeSession(DB, claims.SessionID)
+			if err != nil {
+				http.Error(w, "error revoking session", http.StatusInternalServerError)
+				log.Print(err.Error())
+				return
+			}
+		}
+
+		clearTokenCookies(w)
+	}
+}
+
+// logoutEverywhere revokes every session belonging to the authenticated user, logging them out on all
+// of their devices.
+func logoutEverywhere(DB *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := revokeUserSessions(DB, auth.UserID(r))
+		if err != nil {
+			http.Error(w, "error revoking sessions", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+
+		clearTokenCookies(w)
+	}
+}
+
+// refresh exchanges the refresh_token cookie for a new access token. The refresh token is rotated
+// at the same time and the old one is revoked, so each refresh token can only be redeemed once.
+func refresh(DB *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("refresh_token")
+		if err != nil {
+			http.Error(w, "missing refresh token", http.StatusUnauthorized)
+			return
+		}
+
+		// Check the signature, expiry and issuer, then make sure this is actually a refresh token
+		claims, err := parseClaims(cookie.Value)
+		if err != nil || claims.Subject != "refresh" || claims.Id == "" {
+			http.Error(w, "invalid refresh token", http.StatusUnauthorized)
+			return
+		}
 
+		// Revoke the token we were given. Only one request can flip it from active to revoked, so if
+		// two requests race with the same token only one of them gets new tokens.
+		active, err := revokeToken(DB, claims.Id)
 		if err != nil {
-			http.Error(w, "error creating refreshToken", http.StatusInternalServerError)
+			http.Error(w, "error checking refresh token", http.StatusInternalServerError)
 			log.Print(err.Error())
 			return
 		}
+		if !active {
+			// A refresh token that was already used is a sign that it was stolen, so end the
+			// whole session rather than just rejecting this request. Tokens from before sessions
+			// existed have no session to end.
+			if claims.SessionID != "" {
+				err = revokeSession(DB, claims.SessionID)
+				if err != nil {
+					log.Print(err.Error())
+				}
+			}
+			http.Error(w, "refresh token has already been used", http.StatusUnauthorized)
+			return
+		}
 
-		http.SetCookie(w, &http.Cookie{
-			Name:    "refresh_token",
-			Value:   refreshToken,
-			Expires: refreshExpiresAt,
-			Path:    "/",
-		})
+		err = setTokenCookies(w, DB, claims.UserID, claims.SessionID)
+		if err != nil {
+			http.Error(w, "error generating tokens", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
 	}
 }
 
-func logout(w http.ResponseWriter, r *http.Request) {
-	// Set the access_token and refresh_token to have an empty value and set their expiration date to anytime in the past
-	var expiresAt = /*YOUR CODE HERE*/
-	http.SetCookie(w, &http.Cookie{Name: "access_token", Value: "", Expires: expiresAt})
-	http.SetCookie(w, &http.Cookie{Name: "refresh_token", Value: "", Expires: expiresAt})
+// setTokenCookies mints a fresh access token and refresh token for userID as part of the session
+// sessionID, records both of them so they can be revoked later, and sets them as cookies.
+func setTokenCookies(w http.ResponseWriter, DB *sql.DB, userID string, sessionID string) error {
+	accessExpiresAt := time.Now().Add(DefaultAccessJWTExpiry)
+	accessClaims := AuthClaims{
+		UserID:    userID,
+		SessionID: sessionID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Subject:   "access",
+			ExpiresAt: accessExpiresAt.Unix(),
+			Issuer:    defaultJWTIssuer,
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+	accessToken, err := setClaims(accessClaims)
+	if err != nil {
+		return err
+	}
+
+	refreshExpiresAt := time.Now().Add(DefaultRefreshJWTExpiry)
+	refreshClaims := AuthClaims{
+		UserID:    userID,
+		SessionID: sessionID,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.New().String(),
+			Subject:   "refresh",
+			ExpiresAt: refreshExpiresAt.Unix(),
+			Issuer:    defaultJWTIssuer,
+			IssuedAt:  time.Now().Unix(),
+		},
+	}
+	refreshToken, err := setClaims(refreshClaims)
+	if err != nil {
+		return err
+	}
+
+	err = recordTokens(DB, accessClaims, refreshClaims)
+	if err != nil {
+		return err
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:    "access_token",
+		Value:   accessToken,
+		Expires: accessExpiresAt,
+		// Since our website does not use HTTPS, we have this commented out.
+		// However, in an actual service you would definitely want this so no
+		// cookies get stolen!
+		//Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+		Path:     "/",
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:    "refresh_token",
+		Value:   refreshToken,
+		Expires: refreshExpiresAt,
+		Path:    "/",
+	})
+	return nil
+}
+
+// clearTokenCookies sets the access_token and refresh_token to have an empty value and an expiration
+// date in the past, which makes the browser delete them.
+func clearTokenCookies(w http.ResponseWriter) {
+	var expiresAt = time.Unix(0, 0)
+	http.SetCookie(w, &http.Cookie{Name: "access_token", Value: "", Expires: expiresAt, Path: "/"})
+	http.SetCookie(w, &http.Cookie{Name: "refresh_token", Value: "", Expires: expiresAt, Path: "/"})
 }
 
 func verify(DB *sql.DB) http.HandlerFunc {
@@ -226,19 +324,76 @@ func verify(DB *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Obtain the user with the verifiedToken from the query parameter and set their verification status to the integer "1"
-		
+		// Start a transaction, so the token is only used up if the user is verified too
+		tx, err := DB.Begin()
+		if err != nil {
+			http.Error(w, "error verifying email", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		// Redeem the token, which fails if it doesn't exist, has expired or was already used
+		userID, err := redeemToken(tx, verifyPurpose, token)
+		if errors.Is(err, errBadToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			log.Print(err.Error())
+			return
+		}
+		if err != nil {
+			http.Error(w, "error verifying email", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+
+		// Set the verification status of the user with userID to the integer "1"
+		// (use tx instead of DB so it's part of the transaction)
+
 		// Check for errors in executing the previous query
-		
-		// Make sure there were some rows affected
-		// Check: https://golang.org/pkg/database/sql/#Result
-		// This is to make sure that there was an email that was actually changed by our query.
-		// If no rows were affected return an error of type "StatusBadRequest"
 
+		err = tx.Commit()
+		if err != nil {
+			http.Error(w, "error verifying email", http.StatusInternalServerError)
+			log.Print(err.Error())
+		}
 	}
 }
 
-func sendReset(m Mailer, DB *sql.DB) http.HandlerFunc {
+// verifyFromEmail handles a verification link opened from a signup email. It verifies the user just
+// like verify, but instead of replying with a status a browser has nothing to show for, it redirects
+// to the frontend page saying whether it worked.
+func verifyFromEmail(DB *sql.DB, links Links) http.HandlerFunc {
+	verifyToken := verify(DB)
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := &statusRecorder{header: http.Header{}, status: http.StatusOK}
+		verifyToken(status, r)
+		http.Redirect(w, r, links.Verified(status.status < 400), http.StatusSeeOther)
+	}
+}
+
+// statusRecorder is a ResponseWriter that only keeps the status code written to it.
+type statusRecorder struct {
+	header http.Header
+	status int
+	wrote  bool
+}
+
+func (s *statusRecorder) Header() http.Header {
+	return s.header
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if !s.wrote {
+		s.status, s.wrote = status, true
+	}
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	return len(b), nil
+}
+
+func sendReset(q EmailQueue, DB *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get the email from the body (decode into an instance of Credentials)
 
@@ -246,18 +401,40 @@ func sendReset(m Mailer, DB *sql.DB) http.HandlerFunc {
 
 		// Check for other miscallenous errors that may occur
 		// What is considered an invalid input for an email?
-		
-		// Generate reset token
 
-		// Obtain the user with the specified email and set their resetToken to the token we generated
-		
-		// Check for errors executing the queries
+		// Start a transaction, so the reset token is only stored if its email is queued too
+		tx, err := DB.Begin()
+		if err != nil {
+			http.Error(w, "error storing reset token", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		defer tx.Rollback()
+
+		// Obtain the userId of the user with the specified email (use tx instead of DB so it's part of
+		// the transaction)
 
-		// Send verification email
-		err = m.SendEmail(/*YOUR CODE HERE*/, "BearChat Password Reset", "password-reset.html", map[string]interface{}{"Token": token})
+		// Check for errors executing the query
+
+		// Create a reset token for the user (see tokens.go), which replaces any reset token sent before
+		token, err := issueToken(tx, userID, resetPurpose, resetTokenTTL)
+		if err != nil {
+			http.Error(w, "error storing reset token", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+
+		// Queue the password reset email, which is sent in the background once the transaction commits
+		err = q.Enqueue(tx, /*YOUR CODE HERE*/, "password-reset", map[string]interface{}{"Token": token})
 		if err != nil {
 			http.Error(w, "error sending verification email", http.StatusInternalServerError)
 			log.Print(err.Error())
+			return
+		}
+		err = tx.Commit()
+		if err != nil {
+			http.Error(w, "error storing reset token", http.StatusInternalServerError)
+			log.Print(err.Error())
 		}
 	}
 }
@@ -266,18 +443,12 @@ func resetPassword(DB *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get token from query params
 
-		// Get the username, email, and password from the body
-		
+		// Get the new password from the body
+
 		// Check for errors decoding the body
 
 		// Check for invalid inputs, return an error if input is invalid
 
-		// Check if the username and token pair exist
-		
-		// Check for errors executing the query
-		
-		// Check exists boolean. Call an error if the username-token pair doesn't exist
-
 		// Hash the new password
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(/*YOUR CODE HERE*/), bcrypt.DefaultCost)
 
@@ -288,7 +459,37 @@ func resetPassword(DB *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Input new password and clear the reset token (set the token equal to empty string)
+		// Start a transaction, so the token is only used up if the password is changed too
+		tx, err := DB.Begin()
+		if err != nil {
+			http.Error(w, "error resetting password", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		defer tx.Rollback()
 
+		// Redeem the token, which fails if it doesn't exist, has expired or was already used
+		userID, err := redeemToken(tx, resetPurpose, token)
+		if errors.Is(err, errBadToken) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			log.Print(err.Error())
+			return
+		}
+		if err != nil {
+			http.Error(w, "error resetting password", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+
+		// Set the password of the user with userID to the new hashed password
+		// (use tx instead of DB so it's part of the transaction)
+
+		// Check for errors executing the query
+
+		err = tx.Commit()
+		if err != nil {
+			http.Error(w, "error resetting password", http.StatusInternalServerError)
+			log.Print(err.Error())
+		}
 	}
 }
@@ -2,6 +2,7 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"io"
@@ -14,6 +15,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/BearCloud/sp21-bearchat/common/auth"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -26,6 +28,12 @@ func TestMain(m *testing.M) {
 	log.SetFlags(0)
 	log.SetOutput(io.Discard)
 
+	// Load the signing keys, falling back to a temporary key if none are configured.
+	err := InitKeys()
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	// Runs the tests to completion then exits.
 	os.Exit(m.Run())
 }
@@ -59,7 +67,7 @@ func (s *AuthTestSuite) TestSignup() {
 		// Make a fake request and response to probe the function with.
 		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr := httptest.NewRecorder()
-		m := newRecordMailer()
+		m := newRecordQueue()
 
 		// Call the function with our fake stuff.
 		signup(m, s.db)(rr, r)
@@ -70,8 +78,8 @@ func (s *AuthTestSuite) TestSignup() {
 		// Check that the user was given an access_token and a refresh_token.
 		s.verifyLoginCookies(rr.Result().Cookies())
 
-		// Lastly, make sure that the mailer was called to send an email.
-		s.Assert().True(m.sendEmailCalled, "code did not call SendEmail with mailer")
+		// Lastly, make sure that the email was queued.
+		s.Assert().True(m.emailQueued, "code did not queue an email")
 	})
 
 	//Test Multiple Signups
@@ -84,7 +92,7 @@ func (s *AuthTestSuite) TestSignup() {
 
 			r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(credJson))
 			rr := httptest.NewRecorder()
-			m := newRecordMailer()
+			m := newRecordQueue()
 
 			// Call the function with our fake stuff.
 			signup(m, s.db)(rr, r)
@@ -95,8 +103,8 @@ func (s *AuthTestSuite) TestSignup() {
 			// Check that the user was given an access_token and a refresh_token.
 			s.verifyLoginCookies(rr.Result().Cookies())
 
-			// Lastly, make sure that the mailer was called to send an email.
-			s.Assert().True(m.sendEmailCalled, "code did not call SendEmail with mailer")
+			// Lastly, make sure that the email was queued.
+			s.Assert().True(m.emailQueued, "code did not queue an email")
 		}
 	})
 
@@ -105,7 +113,7 @@ func (s *AuthTestSuite) TestSignup() {
 		// Make a fake request and response to probe the function with.
 		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr := httptest.NewRecorder()
-		m := newRecordMailer()
+		m := newRecordQueue()
 
 		// Sign up for the first time.
 		signup(m, s.db)(rr, r)
@@ -136,7 +144,7 @@ func (s *AuthTestSuite) TestSignup() {
 		// Make a fake request and response to probe the function with.
 		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr := httptest.NewRecorder()
-		m := newRecordMailer()
+		m := newRecordQueue()
 
 		// Sign up for the first time.
 		signup(m, s.db)(rr, r)
@@ -169,7 +177,7 @@ func (s *AuthTestSuite) TestSignin() {
 		//First create an user and have it sign up.
 		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr := httptest.NewRecorder()
-		m := newRecordMailer()
+		m := newRecordQueue()
 
 		// Sign up for the first time.
 		signup(m, s.db)(rr, r)
@@ -211,7 +219,7 @@ func (s *AuthTestSuite) TestSignin() {
 		//First create an user and have it sign up.
 		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr := httptest.NewRecorder()
-		m := newRecordMailer()
+		m := newRecordQueue()
 
 		// Sign up for the first time.
 		signup(m, s.db)(rr, r)
@@ -240,7 +248,7 @@ func (s *AuthTestSuite) TestLogout() {
 	//First create an user and have it sign up.
 	r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 	rr := httptest.NewRecorder()
-	m := newRecordMailer()
+	m := newRecordQueue()
 
 	// Sign up for the first time.
 	signup(m, s.db)(rr, r)
@@ -259,7 +267,7 @@ func (s *AuthTestSuite) TestLogout() {
 	r.AddCookie(rr.Result().Cookies()[0])
 	r.AddCookie(rr.Result().Cookies()[1])
 	rr = httptest.NewRecorder()
-	logout(rr, r)
+	logout(s.db)(rr, r)
 
 	// Check that the user's access_token and refresh_token was set to expire.
 	cookies = rr.Result().Cookies()
@@ -269,13 +277,162 @@ func (s *AuthTestSuite) TestLogout() {
 	}
 }
 
+func (s *AuthTestSuite) TestRefresh() {
+	s.Run("Test Valid Refresh Token", func() {
+		s.SetupTest()
+		// First create a user and have it sign up.
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		rr := httptest.NewRecorder()
+		m := newRecordQueue()
+		signup(m, s.db)(rr, r)
+		refreshCookie := s.findCookie(rr.Result().Cookies(), "refresh_token")
+		s.Require().NotNil(refreshCookie, "signup did not set a refresh_token cookie")
+
+		// Exchange the refresh token for new tokens.
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+		r.AddCookie(refreshCookie)
+		rr = httptest.NewRecorder()
+		refresh(s.db)(rr, r)
+
+		s.Assert().Equal(http.StatusOK, rr.Result().StatusCode, "incorrect status code returned")
+		s.verifyLoginCookies(rr.Result().Cookies())
+
+		// The refresh token should have been rotated.
+		newRefreshCookie := s.findCookie(rr.Result().Cookies(), "refresh_token")
+		if s.Assert().NotNil(newRefreshCookie) {
+			s.Assert().NotEqual(refreshCookie.Value, newRefreshCookie.Value, "refresh token was not rotated")
+		}
+	})
+
+	s.Run("Test Reused Refresh Token", func() {
+		s.SetupTest()
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		rr := httptest.NewRecorder()
+		m := newRecordQueue()
+		signup(m, s.db)(rr, r)
+		refreshCookie := s.findCookie(rr.Result().Cookies(), "refresh_token")
+		s.Require().NotNil(refreshCookie, "signup did not set a refresh_token cookie")
+
+		// Use the refresh token once.
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+		r.AddCookie(refreshCookie)
+		rr = httptest.NewRecorder()
+		refresh(s.db)(rr, r)
+		s.Require().Equal(http.StatusOK, rr.Result().StatusCode, "incorrect status code returned")
+
+		// Using it a second time must fail.
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+		r.AddCookie(refreshCookie)
+		rr = httptest.NewRecorder()
+		refresh(s.db)(rr, r)
+		s.Assert().Equal(http.StatusUnauthorized, rr.Result().StatusCode, "incorrect status code returned")
+	})
+
+	s.Run("Test Access Token As Refresh Token", func() {
+		s.SetupTest()
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		rr := httptest.NewRecorder()
+		m := newRecordQueue()
+		signup(m, s.db)(rr, r)
+		accessCookie := s.findCookie(rr.Result().Cookies(), "access_token")
+		s.Require().NotNil(accessCookie, "signup did not set an access_token cookie")
+
+		// Pass the access token off as a refresh token.
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+		r.AddCookie(&http.Cookie{Name: "refresh_token", Value: accessCookie.Value})
+		rr = httptest.NewRecorder()
+		refresh(s.db)(rr, r)
+		s.Assert().Equal(http.StatusUnauthorized, rr.Result().StatusCode, "incorrect status code returned")
+	})
+}
+
+func (s *AuthTestSuite) TestRevocation() {
+	s.Run("Test Logout Revokes Session", func() {
+		s.SetupTest()
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		rr := httptest.NewRecorder()
+		m := newRecordQueue()
+		signup(m, s.db)(rr, r)
+		accessCookie := s.findCookie(rr.Result().Cookies(), "access_token")
+		refreshCookie := s.findCookie(rr.Result().Cookies(), "refresh_token")
+		s.Require().NotNil(accessCookie, "signup did not set an access_token cookie")
+		s.Require().NotNil(refreshCookie, "signup did not set a refresh_token cookie")
+
+		// Log out, keeping a copy of the cookies.
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/logout", nil)
+		r.AddCookie(accessCookie)
+		r.AddCookie(refreshCookie)
+		rr = httptest.NewRecorder()
+		logout(s.db)(rr, r)
+
+		// Neither token should be accepted anymore.
+		_, err := newValidator(s.db).Validate(accessCookie.Value)
+		s.Assert().Error(err, "access token still valid after logout")
+
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/refresh", nil)
+		r.AddCookie(refreshCookie)
+		rr = httptest.NewRecorder()
+		refresh(s.db)(rr, r)
+		s.Assert().Equal(http.StatusUnauthorized, rr.Result().StatusCode, "refresh token still valid after logout")
+	})
+
+	s.Run("Test Logout Everywhere", func() {
+		s.SetupTest()
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		rr := httptest.NewRecorder()
+		m := newRecordQueue()
+		signup(m, s.db)(rr, r)
+		firstAccess := s.findCookie(rr.Result().Cookies(), "access_token")
+		s.Require().NotNil(firstAccess, "signup did not set an access_token cookie")
+
+		// Sign in again to start a second session.
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/signin", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		rr = httptest.NewRecorder()
+		signin(s.db)(rr, r)
+		secondAccess := s.findCookie(rr.Result().Cookies(), "access_token")
+		s.Require().NotNil(secondAccess, "signin did not set an access_token cookie")
+
+		// Log out everywhere from the second session.
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/logout/all", nil)
+		r.AddCookie(secondAccess)
+		rr = httptest.NewRecorder()
+		auth.Middleware(newValidator(s.db))(logoutEverywhere(s.db)).ServeHTTP(rr, r)
+		s.Assert().Equal(http.StatusOK, rr.Result().StatusCode, "incorrect status code returned")
+
+		// Both sessions should be gone.
+		_, err := newValidator(s.db).Validate(firstAccess.Value)
+		s.Assert().Error(err, "first session still valid")
+		_, err = newValidator(s.db).Validate(secondAccess.Value)
+		s.Assert().Error(err, "second session still valid")
+	})
+
+	s.Run("Test Expired Tokens Are Purged", func() {
+		s.SetupTest()
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		m := newRecordQueue()
+		signup(m, s.db)(httptest.NewRecorder(), r)
+
+		// Only the token that has expired should go
+		_, err := s.db.Exec("UPDATE sessions SET expiresAt=DATE_SUB(UTC_TIMESTAMP(), INTERVAL 1 MINUTE) LIMIT 1")
+		s.Require().NoError(err)
+		purged, err := purgeExpiredTokens(context.Background(), s.db)
+		s.Require().NoError(err)
+		s.Assert().Equal(int64(1), purged)
+
+		var remaining int
+		err = s.db.QueryRow("SELECT COUNT(*) FROM sessions").Scan(&remaining)
+		s.Require().NoError(err)
+		s.Assert().Equal(1, remaining)
+	})
+}
+
 func (s *AuthTestSuite) TestVerify() {
 	s.Run("Test Valid Token", func() {
 		s.SetupTest()
 		// First create a user and have it sign up.
 		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr := httptest.NewRecorder()
-		m := newRecordMailer()
+		m := newRecordQueue()
 
 		// Sign up
 		signup(m, s.db)(rr, r)
@@ -287,10 +444,8 @@ func (s *AuthTestSuite) TestVerify() {
 			s.Assert().False(verified, "user started out verified already")
 		}
 
-		// Get verification token from database
-		var token string
-		err = s.db.QueryRow("SELECT verifiedToken FROM users WHERE email=?", s.testCreds.Email).Scan(&token)
-		s.Assert().NoError(err, "an error occurred while checking the database")
+		// Get verification token from the queued email, since the database only has its hash
+		token := m.token()
 
 		// Create a fake request and response to probe the function with
 		r = httptest.NewRequest(http.MethodPost, "/api/auth/verify", nil)
@@ -309,6 +464,33 @@ func (s *AuthTestSuite) TestVerify() {
 		}
 	})
 
+	s.Run("Test Email Link", func() {
+		s.SetupTest()
+		// Sign up, and follow the link in the email like a browser would
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		m := newRecordQueue()
+		signup(m, s.db)(httptest.NewRecorder(), r)
+
+		r = httptest.NewRequest(http.MethodGet, testLinks.Verify(m.token()), nil)
+		rr := httptest.NewRecorder()
+		verifyFromEmail(s.db, testLinks)(rr, r)
+
+		// The browser should be sent to the success page, and the user verified
+		s.Assert().Equal(http.StatusSeeOther, rr.Result().StatusCode, "incorrect status code returned")
+		s.Assert().Equal(testLinks.Verified(true), rr.Result().Header.Get("Location"), "redirected to the wrong page")
+		var verified bool
+		err := s.db.QueryRow("SELECT verified FROM users WHERE email=?", s.testCreds.Email).Scan(&verified)
+		if s.Assert().NoError(err) {
+			s.Assert().True(verified, "user was not verified")
+		}
+
+		// The same link doesn't work for a token nobody has
+		r = httptest.NewRequest(http.MethodGet, testLinks.Verify("bogusbogie123"), nil)
+		rr = httptest.NewRecorder()
+		verifyFromEmail(s.db, testLinks)(rr, r)
+		s.Assert().Equal(testLinks.Verified(false), rr.Result().Header.Get("Location"), "redirected to the wrong page")
+	})
+
 	s.Run("Test Invalid Token", func() {
 		s.SetupTest()
 		// Create a fake request and response to probe the function with
@@ -327,7 +509,7 @@ func (s *AuthTestSuite) TestVerify() {
 
 		// Make sure invalid token doesn't get stored in the database
 		var exists bool
-		err := s.db.QueryRow("SELECT EXISTS(SELECT * FROM users WHERE verifiedToken=?)", invalidToken).Scan(&exists)
+		err := s.db.QueryRow("SELECT EXISTS(SELECT * FROM userTokens WHERE tokenHash=?)", hashToken(invalidToken)).Scan(&exists)
 		if s.Assert().NoError(err, "an error occurred while checking the database") {
 			s.Assert().False(exists, "invalid token was saved in the database")
 		}
@@ -346,20 +528,20 @@ func (s *AuthTestSuite) TestReset() {
 		// First create a user and have it sign up.
 		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr := httptest.NewRecorder()
-		m := newRecordMailer()
+		m := newRecordQueue()
 
 		// Sign up
 		signup(m, s.db)(rr, r)
 
 		r = httptest.NewRequest(http.MethodPost, "/api/auth/sendreset", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr = httptest.NewRecorder()
-		m = newRecordMailer()
+		m = newRecordQueue()
 
 		// Make request
 		sendReset(m, s.db)(rr, r)
 
-		// Make sure that the mailer was called to send an email.
-		s.Assert().True(m.sendEmailCalled, "code did not call SendEmail with mailer")
+		// Make sure that the email was queued.
+		s.Assert().True(m.emailQueued, "code did not queue an email")
 	})
 
 	s.Run("Test sendReset Invalid Email", func() {
@@ -370,7 +552,7 @@ func (s *AuthTestSuite) TestReset() {
 			Password: "asdf",
 		})))
 		rr := httptest.NewRecorder()
-		m := newRecordMailer()
+		m := newRecordQueue()
 
 		// Make request
 		sendReset(m, s.db)(rr, r)
@@ -384,7 +566,7 @@ func (s *AuthTestSuite) TestReset() {
 		// First create a user and have it sign up.
 		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr := httptest.NewRecorder()
-		m := newRecordMailer()
+		m := newRecordQueue()
 
 		// Sign up
 		signup(m, s.db)(rr, r)
@@ -392,17 +574,15 @@ func (s *AuthTestSuite) TestReset() {
 		// Now call sendReset
 		r = httptest.NewRequest(http.MethodPost, "/api/auth/sendreset", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr = httptest.NewRecorder()
-		m = newRecordMailer()
+		m = newRecordQueue()
 
 		sendReset(m, s.db)(rr, r)
 
-		// Make sure that the mailer was called to send an email.
-		s.Assert().True(m.sendEmailCalled, "code did not call SendEmail with mailer")
+		// Make sure that the email was queued.
+		s.Assert().True(m.emailQueued, "code did not queue an email")
 
-		// Get reset token from database
-		var token string
-		err := s.db.QueryRow("SELECT resetToken FROM users WHERE email=?", s.testCreds.Email).Scan(&token)
-		s.Assert().NoError(err, "an error occurred while checking the database")
+		// Get reset token from the queued email, since the database only has its hash
+		token := m.token()
 
 		// Now make the request
 		r = httptest.NewRequest(http.MethodPost, "/api/auth/resetpw", bytes.NewBuffer(s.credsJSON(newPassCreds)))
@@ -415,11 +595,54 @@ func (s *AuthTestSuite) TestReset() {
 
 		// Make sure password was changed
 		var hashedPassword string
-		err = s.db.QueryRow("SELECT hashedPassword FROM users WHERE email=?", s.testCreds.Email).Scan(&hashedPassword)
+		err := s.db.QueryRow("SELECT hashedPassword FROM users WHERE email=?", s.testCreds.Email).Scan(&hashedPassword)
 		s.Assert().NoError(err, "an error occurred while checking the database")
 
 		err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(newPassCreds.Password))
 		s.Assert().NoError(err, "password hash check failed")
+
+		// The token only works once
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/resetpw", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		rr = httptest.NewRecorder()
+		r.URL.RawQuery = q.Encode()
+
+		resetPassword(s.db)(rr, r)
+
+		s.Assert().Equal(http.StatusBadRequest, rr.Result().StatusCode, "reused token was accepted")
+		err = s.db.QueryRow("SELECT hashedPassword FROM users WHERE email=?", s.testCreds.Email).Scan(&hashedPassword)
+		s.Assert().NoError(err, "an error occurred while checking the database")
+		err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(newPassCreds.Password))
+		s.Assert().NoError(err, "password was changed with a reused token")
+	})
+
+	s.Run("Test resetPassword Expired Token", func() {
+		s.SetupTest()
+		// Sign up and ask for a reset
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		signup(newRecordQueue(), s.db)(httptest.NewRecorder(), r)
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/sendreset", bytes.NewBuffer(s.credsJSON(s.testCreds)))
+		m := newRecordQueue()
+		sendReset(m, s.db)(httptest.NewRecorder(), r)
+
+		// Let the token run out
+		_, err := s.db.Exec("UPDATE userTokens SET expiresAt=DATE_SUB(NOW(3), INTERVAL 1 SECOND) WHERE tokenHash=?", hashToken(m.token()))
+		s.Require().NoError(err)
+
+		r = httptest.NewRequest(http.MethodPost, "/api/auth/resetpw", bytes.NewBuffer(s.credsJSON(newPassCreds)))
+		rr := httptest.NewRecorder()
+		q := url.Values{}
+		q.Add("token", m.token())
+		r.URL.RawQuery = q.Encode()
+
+		resetPassword(s.db)(rr, r)
+
+		// Make sure status code is correct and the password was not changed
+		s.Assert().Equal(http.StatusBadRequest, rr.Result().StatusCode, "incorrect status code returned")
+		var hashedPassword string
+		err = s.db.QueryRow("SELECT hashedPassword FROM users WHERE email=?", s.testCreds.Email).Scan(&hashedPassword)
+		s.Assert().NoError(err, "an error occurred while checking the database")
+		err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(s.testCreds.Password))
+		s.Assert().NoError(err, "password was changed with an expired token")
 	})
 
 	s.Run("Test resetPassword Invalid Token", func() {
@@ -427,7 +650,7 @@ func (s *AuthTestSuite) TestReset() {
 		// First create a user and have it sign up.
 		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr := httptest.NewRecorder()
-		m := newRecordMailer()
+		m := newRecordQueue()
 
 		// Sign up
 		signup(m, s.db)(rr, r)
@@ -435,12 +658,12 @@ func (s *AuthTestSuite) TestReset() {
 		// Now call sendReset
 		r = httptest.NewRequest(http.MethodPost, "/api/auth/sendreset", bytes.NewBuffer(s.credsJSON(s.testCreds)))
 		rr = httptest.NewRecorder()
-		m = newRecordMailer()
+		m = newRecordQueue()
 
 		sendReset(m, s.db)(rr, r)
 
-		// Make sure that the mailer was called to send an email.
-		s.Assert().True(m.sendEmailCalled, "code did not call SendEmail with mailer")
+		// Make sure that the email was queued.
+		s.Assert().True(m.emailQueued, "code did not queue an email")
 
 		// Now resetPassword
 		invalidToken := "hehehe"
@@ -477,9 +700,31 @@ type AuthTestSuite struct {
 // Clears the users database so the tests remain independent.
 func (s *AuthTestSuite) clearDatabase() (err error) {
 	_, err = s.db.Exec("TRUNCATE TABLE users")
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("TRUNCATE TABLE sessions")
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("TRUNCATE TABLE emailOutbox")
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec("TRUNCATE TABLE userTokens")
 	return err
 }
 
+// Returns the cookie with the given name, or nil if there isn't one.
+func (s *AuthTestSuite) findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
 // Returns true iff the cookie matches the expectations for signing up and signing in.
 func (s *AuthTestSuite) verifyCookie(c *http.Cookie) bool {
 	return (c.Name == "access_token" || c.Name == "refresh_token") &&
@@ -531,16 +776,25 @@ func (s *AuthTestSuite) SetupSuite() {
 	}
 }
 
-// Creates a Mailer that only records if SendEmail was called and does nothing else.
-type recordMailer struct {
-	sendEmailCalled bool
+// Creates an EmailQueue that only records if Enqueue was called, and the data of the last email queued,
+// and does nothing else.
+type recordQueue struct {
+	emailQueued bool
+	data        map[string]interface{}
 }
 
-func newRecordMailer() *recordMailer {
-	return &recordMailer{sendEmailCalled: false}
+func newRecordQueue() *recordQueue {
+	return &recordQueue{emailQueued: false}
 }
 
-func (m *recordMailer) SendEmail(recipient string, subject string, templatePath string, data map[string]interface{}) error {
-	m.sendEmailCalled = true
+func (m *recordQueue) Enqueue(tx *sql.Tx, recipient string, templateName string, data map[string]interface{}) error {
+	m.emailQueued = true
+	m.data = data
 	return nil
 }
+
+// Returns the token in the last email queued.
+func (m *recordQueue) token() string {
+	token, _ := m.data["Token"].(string)
+	return token
+}
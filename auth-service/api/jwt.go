@@ -1,9 +1,12 @@
 package api
 
 import (
-	"math/rand"
+	"database/sql"
+	"errors"
+	"fmt"
 	"time"
 
+	"github.com/BearCloud/sp21-bearchat/common/auth"
 	"github.com/dgrijalva/jwt-go"
 )
 
@@ -12,32 +15,48 @@ var (
 	DefaultAccessJWTExpiry = 1 * 1440 * time.Minute
 	// DefaultRefreshJWTExpiry is the default refresh token duration. It refreshes every 30 days.
 	DefaultRefreshJWTExpiry = 30 * 1440 * time.Minute
-	defaultJWTIssuer        = "CalChat"
-	jwtKey                  = []byte("my_secret_key")
+	defaultJWTIssuer        = auth.Issuer
 )
 
-// AuthClaims represents the claims in the access token
+// AuthClaims represents the claims in the access token. Every token also carries a unique ID in the
+// standard "jti" claim, which is what gets checked against the sessions table.
 type AuthClaims struct {
-	UserID string
+	UserID    string
+	SessionID string
 	jwt.StandardClaims
 }
 
 func setClaims(claims AuthClaims) (tokenString string, Error error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(jwtKey)
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = jwtKeys.signingKID
+	tokenString, err := token.SignedString(jwtKeys.signingKey)
 	if err != nil {
 		return "", err
 	}
 	return tokenString, err
 }
 
-// GetRandomBase62 returns a string of random base62 characters
-func GetRandomBase62(length int) string {
-	const base62 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
-	rand.Seed(time.Now().Unix())
-	r := make([]byte, length)
-	for i := range r {
-		r[i] = base62[rand.Intn(len(base62))]
+// parseClaims checks the signature, expiry and issuer of a token and returns its claims.
+func parseClaims(tokenString string) (*AuthClaims, error) {
+	claims := &AuthClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return jwtKeys.Key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid || !claims.VerifyIssuer(defaultJWTIssuer, true) {
+		return nil, errors.New("invalid token")
 	}
-	return string(r)
+	return claims, nil
+}
+
+// newValidator returns a validator for the shared auth middleware that checks tokens against our own
+// keys and the sessions table.
+func newValidator(DB *sql.DB) *auth.Validator {
+	return auth.NewValidator(jwtKeys, auth.SQLSessions{DB: DB})
 }
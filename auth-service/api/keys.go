@@ -0,0 +1,218 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Tokens are signed with RS256 so that other services only ever need our public keys to verify them.
+// Keys are identified by a key ID ("kid") that is stamped into the header of every token we sign.
+//
+// Keys can be configured in two ways:
+//
+//   - JWT_KEYS_DIR points at a directory of PEM files named <kid>.pem. Files holding a private key can
+//     be used for signing, files holding only a public key are kept around to verify old tokens.
+//     JWT_SIGNING_KID picks which private key signs new tokens.
+//   - JWT_PRIVATE_KEY holds a single PEM encoded private key, which is used for signing. Its kid is
+//     JWT_SIGNING_KID if set, otherwise it is derived from the key itself.
+//
+// To rotate keys, add the new key to JWT_KEYS_DIR and point JWT_SIGNING_KID at it. Keep the old key
+// (the public half is enough) in the directory until DefaultRefreshJWTExpiry has passed so tokens
+// that were signed with it keep validating until they expire.
+//
+// If neither is set, a throwaway key is generated so the service can still run locally. Tokens signed
+// with it stop working whenever the service restarts.
+
+// KeySet holds the key used to sign new tokens and every public key we still accept.
+type KeySet struct {
+	signingKID string
+	signingKey *rsa.PrivateKey
+	publicKeys map[string]*rsa.PublicKey
+}
+
+// jwtKeys is the KeySet used by setClaims and parseClaims. It is set up by InitKeys.
+var jwtKeys *KeySet
+
+// InitKeys loads the signing keys from the environment. It must be called before any tokens are
+// signed or verified.
+func InitKeys() error {
+	keys, err := LoadKeySet(os.Getenv("JWT_KEYS_DIR"), os.Getenv("JWT_PRIVATE_KEY"), os.Getenv("JWT_SIGNING_KID"))
+	if err != nil {
+		return err
+	}
+	jwtKeys = keys
+	return nil
+}
+
+// LoadKeySet builds a KeySet from a directory of PEM files and/or a single PEM encoded private key.
+func LoadKeySet(dir string, privateKeyPEM string, signingKID string) (*KeySet, error) {
+	keys := &KeySet{publicKeys: make(map[string]*rsa.PublicKey)}
+	privateKeys := make(map[string]*rsa.PrivateKey)
+
+	if dir != "" {
+		files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				return nil, err
+			}
+			kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+			private, public, err := parseKeyPEM(data)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", file, err)
+			}
+			if private != nil {
+				privateKeys[kid] = private
+			}
+			keys.publicKeys[kid] = public
+		}
+	}
+
+	if privateKeyPEM != "" {
+		private, _, err := parseKeyPEM([]byte(privateKeyPEM))
+		if err != nil {
+			return nil, fmt.Errorf("JWT_PRIVATE_KEY: %w", err)
+		}
+		if private == nil {
+			return nil, errors.New("JWT_PRIVATE_KEY does not contain a private key")
+		}
+		kid := signingKID
+		if kid == "" {
+			kid = thumbprint(&private.PublicKey)
+		}
+		privateKeys[kid] = private
+		keys.publicKeys[kid] = &private.PublicKey
+		signingKID = kid
+	}
+
+	if len(privateKeys) == 0 {
+		log.Println("no JWT signing key configured, generating a temporary one")
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		signingKID = thumbprint(&private.PublicKey)
+		privateKeys[signingKID] = private
+		keys.publicKeys[signingKID] = &private.PublicKey
+	}
+
+	// With a single private key there is no question about which one to sign with
+	if signingKID == "" && len(privateKeys) == 1 {
+		for kid := range privateKeys {
+			signingKID = kid
+		}
+	}
+	private, ok := privateKeys[signingKID]
+	if !ok {
+		return nil, fmt.Errorf("no private key found for signing kid %q", signingKID)
+	}
+	keys.signingKID = signingKID
+	keys.signingKey = private
+	return keys, nil
+}
+
+// Key returns the public key with the given kid.
+func (k *KeySet) Key(kid string) (*rsa.PublicKey, error) {
+	key, ok := k.publicKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// parseKeyPEM reads an RSA key from a PEM block. A private key is returned along with its public half.
+func parseKeyPEM(data []byte) (*rsa.PrivateKey, *rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, nil, errors.New("no PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		return private, &private.PublicKey, nil
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		private, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, nil, errors.New("private key is not an RSA key")
+		}
+		return private, &private.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		public, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		return nil, public, err
+	case "PUBLIC KEY":
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, nil, err
+		}
+		public, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, nil, errors.New("public key is not an RSA key")
+		}
+		return nil, public, nil
+	}
+	return nil, nil, fmt.Errorf("unsupported PEM block type %q", block.Type)
+}
+
+// thumbprint derives a stable key ID from a public key.
+func thumbprint(key *rsa.PublicKey) string {
+	sum := sha256.Sum256(x509.MarshalPKCS1PublicKey(key))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
+
+// JWK is a single RSA public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWKS returns every public key in the set in JSON Web Key Set format.
+func (k *KeySet) JWKS() map[string][]JWK {
+	keys := make([]JWK, 0, len(k.publicKeys))
+	for kid, public := range k.publicKeys {
+		keys = append(keys, JWK{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: "RS256",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		})
+	}
+	return map[string][]JWK{"keys": keys}
+}
+
+// jwks publishes the public keys so other services can verify our tokens.
+func jwks(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	json.NewEncoder(w).Encode(jwtKeys.JWKS())
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Makes sure tokens signed with a retired key keep validating after the signing key is rotated.
+func TestKeyRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	oldKey := writeTestKey(t, dir, "old")
+	writeTestKey(t, dir, "new")
+
+	// Sign a token with the old key.
+	oldKeys, err := LoadKeySet(dir, "", "old")
+	require.NoError(t, err)
+	jwtKeys = oldKeys
+	defer InitKeys()
+	oldToken, err := setClaims(testClaims())
+	require.NoError(t, err)
+
+	// Rotate to the new key, keeping only the public half of the old one.
+	require.NoError(t, os.Remove(filepath.Join(dir, "old.pem")))
+	publicDER, err := x509.MarshalPKIXPublicKey(&oldKey.PublicKey)
+	require.NoError(t, err)
+	writeTestPEM(t, filepath.Join(dir, "old.pem"), "PUBLIC KEY", publicDER)
+
+	newKeys, err := LoadKeySet(dir, "", "new")
+	require.NoError(t, err)
+	jwtKeys = newKeys
+
+	newToken, err := setClaims(testClaims())
+	require.NoError(t, err)
+	parsed, _ := jwt.Parse(newToken, nil)
+	assert.Equal(t, "new", parsed.Header["kid"], "new tokens should be signed with the new key")
+
+	// Both tokens still validate.
+	_, err = parseClaims(oldToken)
+	assert.NoError(t, err, "token signed with the old key no longer validates")
+	_, err = parseClaims(newToken)
+	assert.NoError(t, err, "token signed with the new key does not validate")
+
+	// And both keys are published.
+	kids := []string{}
+	for _, key := range newKeys.JWKS()["keys"] {
+		kids = append(kids, key.Kid)
+	}
+	assert.ElementsMatch(t, []string{"old", "new"}, kids)
+}
+
+// Makes sure a public key alone can't be chosen to sign tokens.
+func TestSigningKeyMustBePrivate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	key := writeTestKey(t, dir, "current")
+	publicDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	writeTestPEM(t, filepath.Join(dir, "retired.pem"), "PUBLIC KEY", publicDER)
+
+	_, err = LoadKeySet(dir, "", "retired")
+	assert.Error(t, err)
+}
+
+func testClaims() AuthClaims {
+	return AuthClaims{
+		UserID: "user",
+		StandardClaims: jwt.StandardClaims{
+			Subject:   "access",
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			Issuer:    defaultJWTIssuer,
+		},
+	}
+}
+
+func writeTestKey(t *testing.T, dir string, kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	writeTestPEM(t, filepath.Join(dir, kid+".pem"), "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key))
+	return key
+}
+
+func writeTestPEM(t *testing.T, path string, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// Links builds the absolute links we send users to, both in emails and when verify redirects a browser.
+// The API and the frontend can be served from different hosts, so each has its own.
+type Links struct {
+	// Scheme is "https", or "http" when running locally.
+	Scheme string
+	// Host is the host, and port if it isn't the default, that users reach the API at. The verification
+	// link in signup emails points here.
+	Host string
+	// FrontendHost is the host users reach the frontend at, which has the password reset form and the
+	// pages verify redirects to.
+	FrontendHost string
+}
+
+// NewLinks creates Links from the PUBLIC_SCHEME, PUBLIC_HOST and FRONTEND_HOST environment variables.
+// PUBLIC_SCHEME defaults to "https" and FRONTEND_HOST to PUBLIC_HOST.
+func NewLinks() (Links, error) {
+	l := Links{Scheme: os.Getenv("PUBLIC_SCHEME"), Host: os.Getenv("PUBLIC_HOST"), FrontendHost: os.Getenv("FRONTEND_HOST")}
+	if l.Scheme == "" {
+		l.Scheme = "https"
+	}
+	if l.FrontendHost == "" {
+		l.FrontendHost = l.Host
+	}
+	return l, l.validate()
+}
+
+// validate checks that the links made from l are absolute URLs.
+func (l Links) validate() error {
+	if l.Scheme != "https" && l.Scheme != "http" {
+		return fmt.Errorf("PUBLIC_SCHEME must be https or http, not %q", l.Scheme)
+	}
+	if l.Host == "" {
+		return errors.New("PUBLIC_HOST must be set so emails can link back to us")
+	}
+	for _, host := range []string{l.Host, l.FrontendHost} {
+		parsed, err := url.Parse(l.Scheme + "://" + host)
+		if err != nil || parsed.Host != host {
+			return fmt.Errorf("%q isn't a host", host)
+		}
+	}
+	return nil
+}
+
+// link returns the absolute URL of path on host, with the given query.
+func (l Links) link(host string, path string, query url.Values) string {
+	u := url.URL{Scheme: l.Scheme, Host: host, Path: path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// Verify returns the link that verifies the email of the user with the verification token.
+func (l Links) Verify(token string) string {
+	return l.link(l.Host, "/api/auth/verify", url.Values{"token": {token}})
+}
+
+// Reset returns the link to the frontend's form for resetting a password with the reset token.
+func (l Links) Reset(token string) string {
+	return l.link(l.FrontendHost, "/reset", url.Values{"token": {token}})
+}
+
+// Verified returns the frontend page a browser is sent to after following a verification link. ok
+// tells whether the email was verified.
+func (l Links) Verified(ok bool) string {
+	if ok {
+		return l.link(l.FrontendHost, "/verified", nil)
+	}
+	return l.link(l.FrontendHost, "/verified", url.Values{"error": {"invalid_token"}})
+}
+
+// addLink returns a copy of data with the Link field the named email needs, made from its Token.
+// Emails without a link are returned as they are.
+func (l Links) addLink(templateName string, data map[string]interface{}) (map[string]interface{}, error) {
+	var link func(string) string
+	switch templateName {
+	case "user-signup":
+		link = l.Verify
+	case "password-reset":
+		link = l.Reset
+	default:
+		return data, nil
+	}
+	token, ok := data["Token"].(string)
+	if !ok || token == "" {
+		return nil, fmt.Errorf("email %q needs a Token to link to", templateName)
+	}
+
+	linked := make(map[string]interface{}, len(data)+1)
+	for field, value := range data {
+		linked[field] = value
+	}
+	linked["Link"] = link(token)
+	return linked, nil
+}
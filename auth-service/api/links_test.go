@@ -0,0 +1,69 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Checks the links put in emails and the pages verify redirects to.
+func TestLinks(t *testing.T) {
+	assert.Equal(t, "https://api.bearchat.test/api/auth/verify?token=abc123", testLinks.Verify("abc123"))
+	assert.Equal(t, "https://bearchat.test/reset?token=a%2Bb%26c", testLinks.Reset("a+b&c"))
+	assert.Equal(t, "https://bearchat.test/verified", testLinks.Verified(true))
+	assert.Equal(t, "https://bearchat.test/verified?error=invalid_token", testLinks.Verified(false))
+
+	data := map[string]interface{}{"Token": "abc123"}
+	linked, err := testLinks.addLink("password-reset", data)
+	require.NoError(t, err)
+	assert.Equal(t, "https://bearchat.test/reset?token=abc123", linked["Link"])
+	assert.NotContains(t, data, "Link", "the queued data shouldn't be changed")
+
+	_, err = testLinks.addLink("user-signup", map[string]interface{}{})
+	assert.Error(t, err, "an email can't link anywhere without a token")
+}
+
+// Checks that NewLinks fills in defaults and refuses settings that wouldn't make absolute links.
+func TestNewLinks(t *testing.T) {
+	setEnv := func(scheme, host, frontendHost string) {
+		os.Setenv("PUBLIC_SCHEME", scheme)
+		os.Setenv("PUBLIC_HOST", host)
+		os.Setenv("FRONTEND_HOST", frontendHost)
+	}
+	defer setEnv("", "", "")
+
+	setEnv("", "bearchat.test", "")
+	links, err := NewLinks()
+	require.NoError(t, err)
+	assert.Equal(t, Links{Scheme: "https", Host: "bearchat.test", FrontendHost: "bearchat.test"}, links)
+
+	setEnv("http", "localhost", "localhost:3000")
+	links, err = NewLinks()
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:3000/reset?token=abc123", links.Reset("abc123"))
+
+	for _, env := range [][3]string{
+		{"https", "", ""},
+		{"ftp", "bearchat.test", ""},
+		{"https", "https://bearchat.test", ""},
+		{"https", "bearchat.test", "bearchat.test/app"},
+	} {
+		setEnv(env[0], env[1], env[2])
+		_, err = NewLinks()
+		assert.Error(t, err, "%v should be refused", env)
+	}
+}
+
+// Checks that a verification link that can't be redeemed sends the browser to the failure page.
+func TestVerifyFromEmailInvalid(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/auth/verify", nil)
+	rr := httptest.NewRecorder()
+	verifyFromEmail(nil, testLinks)(rr, r)
+
+	assert.Equal(t, http.StatusSeeOther, rr.Code)
+	assert.Equal(t, testLinks.Verified(false), rr.Header().Get("Location"))
+}
@@ -2,64 +2,374 @@ package api
 
 import (
 	"bytes"
-	"html/template"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/sendgrid/rest"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
 )
 
-// A Mailer is something that allows us to send emails that use an HTML template using the files in `/templates`.
-// Note our project is configured to only have a single Mailer which is from SendGrid, so why is
-// it that we made our code work with this interface instead of just using SendGrid directly?
+// senderName is the name emails are sent from.
+const senderName = "DevOps At Berkeley"
+
+// defaultSMTPTimeout is how long an SMTPMailer waits to send an email when it has no Timeout.
+const defaultSMTPTimeout = 30 * time.Second
+
+// sendGridTimeout is how long SendGrid gets to take an email. It is well under the outbox's Lease, so
+// an email is never still being sent when another worker picks it up again.
+const sendGridTimeout = 20 * time.Second
+
+// A Mailer is something that allows us to send emails made from the templates in `/templates` (see
+// templates.go). In production our emails go out through SendGrid, so why is it that we made our code
+// work with this interface instead of just using SendGrid directly?
 //
 // The answer is that it makes our code easier to extend in the future. If you wanted to add more
 // Mailers, then you only need to make some struct with a SendEmail method and your code will
-// handle it automatically. Additionally, this allows us to *mock* the Mailer in our tests
-// so we don't send actual emails out to people while testing (sometimes an API can charge or
-// ban you if you make too many requests). This is part of a software engineering technique
-// called a Dependency Injection.
+// handle it automatically. That is exactly how the SMTP and file Mailers below were added, so the
+// emails can be sent and read locally without a SendGrid key. Additionally, this allows us to *mock*
+// the Mailer in our tests so we don't send actual emails out to people while testing (sometimes an
+// API can charge or ban you if you make too many requests). This is part of a software engineering
+// technique called a Dependency Injection.
 type Mailer interface {
-	// SendEmail sends an email to the recipient with the specified subject
-	SendEmail(recipient string, subject string, templatePath string, data map[string]interface{}) error
+	// SendEmail sends the email made from the named template to the recipient, in the given locale
+	SendEmail(recipient string, locale string, templateName string, data map[string]interface{}) error
 }
 
 // A Struct that contains all the information needed to send an email using SendGrid.
 type SendGridMailer struct {
-	client *sendgrid.Client
-	sender *mail.Email
-	scheme string
+	client    *sendgrid.Client
+	http      *rest.Client
+	sender    *mail.Email
+	links     Links
+	templates *TemplateRegistry
 }
 
 // InitMailer initalizes the SendGrid client with default settings. Make sure to actually place a SENDGRID_KEY
 // into an .env file next to main.go so the code can log you in! Also add in a SENDER_EMAIL!
-func NewSendGridMailer() SendGridMailer {
+func NewSendGridMailer(templates *TemplateRegistry, links Links) SendGridMailer {
 	return SendGridMailer{sendgrid.NewSendClient(os.Getenv("SENDGRID_KEY")),
-		mail.NewEmail("DevOps At Berkeley", os.Getenv("SENDER_EMAIL")),
-		"http",
+		&rest.Client{HTTPClient: &http.Client{Timeout: sendGridTimeout}},
+		mail.NewEmail(senderName, os.Getenv("SENDER_EMAIL")),
+		links,
+		templates,
 	}
 }
 
 // This SendEmail function uses SendGrid to send an email.
-func (m SendGridMailer) SendEmail(recipient string, subject string, templatePath string, data map[string]interface{}) error {
-	// Parse template file and execute with data.
-	var html bytes.Buffer
-	tmpl, err := template.ParseFiles("./api/templates/" + templatePath)
+func (m SendGridMailer) SendEmail(recipient string, locale string, templateName string, data map[string]interface{}) error {
+	data, err := m.links.addLink(templateName, data)
 	if err != nil {
 		return err
 	}
-	err = tmpl.Execute(&html, data)
+	rendered, err := m.templates.Render(templateName, locale, data)
 	if err != nil {
 		return err
 	}
 
-	//turn our html page buffer into a string
-	plainTextContent := html.String()
 	recipientEmail := mail.NewEmail("recipient", recipient)
 
 	// Construct and send email via Sendgrid.
-	message := mail.NewSingleEmail(m.sender, subject, recipientEmail, plainTextContent, html.String())
+	message := mail.NewSingleEmail(m.sender, rendered.Subject, recipientEmail, rendered.Text, rendered.HTML)
+
+	request := m.client.Request
+	request.Body = mail.GetRequestBody(message)
+	response, err := m.http.Send(request)
+	if err != nil {
+		return err
+	}
+	// SendGrid turning the email down isn't an error to the client, only a status
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("SendGrid replied %d: %s", response.StatusCode, strings.TrimSpace(response.Body))
+	}
+	return nil
+}
+
+// NewMailer creates the Mailer selected by the MAILER environment variable:
+//
+//   - "sendgrid" (the default) sends emails through SendGrid, using SENDGRID_KEY.
+//   - "smtp" sends emails to the SMTP server at SMTP_ADDR, such as a local MailHog.
+//   - "file" writes emails to the maildir at MAIL_DIR, where they can be read without sending them.
+//
+// Every Mailer sends from SENDER_EMAIL, and makes its emails from templates, with links made by links.
+func NewMailer(templates *TemplateRegistry, links Links) (Mailer, error) {
+	switch backend := os.Getenv("MAILER"); backend {
+	case "", "sendgrid":
+		return NewSendGridMailer(templates, links), nil
+	case "smtp":
+		return NewSMTPMailer(templates, links)
+	case "file":
+		return NewFileMailer(templates, links)
+	default:
+		return nil, fmt.Errorf("unknown MAILER backend %q", backend)
+	}
+}
+
+// An SMTPMailer sends emails to an SMTP server. It upgrades the connection with STARTTLS whenever the
+// server supports it, and logs in if it has a username.
+type SMTPMailer struct {
+	// Addr is the host:port of the SMTP server.
+	Addr string
+	// Username and Password log in to the server with PLAIN auth. Leave Username empty for servers that
+	// don't need a login. The password is only ever sent over TLS, or to a server on localhost.
+	Username string
+	Password string
+	// RequireTLS refuses to send anything to a server that doesn't support STARTTLS.
+	RequireTLS bool
+	// TLSConfig is used for STARTTLS. If it is nil, the server's certificate is checked against the
+	// host in Addr.
+	TLSConfig *tls.Config
+	// Timeout is the most time connecting to the server and sending one email can take, so a server
+	// that stops answering can't hold up the outbox. It defaults to defaultSMTPTimeout.
+	Timeout   time.Duration
+	Sender    string
+	Links     Links
+	Templates *TemplateRegistry
+}
+
+// NewSMTPMailer creates an SMTPMailer from the SMTP_ADDR, SMTP_USERNAME, SMTP_PASSWORD and
+// SMTP_REQUIRE_TLS environment variables. Set SMTP_REQUIRE_TLS to "true" for any server that isn't
+// running locally.
+func NewSMTPMailer(templates *TemplateRegistry, links Links) (*SMTPMailer, error) {
+	addr := os.Getenv("SMTP_ADDR")
+	if addr == "" {
+		return nil, errors.New("SMTP_ADDR must be set to use the smtp mailer")
+	}
+	return &SMTPMailer{
+		Addr:       addr,
+		Username:   os.Getenv("SMTP_USERNAME"),
+		Password:   os.Getenv("SMTP_PASSWORD"),
+		RequireTLS: os.Getenv("SMTP_REQUIRE_TLS") == "true",
+		Sender:     os.Getenv("SENDER_EMAIL"),
+		Links:      links,
+		Templates:  templates,
+	}, nil
+}
+
+// SendEmail renders the template and sends it to the SMTP server.
+func (m *SMTPMailer) SendEmail(recipient string, locale string, templateName string, data map[string]interface{}) error {
+	data, err := m.Links.addLink(templateName, data)
+	if err != nil {
+		return err
+	}
+	message, err := renderMessage(m.Templates, m.Sender, recipient, locale, templateName, data)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+	timeout := m.Timeout
+	if timeout == 0 {
+		timeout = defaultSMTPTimeout
+	}
+	conn, err := net.DialTimeout("tcp", m.Addr, timeout)
+	if err != nil {
+		return err
+	}
+	err = conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		config := m.TLSConfig
+		if config == nil {
+			config = &tls.Config{ServerName: host}
+		}
+		err = c.StartTLS(config)
+		if err != nil {
+			return err
+		}
+	} else if m.RequireTLS {
+		return fmt.Errorf("smtp server %s doesn't support STARTTLS", m.Addr)
+	}
+
+	if m.Username != "" {
+		err = c.Auth(smtp.PlainAuth("", m.Username, m.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(m.Sender)
+	if err != nil {
+		return err
+	}
+	err = c.Rcpt(recipient)
+	if err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(message)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// A FileMailer writes each email, as a .eml file, into the maildir at Dir instead of sending it. Mail
+// clients can open the maildir directly, and tests can read the files to find links in the emails.
+type FileMailer struct {
+	Dir       string
+	Sender    string
+	Links     Links
+	Templates *TemplateRegistry
+}
+
+// NewFileMailer creates a FileMailer that writes to the MAIL_DIR environment variable, creating the
+// maildir if it doesn't exist yet.
+func NewFileMailer(templates *TemplateRegistry, links Links) (*FileMailer, error) {
+	dir := os.Getenv("MAIL_DIR")
+	if dir == "" {
+		return nil, errors.New("MAIL_DIR must be set to use the file mailer")
+	}
+	m := &FileMailer{Dir: dir, Sender: os.Getenv("SENDER_EMAIL"), Links: links, Templates: templates}
+	return m, m.init()
+}
+
+// init creates the tmp, new and cur directories of the maildir.
+func (m *FileMailer) init() error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(m.Dir, sub), 0o755)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SendEmail renders the template into a file in the maildir's new directory. The file is written to
+// tmp first and then moved, so a reader never sees half an email.
+func (m *FileMailer) SendEmail(recipient string, locale string, templateName string, data map[string]interface{}) error {
+	data, err := m.Links.addLink(templateName, data)
+	if err != nil {
+		return err
+	}
+	message, err := renderMessage(m.Templates, m.Sender, recipient, locale, templateName, data)
+	if err != nil {
+		return err
+	}
+	err = m.init()
+	if err != nil {
+		return err
+	}
+
+	id, err := randomHex(8)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d.%s.eml", time.Now().UnixNano(), id)
+	tmp := filepath.Join(m.Dir, "tmp", name)
+	err = os.WriteFile(tmp, message, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(m.Dir, "new", name))
+}
+
+// renderMessage renders the named email and wraps it in the headers of an email from sender to
+// recipient, ready to be sent over SMTP or saved as a .eml file. The email has both the plain-text and
+// the HTML version, for mail clients to pick from.
+func renderMessage(templates *TemplateRegistry, sender string, recipient string, locale string, templateName string, data map[string]interface{}) ([]byte, error) {
+	rendered, err := templates.Render(templateName, locale, data)
+	if err != nil {
+		return nil, err
+	}
+
+	// Anything that ends up in a header can't be allowed to start a header of its own
+	for _, field := range []string{sender, recipient, rendered.Subject} {
+		if strings.ContainsAny(field, "\r\n") {
+			return nil, errors.New("email headers can't contain line breaks")
+		}
+	}
 
-	_, err = m.client.Send(message)
-	return err
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(sender, "@"); at >= 0 {
+		domain = sender[at+1:]
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=UTF-8", rendered.Text},
+		{"text/html; charset=UTF-8", rendered.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded := quotedprintable.NewWriter(w)
+		_, err = encoded.Write([]byte(part.content))
+		if err != nil {
+			return nil, err
+		}
+		err = encoded.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = parts.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	fmt.Fprintf(&message, "From: %s\r\n", mime.QEncoding.Encode("utf-8", senderName)+" <"+sender+">")
+	fmt.Fprintf(&message, "To: %s\r\n", recipient)
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", rendered.Subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&message, "Message-ID: <%s@%s>\r\n", id, domain)
+	fmt.Fprintf(&message, "Content-Language: %s\r\n", rendered.Locale)
+	message.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+	body.WriteTo(&message)
+	return message.Bytes(), nil
+}
+
+// randomHex returns n random bytes as a hex string.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
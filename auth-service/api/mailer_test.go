@@ -0,0 +1,245 @@
+package api
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	netmail "net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sendgrid/sendgrid-go"
+	"github.com/sendgrid/sendgrid-go/helpers/mail"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The links test emails are made with.
+var testLinks = Links{Scheme: "https", Host: "api.bearchat.test", FrontendHost: "bearchat.test"}
+
+// Loads the embedded templates.
+func loadTemplates(t *testing.T) *TemplateRegistry {
+	templates, err := LoadTemplates()
+	require.NoError(t, err)
+	return templates
+}
+
+// Parses an email and returns its decoded parts, by content type.
+func readEmail(t *testing.T, r io.Reader) (*netmail.Message, map[string]string) {
+	message, err := netmail.ReadMessage(r)
+	require.NoError(t, err)
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	require.NoError(t, err)
+	require.Equal(t, "multipart/alternative", mediaType)
+
+	parts := map[string]string{}
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return message, parts
+		}
+		require.NoError(t, err)
+		// The multipart reader decodes quoted-printable parts itself
+		body, err := io.ReadAll(part)
+		require.NoError(t, err)
+		parts[part.Header.Get("Content-Type")] = string(body)
+	}
+}
+
+// smtpSession is what a fakeSMTP server was told by a client.
+type smtpSession struct {
+	auth string
+	from string
+	to   string
+	data string
+}
+
+// fakeSMTP accepts one SMTP connection on a local port and records what the client sent. It doesn't
+// support STARTTLS. The session is sent on the returned channel once the client quits.
+func fakeSMTP(t *testing.T) (string, <-chan smtpSession) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	sessions := make(chan smtpSession, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(line string) { io.WriteString(conn, line+"\r\n") }
+
+		session := smtpSession{}
+		reply("220 localhost fake SMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			command := strings.TrimRight(line, "\r\n")
+			switch verb := strings.ToUpper(strings.SplitN(command, " ", 2)[0]); verb {
+			case "EHLO":
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				fields := strings.Fields(command)
+				decoded, _ := base64.StdEncoding.DecodeString(fields[len(fields)-1])
+				session.auth = string(decoded)
+				reply("235 authenticated")
+			case "MAIL":
+				session.from = command
+				reply("250 ok")
+			case "RCPT":
+				session.to = command
+				reply("250 ok")
+			case "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				session.data = data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				sessions <- session
+				return
+			default:
+				reply("502 unknown command")
+			}
+		}
+	}()
+	return listener.Addr().String(), sessions
+}
+
+// fakeSendGrid creates a SendGridMailer that sends to a local server, which answers every email with
+// status and body. The server counts the emails it was sent.
+func fakeSendGrid(t *testing.T, status int, body string) (SendGridMailer, *int) {
+	received := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(server.Close)
+
+	m := NewSendGridMailer(loadTemplates(t), testLinks)
+	m.client = sendgrid.NewSendClient("test-key")
+	m.client.BaseURL = server.URL + "/v3/mail/send"
+	m.sender = mail.NewEmail(senderName, "noreply@bearchat.test")
+	return m, &received
+}
+
+// Checks that emails SendGrid takes are sent, and that the ones it turns down are errors.
+func TestSendGridMailer(t *testing.T) {
+	m, received := fakeSendGrid(t, http.StatusAccepted, "")
+	err := m.SendEmail("oski@berkeley.edu", "en", "user-signup", map[string]interface{}{"Token": "abc123"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, *received)
+
+	m, _ = fakeSendGrid(t, http.StatusBadRequest, `{"errors":[{"message":"The from address does not match a verified Sender Identity."}]}`)
+	err = m.SendEmail("oski@berkeley.edu", "en", "user-signup", map[string]interface{}{"Token": "abc123"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "400")
+		assert.Contains(t, err.Error(), "verified Sender Identity")
+	}
+}
+
+// Checks that emails are sent to an SMTP server, logging in when there is a username.
+func TestSMTPMailer(t *testing.T) {
+	addr, sessions := fakeSMTP(t)
+
+	m := &SMTPMailer{Addr: addr, Username: "oski", Password: "gobears", Sender: "noreply@bearchat.test", Links: testLinks, Templates: loadTemplates(t)}
+	err := m.SendEmail("oski@berkeley.edu", "en", "user-signup", map[string]interface{}{"Token": "abc123"})
+	require.NoError(t, err)
+
+	session := <-sessions
+	assert.Equal(t, "\x00oski\x00gobears", session.auth)
+	assert.Equal(t, "MAIL FROM:<noreply@bearchat.test>", session.from)
+	assert.Equal(t, "RCPT TO:<oski@berkeley.edu>", session.to)
+	message, parts := readEmail(t, strings.NewReader(session.data))
+	assert.Equal(t, "oski@berkeley.edu", message.Header.Get("To"))
+	assert.Equal(t, "Email Verification", message.Header.Get("Subject"))
+	assert.Contains(t, parts["text/plain; charset=UTF-8"], "https://api.bearchat.test/api/auth/verify?token=abc123")
+	assert.Contains(t, parts["text/html; charset=UTF-8"], `href="https://api.bearchat.test/api/auth/verify?token=abc123"`)
+}
+
+// Checks that an SMTPMailer that requires TLS won't send to a server without STARTTLS.
+func TestSMTPMailerRequireTLS(t *testing.T) {
+	addr, _ := fakeSMTP(t)
+
+	m := &SMTPMailer{Addr: addr, RequireTLS: true, Sender: "noreply@bearchat.test", Links: testLinks, Templates: loadTemplates(t)}
+	err := m.SendEmail("oski@berkeley.edu", "en", "user-signup", map[string]interface{}{"Token": "abc123"})
+	assert.Error(t, err)
+}
+
+// Checks that a server that stops answering makes SendEmail give up instead of waiting forever.
+func TestSMTPMailerTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		// Accept the connection but never greet the client
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			io.Copy(io.Discard, conn)
+		}
+	}()
+
+	m := &SMTPMailer{Addr: listener.Addr().String(), Timeout: 50 * time.Millisecond, Sender: "noreply@bearchat.test", Links: testLinks, Templates: loadTemplates(t)}
+	start := time.Now()
+	err = m.SendEmail("oski@berkeley.edu", "en", "user-signup", map[string]interface{}{"Token": "abc123"})
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+// Checks that emails are written into the maildir's new directory.
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	os.Setenv("MAIL_DIR", dir)
+	defer os.Unsetenv("MAIL_DIR")
+	m, err := NewFileMailer(loadTemplates(t), testLinks)
+	require.NoError(t, err)
+
+	err = m.SendEmail("oski@berkeley.edu", "es", "password-reset", map[string]interface{}{"Token": "abc123"})
+	require.NoError(t, err)
+
+	files, err := filepath.Glob(filepath.Join(dir, "new", "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	f, err := os.Open(files[0])
+	require.NoError(t, err)
+	defer f.Close()
+	message, parts := readEmail(t, f)
+	subject, err := new(mime.WordDecoder).DecodeHeader(message.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Restablecer contraseña de BearChat", subject)
+	assert.Equal(t, "es", message.Header.Get("Content-Language"))
+	assert.Contains(t, parts["text/plain; charset=UTF-8"], "Restablece tu contraseña")
+	assert.Contains(t, parts["text/html; charset=UTF-8"], `href="https://bearchat.test/reset?token=abc123"`)
+
+	// Nothing is left behind in tmp
+	leftover, err := os.ReadDir(filepath.Join(dir, "tmp"))
+	require.NoError(t, err)
+	assert.Empty(t, leftover)
+
+	// Line breaks can't be used to add headers of their own
+	err = m.SendEmail("oski@berkeley.edu\r\nBcc: stanfurd@stanford.edu", "en", "password-reset", map[string]interface{}{"Token": "abc123"})
+	assert.Error(t, err)
+}
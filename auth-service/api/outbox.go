@@ -0,0 +1,302 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/gorilla/mux"
+)
+
+// The states an email in the outbox can be in. Every email starts out pending, and is either sent or,
+// once it has failed too many times, given up on and left in the outbox as failed.
+const (
+	emailPending = "pending"
+	emailSent    = "sent"
+	emailFailed  = "failed"
+)
+
+const (
+	// defaultFailedEmails is how many failed emails are listed when the request doesn't give a limit.
+	defaultFailedEmails = 50
+	// maxFailedEmails is the most failed emails that can be listed at once.
+	maxFailedEmails = 500
+)
+
+// An EmailQueue holds emails until they can be sent. Emails are queued as part of a transaction, so an
+// email is only ever sent if the changes it tells the user about were committed, and those changes are
+// never committed without the email.
+type EmailQueue interface {
+	// Enqueue queues the email made from the named template to the recipient, to be sent once tx
+	// commits.
+	Enqueue(tx *sql.Tx, recipient string, templateName string, data map[string]interface{}) error
+}
+
+// An Outbox is an EmailQueue kept in the emailOutbox table. Requests only ever write to the table, and
+// Run sends the emails in the background, so a slow or broken Mailer never holds up a request. Emails
+// that fail to send are retried with exponential backoff until MaxAttempts is reached, after which
+// they are marked as failed and stay in the table for an admin to look at.
+//
+// The template data of an email holds the token it delivers, which is only ever stored hashed
+// anywhere else. So the data is cleared as soon as the email is sent or given up on, and rows that
+// are done with are deleted once they are older than Retention.
+type Outbox struct {
+	DB     *sql.DB
+	Mailer Mailer
+	// MaxAttempts is how many times an email is tried before it is given up on.
+	MaxAttempts int
+	// MinBackoff is how long to wait before retrying an email that failed once. The wait doubles with
+	// each failure after that, up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// PollInterval is how often Run looks for emails that are due.
+	PollInterval time.Duration
+	// BatchSize is the most emails sent in one go.
+	BatchSize int
+	// Lease is how long an email is set aside for the worker sending it. If the worker dies partway,
+	// the email is picked up again once the lease runs out.
+	Lease time.Duration
+	// Retention is how long sent and failed emails are kept before Purge deletes them.
+	Retention time.Duration
+}
+
+// NewOutbox creates an Outbox that stores emails in db and sends them with m.
+func NewOutbox(db *sql.DB, m Mailer) *Outbox {
+	return &Outbox{
+		DB:           db,
+		Mailer:       m,
+		MaxAttempts:  8,
+		MinBackoff:   30 * time.Second,
+		MaxBackoff:   time.Hour,
+		PollInterval: 5 * time.Second,
+		BatchSize:    20,
+		Lease:        time.Minute,
+		Retention:    30 * 24 * time.Hour,
+	}
+}
+
+// A FailedEmail is an email the outbox gave up on. The template data is left out, since it holds the
+// tokens the email was meant to deliver.
+type FailedEmail struct {
+	ID        int64     `json:"id"`
+	Recipient string    `json:"recipient"`
+	Locale    string    `json:"locale"`
+	Template  string    `json:"template"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// outboxEmail is a pending email read from the outbox.
+type outboxEmail struct {
+	id        int64
+	recipient string
+	locale    string
+	template  string
+	data      string
+	attempts  int
+}
+
+// Enqueue stores the email in the outbox as part of tx, ready to be sent straight away. It is sent in
+// the locale of the user with the recipient's email, as tx sees them, or in DefaultLocale if there is
+// no such user.
+func (o *Outbox) Enqueue(tx *sql.Tx, recipient string, templateName string, data map[string]interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO emailOutbox (recipient, locale, template, data, status, attempts, nextAttempt) "+
+		"VALUES (?, COALESCE((SELECT locale FROM users WHERE email=? LIMIT 1), ?), ?, ?, ?, 0, NOW(3))",
+		recipient, recipient, DefaultLocale, templateName, string(encoded), emailPending)
+	return err
+}
+
+// Run sends the emails that are due, and purges old ones, every PollInterval until ctx is done.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.PollInterval)
+	defer ticker.Stop()
+	for {
+		_, err := o.SendDue(ctx)
+		if err != nil {
+			log.Print(err.Error())
+		}
+		_, err = o.Purge(ctx)
+		if err != nil {
+			log.Print(err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendDue sends up to BatchSize of the pending emails whose next attempt is due, and returns how many
+// of them were sent.
+func (o *Outbox) SendDue(ctx context.Context) (int, error) {
+	rows, err := o.DB.QueryContext(ctx, "SELECT id, recipient, locale, template, data, attempts FROM emailOutbox "+
+		"WHERE status=? AND nextAttempt <= NOW(3) ORDER BY nextAttempt, id LIMIT ?", emailPending, o.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	due := []outboxEmail{}
+	for rows.Next() {
+		email := outboxEmail{}
+		err = rows.Scan(&email.id, &email.recipient, &email.locale, &email.template, &email.data, &email.attempts)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		due = append(due, email)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, email := range due {
+		claimed, err := o.claim(ctx, email)
+		if err != nil {
+			return sent, err
+		}
+		if !claimed {
+			continue
+		}
+
+		err = o.send(email)
+		if err != nil {
+			log.Printf("sending email %d failed: %s", email.id, err.Error())
+			err = o.retryLater(ctx, email, err)
+		} else {
+			sent++
+			_, err = o.DB.ExecContext(ctx, "UPDATE emailOutbox SET status=?, sentAt=NOW(3), data=NULL WHERE id=?", emailSent, email.id)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// claim sets the email aside for Lease, so no other worker sends it at the same time. It reports false
+// if another worker got to the email first.
+func (o *Outbox) claim(ctx context.Context, email outboxEmail) (bool, error) {
+	result, err := o.DB.ExecContext(ctx, "UPDATE emailOutbox SET nextAttempt=DATE_ADD(NOW(3), INTERVAL ? MICROSECOND) "+
+		"WHERE id=? AND status=? AND attempts=? AND nextAttempt <= NOW(3)", o.Lease.Microseconds(), email.id, emailPending, email.attempts)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := result.RowsAffected()
+	return claimed == 1, err
+}
+
+// send renders and sends the email with the Mailer.
+func (o *Outbox) send(email outboxEmail) error {
+	data := map[string]interface{}{}
+	err := json.Unmarshal([]byte(email.data), &data)
+	if err != nil {
+		return err
+	}
+	return o.Mailer.SendEmail(email.recipient, email.locale, email.template, data)
+}
+
+// retryLater records a failed attempt at sending the email. The email is tried again after the
+// backoff, unless it has used up all its attempts, in which case it is marked as failed.
+func (o *Outbox) retryLater(ctx context.Context, email outboxEmail, sendErr error) error {
+	attempts := email.attempts + 1
+	if attempts >= o.MaxAttempts {
+		_, err := o.DB.ExecContext(ctx, "UPDATE emailOutbox SET status=?, attempts=?, lastError=?, data=NULL WHERE id=?",
+			emailFailed, attempts, sendErr.Error(), email.id)
+		return err
+	}
+	_, err := o.DB.ExecContext(ctx, "UPDATE emailOutbox SET attempts=?, lastError=?, nextAttempt=DATE_ADD(NOW(3), INTERVAL ? MICROSECOND) WHERE id=?",
+		attempts, sendErr.Error(), o.backoff(attempts).Microseconds(), email.id)
+	return err
+}
+
+// Purge deletes the sent and failed emails that are older than Retention, and returns how many it
+// deleted.
+func (o *Outbox) Purge(ctx context.Context) (int64, error) {
+	result, err := o.DB.ExecContext(ctx, "DELETE FROM emailOutbox WHERE status IN (?, ?) AND createdAt < DATE_SUB(NOW(3), INTERVAL ? MICROSECOND)",
+		emailSent, emailFailed, o.Retention.Microseconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// backoff returns how long to wait before retrying an email that has failed attempts times.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	wait := o.MinBackoff
+	for i := 1; i < attempts && wait < o.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > o.MaxBackoff {
+		return o.MaxBackoff
+	}
+	return wait
+}
+
+// Failed returns up to limit of the emails the outbox gave up on, most recent first.
+func (o *Outbox) Failed(ctx context.Context, limit int) ([]FailedEmail, error) {
+	// The connection doesn't parse times, so createdAt is read as seconds since the epoch
+	rows, err := o.DB.QueryContext(ctx, "SELECT id, recipient, locale, template, attempts, COALESCE(lastError, ''), UNIX_TIMESTAMP(createdAt) "+
+		"FROM emailOutbox WHERE status=? ORDER BY id DESC LIMIT ?", emailFailed, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	failed := []FailedEmail{}
+	for rows.Next() {
+		email := FailedEmail{}
+		var createdAt float64
+		err = rows.Scan(&email.ID, &email.Recipient, &email.Locale, &email.Template, &email.Attempts, &email.LastError, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		email.CreatedAt = time.Unix(0, int64(createdAt*float64(time.Second))).UTC().Truncate(time.Millisecond)
+		failed = append(failed, email)
+	}
+	return failed, rows.Err()
+}
+
+// RegisterAdminRoutes maps the admin endpoints onto handlers. They only run for authenticated requests
+// from one of the users in admins.
+func RegisterAdminRoutes(router *mux.Router, db *sql.DB, o *Outbox, admins []string) {
+	authenticate := auth.Middleware(newValidator(db))
+	authorize := func(next http.Handler) http.Handler { return authenticate(auth.AdminMiddleware(admins)(next)) }
+
+	router.Handle("/api/auth/admin/outbox/failed", authorize(failedEmails(o))).Methods(http.MethodGet)
+}
+
+// failedEmails lists the emails the outbox gave up on, up to the limit query parameter.
+func failedEmails(o *Outbox) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultFailedEmails
+		if value := r.URL.Query().Get("limit"); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed < 1 || parsed > maxFailedEmails {
+				http.Error(w, "limit must be between 1 and "+strconv.Itoa(maxFailedEmails), http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+
+		failed, err := o.Failed(r.Context(), limit)
+		if err != nil {
+			http.Error(w, "error listing failed emails", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(failed)
+	}
+}
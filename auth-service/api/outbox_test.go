@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// A Mailer that fails whenever fail is set, and counts the emails it sent.
+type flakyMailer struct {
+	fail bool
+	sent int
+}
+
+func (m *flakyMailer) SendEmail(recipient string, locale string, templateName string, data map[string]interface{}) error {
+	if m.fail {
+		return errors.New("mail server is down")
+	}
+	m.sent++
+	return nil
+}
+
+// Checks that the wait between attempts doubles, up to the maximum.
+func TestOutboxBackoff(t *testing.T) {
+	o := &Outbox{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	for attempts, want := range map[int]time.Duration{1: 1, 2: 2, 3: 4, 4: 8, 5: 10, 6: 10} {
+		assert.Equal(t, want*time.Second, o.backoff(attempts), "wrong backoff after %d attempts", attempts)
+	}
+	assert.Equal(t, 10*time.Second, o.backoff(100))
+}
+
+// Checks that queued emails are only sent once committed, and are retried until they're given up on.
+func (s *AuthTestSuite) TestOutbox() {
+	ctx := context.Background()
+	m := &flakyMailer{}
+	o := NewOutbox(s.db, m)
+	o.MaxAttempts = 2
+	o.MinBackoff = 0
+
+	// Queues an email in a transaction and either commits or rolls it back.
+	enqueue := func(recipient string, commit bool) {
+		tx, err := s.db.Begin()
+		s.Require().NoError(err)
+		s.Require().NoError(o.Enqueue(tx, recipient, "user-signup", map[string]interface{}{"Token": "abc123"}))
+		if commit {
+			s.Require().NoError(tx.Commit())
+		} else {
+			s.Require().NoError(tx.Rollback())
+		}
+	}
+
+	s.Run("Test Rolled Back Emails Are Never Sent", func() {
+		s.SetupTest()
+		enqueue("oski@berkeley.edu", false)
+		sent, err := o.SendDue(ctx)
+		s.Require().NoError(err)
+		s.Assert().Equal(0, sent)
+	})
+
+	s.Run("Test Emails Are Sent Once", func() {
+		s.SetupTest()
+		enqueue("oski@berkeley.edu", true)
+		sent, err := o.SendDue(ctx)
+		s.Require().NoError(err)
+		s.Assert().Equal(1, sent)
+		sent, err = o.SendDue(ctx)
+		s.Require().NoError(err)
+		s.Assert().Equal(0, sent, "a sent email shouldn't be sent again")
+		s.assertNoTokens()
+	})
+
+	s.Run("Test Failed Emails", func() {
+		s.SetupTest()
+		m.fail = true
+		defer func() { m.fail = false }()
+		enqueue("stanfurd@stanford.edu", true)
+
+		// The first failure is retried, and the second one is given up on
+		for i := 0; i < 2; i++ {
+			sent, err := o.SendDue(ctx)
+			s.Require().NoError(err)
+			s.Assert().Equal(0, sent)
+		}
+		failed, err := o.Failed(ctx, 10)
+		s.Require().NoError(err)
+		if s.Assert().Len(failed, 1) {
+			s.Assert().Equal("stanfurd@stanford.edu", failed[0].Recipient)
+			s.Assert().Equal(2, failed[0].Attempts)
+			s.Assert().Equal("mail server is down", failed[0].LastError)
+		}
+
+		s.assertNoTokens()
+
+		m.fail = false
+		sent, err := o.SendDue(ctx)
+		s.Require().NoError(err)
+		s.Assert().Equal(0, sent, "failed emails shouldn't be retried")
+	})
+
+	s.Run("Test SendGrid Errors Are Retried", func() {
+		s.SetupTest()
+		sg, received := fakeSendGrid(s.T(), http.StatusServiceUnavailable, "try again later")
+		o := NewOutbox(s.db, sg)
+		o.MaxAttempts = 2
+		o.MinBackoff = time.Hour
+		enqueue("oski@berkeley.edu", true)
+
+		// The first failure waits out the backoff instead of being marked as sent
+		sent, err := o.SendDue(ctx)
+		s.Require().NoError(err)
+		s.Assert().Equal(0, sent)
+		var status string
+		var attempts int
+		var waiting bool
+		err = s.db.QueryRow("SELECT status, attempts, nextAttempt > NOW(3) FROM emailOutbox").Scan(&status, &attempts, &waiting)
+		s.Require().NoError(err)
+		s.Assert().Equal(emailPending, status)
+		s.Assert().Equal(1, attempts)
+		s.Assert().True(waiting, "a failed email should wait before it is retried")
+
+		sent, err = o.SendDue(ctx)
+		s.Require().NoError(err)
+		s.Assert().Equal(0, sent)
+		s.Assert().Equal(1, *received, "the email was retried before its backoff ran out")
+
+		// Once the backoff is over, the second failure gives up on it
+		_, err = s.db.Exec("UPDATE emailOutbox SET nextAttempt=NOW(3)")
+		s.Require().NoError(err)
+		_, err = o.SendDue(ctx)
+		s.Require().NoError(err)
+		s.Assert().Equal(2, *received)
+		failed, err := o.Failed(ctx, 10)
+		s.Require().NoError(err)
+		if s.Assert().Len(failed, 1) {
+			s.Assert().Equal("SendGrid replied 503: try again later", failed[0].LastError)
+		}
+	})
+
+	s.Run("Test Old Emails Are Purged", func() {
+		s.SetupTest()
+		enqueue("oski@berkeley.edu", true)
+		enqueue("stanfurd@stanford.edu", true)
+		_, err := o.SendDue(ctx)
+		s.Require().NoError(err)
+		enqueue("bruin@ucla.edu", true)
+
+		// Only the sent email that is past Retention should go, not the recent or the pending ones
+		_, err = s.db.Exec("UPDATE emailOutbox SET createdAt=DATE_SUB(NOW(3), INTERVAL 31 DAY) WHERE recipient IN (?, ?)",
+			"oski@berkeley.edu", "bruin@ucla.edu")
+		s.Require().NoError(err)
+		purged, err := o.Purge(ctx)
+		s.Require().NoError(err)
+		s.Assert().Equal(int64(1), purged)
+
+		var remaining int
+		err = s.db.QueryRow("SELECT COUNT(*) FROM emailOutbox").Scan(&remaining)
+		s.Require().NoError(err)
+		s.Assert().Equal(2, remaining)
+	})
+}
+
+// Checks that no email that is done with still holds the token it was sent with.
+func (s *AuthTestSuite) assertNoTokens() {
+	var leaked bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT * FROM emailOutbox WHERE status != ? AND data IS NOT NULL)", emailPending).Scan(&leaked)
+	if s.Assert().NoError(err) {
+		s.Assert().False(leaked, "an email that is done with still holds its token")
+	}
+}
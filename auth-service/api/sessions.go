@@ -0,0 +1,75 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Every token we mint is recorded in the sessions table along with the session it belongs to. A
+// session starts at signup or signin and lives on through every refresh, so revoking a session
+// invalidates all of the tokens that were ever handed out for it. Once a token has expired it is
+// rejected whatever its row says, so PurgeSessions deletes the rows of expired tokens.
+
+// recordTokens stores newly minted tokens as active.
+func recordTokens(DB *sql.DB, tokens ...AuthClaims) error {
+	for _, claims := range tokens {
+		_, err := DB.Exec("INSERT INTO sessions (tokenId, sessionId, userId, expiresAt, revoked) VALUES (?, ?, ?, ?, FALSE)",
+			claims.Id, claims.SessionID, claims.UserID, time.Unix(claims.ExpiresAt, 0))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeToken revokes a single token and reports whether it was still active beforehand.
+func revokeToken(DB *sql.DB, tokenID string) (bool, error) {
+	result, err := DB.Exec("UPDATE sessions SET revoked=TRUE WHERE tokenId=? AND revoked=FALSE", tokenID)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	return rows > 0, err
+}
+
+// revokeSession revokes every token issued for a session.
+func revokeSession(DB *sql.DB, sessionID string) error {
+	_, err := DB.Exec("UPDATE sessions SET revoked=TRUE WHERE sessionId=?", sessionID)
+	return err
+}
+
+// revokeUserSessions revokes every token issued to a user, across all of their sessions.
+func revokeUserSessions(DB *sql.DB, userID string) error {
+	_, err := DB.Exec("UPDATE sessions SET revoked=TRUE WHERE userId=?", userID)
+	return err
+}
+
+// purgeExpiredTokens deletes the tokens that have expired, and returns how many it deleted. expiresAt
+// is written by the driver in UTC, so it is compared with UTC_TIMESTAMP rather than NOW.
+func purgeExpiredTokens(ctx context.Context, DB *sql.DB) (int64, error) {
+	result, err := DB.ExecContext(ctx, "DELETE FROM sessions WHERE expiresAt < UTC_TIMESTAMP()")
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// PurgeSessions deletes expired tokens from the sessions table every interval until ctx is done.
+func PurgeSessions(ctx context.Context, DB *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		_, err := purgeExpiredTokens(ctx, DB)
+		if err != nil {
+			log.Print(err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package api
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+)
+
+// DefaultLocale is the locale emails are sent in when a template has no version in the user's locale.
+const DefaultLocale = "en"
+
+// templateFiles holds the email templates. Each email has an HTML and a plain-text version for every
+// locale it is translated into, named <name>.<locale>.html and <name>.<locale>.txt. The plain-text
+// version also defines the email's subject, in a template called "subject".
+//
+//go:embed templates/*
+var templateFiles embed.FS
+
+// templateFields lists every email and the fields of the data it must be rendered with. A template that
+// isn't listed here, or that doesn't use every one of its fields, fails LoadTemplates. Link is added by
+// the Mailer from the Token the email is queued with (see Links).
+var templateFields = map[string][]string{
+	"user-signup":    {"Link"},
+	"password-reset": {"Link"},
+}
+
+// templateName matches the file names of templates, capturing the email's name, locale and kind.
+var templateName = regexp.MustCompile(`^([a-z-]+)\.([a-z]{2,8})\.(html|txt)$`)
+
+// A renderedEmail is a template rendered for one recipient, ready to be sent. Locale is the locale it was
+// rendered in.
+type renderedEmail struct {
+	Locale  string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// localizedTemplate is an email's templates in one locale.
+type localizedTemplate struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// A TemplateRegistry holds every email template, parsed once when it is loaded.
+type TemplateRegistry struct {
+	templates map[string]map[string]*localizedTemplate // name -> locale -> templates
+}
+
+// LoadTemplates parses the embedded email templates and checks them. Every email needs an HTML and a
+// plain-text version in each of its locales, including DefaultLocale, and each version must render
+// using exactly the fields in templateFields.
+func LoadTemplates() (*TemplateRegistry, error) {
+	sub, err := fs.Sub(templateFiles, "templates")
+	if err != nil {
+		return nil, err
+	}
+	return parseTemplates(sub)
+}
+
+// parseTemplates parses and checks the email templates in files.
+func parseTemplates(files fs.FS) (*TemplateRegistry, error) {
+	paths, err := fs.Glob(files, "*")
+	if err != nil {
+		return nil, err
+	}
+
+	r := &TemplateRegistry{templates: make(map[string]map[string]*localizedTemplate)}
+	for _, path := range paths {
+		match := templateName.FindStringSubmatch(path)
+		if match == nil {
+			return nil, fmt.Errorf("template %s isn't named <name>.<locale>.html or <name>.<locale>.txt", path)
+		}
+		name, locale, kind := match[1], match[2], match[3]
+		if _, ok := templateFields[name]; !ok {
+			return nil, fmt.Errorf("template %s is for an unknown email %q", path, name)
+		}
+		if r.templates[name] == nil {
+			r.templates[name] = make(map[string]*localizedTemplate)
+		}
+		if r.templates[name][locale] == nil {
+			r.templates[name][locale] = &localizedTemplate{}
+		}
+
+		content, err := fs.ReadFile(files, path)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "html" {
+			r.templates[name][locale].html, err = htmltemplate.New(path).Option("missingkey=error").Parse(string(content))
+		} else {
+			r.templates[name][locale].text, err = texttemplate.New(path).Option("missingkey=error").Parse(string(content))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for name, fields := range templateFields {
+		if r.templates[name][DefaultLocale] == nil {
+			return nil, fmt.Errorf("email %q has no %s template", name, DefaultLocale)
+		}
+		for locale := range r.templates[name] {
+			err = r.check(name, locale, fields)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return r, nil
+}
+
+// check renders one email in one locale with a sample value for each of its fields, and makes sure
+// every value shows up in both versions of the email.
+func (r *TemplateRegistry) check(name string, locale string, fields []string) error {
+	t := r.templates[name][locale]
+	if t.html == nil || t.text == nil {
+		return fmt.Errorf("email %q needs both an HTML and a plain-text template in locale %s", name, locale)
+	}
+	if t.text.Lookup("subject") == nil {
+		return fmt.Errorf("email %q has no subject in locale %s", name, locale)
+	}
+
+	data := map[string]interface{}{}
+	for _, field := range fields {
+		data[field] = "sample" + field
+	}
+	rendered, err := r.Render(name, locale, data)
+	if err != nil {
+		return err
+	}
+	for _, field := range fields {
+		if !strings.Contains(rendered.HTML, "sample"+field) || !strings.Contains(rendered.Text, "sample"+field) {
+			return fmt.Errorf("email %q in locale %s doesn't use its %s field", name, locale, field)
+		}
+	}
+	return nil
+}
+
+// Render renders the named email in locale, or in DefaultLocale if it hasn't been translated into
+// locale. data must hold every field the email needs.
+func (r *TemplateRegistry) Render(name string, locale string, data map[string]interface{}) (renderedEmail, error) {
+	localized, ok := r.templates[name]
+	if !ok {
+		return renderedEmail{}, fmt.Errorf("unknown email %q", name)
+	}
+	for _, field := range templateFields[name] {
+		if _, ok := data[field]; !ok {
+			return renderedEmail{}, fmt.Errorf("email %q needs a %s", name, field)
+		}
+	}
+	t, ok := localized[locale]
+	if !ok {
+		locale, t = DefaultLocale, localized[DefaultLocale]
+	}
+
+	var subject, html, text bytes.Buffer
+	err := t.text.ExecuteTemplate(&subject, "subject", data)
+	if err != nil {
+		return renderedEmail{}, err
+	}
+	err = t.html.Execute(&html, data)
+	if err != nil {
+		return renderedEmail{}, err
+	}
+	err = t.text.Execute(&text, data)
+	if err != nil {
+		return renderedEmail{}, err
+	}
+	return renderedEmail{Locale: locale, Subject: strings.TrimSpace(subject.String()), HTML: html.String(), Text: text.String()}, nil
+}
+
+// requestLocale returns the language the request's Accept-Language header prefers most, as a locale
+// like "en" or "es", or DefaultLocale if it doesn't name one. Whether there are templates in that
+// locale is only checked when an email is rendered.
+func requestLocale(r *http.Request) string {
+	best, bestQuality := DefaultLocale, 0.0
+	for _, option := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		parts := strings.Split(strings.TrimSpace(option), ";")
+		locale := strings.ToLower(strings.SplitN(parts[0], "-", 2)[0])
+		quality := 1.0
+		for _, param := range parts[1:] {
+			if value := strings.TrimSpace(param); strings.HasPrefix(value, "q=") {
+				parsed, err := strconv.ParseFloat(strings.TrimPrefix(value, "q="), 64)
+				if err == nil {
+					quality = parsed
+				}
+			}
+		}
+		if isLocale(locale) && quality > bestQuality {
+			best, bestQuality = locale, quality
+		}
+	}
+	return best
+}
+
+// isLocale reports whether locale looks like a language subtag, such as "en".
+func isLocale(locale string) bool {
+	if len(locale) < 2 || len(locale) > 8 {
+		return false
+	}
+	for _, c := range locale {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
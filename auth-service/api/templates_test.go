@@ -0,0 +1,91 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Checks that emails are rendered in the locale asked for, falling back to the default.
+func TestRenderTemplates(t *testing.T) {
+	templates := loadTemplates(t)
+	data := map[string]interface{}{"Link": "https://bearchat.test/api/auth/verify?token=abc123"}
+
+	rendered, err := templates.Render("user-signup", "es", data)
+	require.NoError(t, err)
+	assert.Equal(t, "es", rendered.Locale)
+	assert.Equal(t, "Verificación de correo", rendered.Subject)
+	assert.Contains(t, rendered.Text, "https://bearchat.test/api/auth/verify?token=abc123")
+	assert.NotContains(t, rendered.Text, "<", "the plain-text version shouldn't have HTML in it")
+	assert.Contains(t, rendered.HTML, "Necesitamos que verifiques tu correo")
+
+	rendered, err = templates.Render("user-signup", "fr", data)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultLocale, rendered.Locale)
+	assert.Equal(t, "Email Verification", rendered.Subject)
+
+	_, err = templates.Render("user-signup", "en", map[string]interface{}{})
+	assert.Error(t, err, "rendering without the link should fail")
+	_, err = templates.Render("welcome", "en", data)
+	assert.Error(t, err)
+}
+
+// Checks that broken templates are caught when they are loaded.
+func TestParseTemplatesInvalid(t *testing.T) {
+	// Returns a complete set of templates with the given files replaced.
+	files := func(replace map[string]string) fstest.MapFS {
+		fs := fstest.MapFS{}
+		for _, name := range []string{"user-signup", "password-reset"} {
+			fs[name+".en.html"] = &fstest.MapFile{Data: []byte("<a href=\"{{.Link}}\">x</a>")}
+			fs[name+".en.txt"] = &fstest.MapFile{Data: []byte("{{define \"subject\"}}Hi{{end}}{{.Link}}")}
+		}
+		for name, content := range replace {
+			if content == "" {
+				delete(fs, name)
+			} else {
+				fs[name] = &fstest.MapFile{Data: []byte(content)}
+			}
+		}
+		return fs
+	}
+
+	_, err := parseTemplates(files(nil))
+	require.NoError(t, err)
+
+	for name, replace := range map[string]map[string]string{
+		"Unknown Email":    {"welcome.en.html": "hi"},
+		"Bad File Name":    {"user-signup.html": "hi"},
+		"Missing Text":     {"user-signup.es.html": "{{.Link}}"},
+		"Missing Default":  {"password-reset.en.html": "", "password-reset.en.txt": ""},
+		"Missing Subject":  {"user-signup.en.txt": "{{.Link}}"},
+		"Unused Field":     {"user-signup.en.html": "no token here"},
+		"Unknown Field":    {"user-signup.en.txt": "{{define \"subject\"}}Hi{{end}}{{.Link}} {{.Username}}"},
+		"Invalid Template": {"user-signup.en.html": "{{.Link"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseTemplates(files(replace))
+			assert.Error(t, err)
+		})
+	}
+}
+
+// Checks that the locale is picked from the Accept-Language header by preference.
+func TestRequestLocale(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                        DefaultLocale,
+		"es":                      "es",
+		"es-MX,es;q=0.9,en;q=0.8": "es",
+		"en;q=0.5, es;q=0.9":      "es",
+		"*":                       DefaultLocale,
+		"fr-CA, *;q=0.5":          "fr",
+		"../../etc;q=1, es;q=0.1": "es",
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/auth/signup", nil)
+		r.Header.Set("Accept-Language", header)
+		assert.Equal(t, want, requestLocale(r), "wrong locale for %q", header)
+	}
+}
@@ -0,0 +1,107 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// What a token in the userTokens table can be redeemed for. A token only works for the purpose it was
+// issued for, so a reset token can't be used to verify an email and the other way around.
+const (
+	verifyPurpose = "verify"
+	resetPurpose  = "reset"
+)
+
+const (
+	// tokenBytes is how many random bytes go into a token. 32 bytes is as much as a SHA-256 hash holds,
+	// so tokens can't be guessed and two tokens never collide.
+	tokenBytes = 32
+	// verifyTokenTTL is how long a verification link works for.
+	verifyTokenTTL = 48 * time.Hour
+	// resetTokenTTL is how long a password reset link works for.
+	resetTokenTTL = time.Hour
+)
+
+// errBadToken is wrapped by every error redeemToken returns for a token that can't be redeemed.
+// Handlers should reply to these with a 400 Bad Request.
+var errBadToken = errors.New("invalid token")
+
+var (
+	errTokenUnknown = fmt.Errorf("%w: no such token", errBadToken)
+	errTokenExpired = fmt.Errorf("%w: token has expired", errBadToken)
+	errTokenUsed    = fmt.Errorf("%w: token was already used", errBadToken)
+)
+
+// newToken returns a new random token, made from tokenBytes bytes of crypto/rand and encoded so it can
+// go in a URL as it is.
+func newToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the hash a token is stored under. Only hashes are stored, so someone who can read
+// the database still can't verify emails or reset passwords. Tokens are random enough that a fast hash
+// is as good as bcrypt here, and it lets tokens be looked up by their hash.
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// issueToken creates a token for purpose that belongs to the user and expires after ttl, stores its
+// hash as part of tx, and returns the token. Any token the user was issued for purpose before is
+// revoked, so only the latest email works.
+func issueToken(tx *sql.Tx, userID string, purpose string, ttl time.Duration) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("DELETE FROM userTokens WHERE userId=? AND purpose=?", userID, purpose)
+	if err != nil {
+		return "", err
+	}
+	_, err = tx.Exec("INSERT INTO userTokens (tokenHash, userId, purpose, expiresAt) VALUES (?, ?, ?, DATE_ADD(NOW(3), INTERVAL ? MICROSECOND))",
+		hashToken(token), userID, purpose, ttl.Microseconds())
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// redeemToken uses up the token for purpose as part of tx, and returns the ID of the user it belongs
+// to. A token can only be redeemed once: the row is locked until tx ends, so if two requests redeem the
+// same token at once, the second sees it was used. The error wraps errBadToken if the token doesn't
+// exist, has expired or was already used.
+func redeemToken(tx *sql.Tx, purpose string, token string) (string, error) {
+	var userID string
+	var expired, used bool
+	err := tx.QueryRow("SELECT userId, expiresAt <= NOW(3), usedAt IS NOT NULL FROM userTokens WHERE tokenHash=? AND purpose=? FOR UPDATE",
+		hashToken(token), purpose).Scan(&userID, &expired, &used)
+	if err == sql.ErrNoRows {
+		return "", errTokenUnknown
+	}
+	if err != nil {
+		return "", err
+	}
+	if used {
+		return "", errTokenUsed
+	}
+	if expired {
+		return "", errTokenExpired
+	}
+
+	_, err = tx.Exec("UPDATE userTokens SET usedAt=NOW(3) WHERE tokenHash=?", hashToken(token))
+	if err != nil {
+		return "", err
+	}
+	return userID, nil
+}
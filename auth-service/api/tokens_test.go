@@ -0,0 +1,118 @@
+package api
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Checks that tokens are long, safe to put in a URL, and never repeat.
+func TestNewToken(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 10000; i++ {
+		token, err := newToken()
+		require.NoError(t, err)
+		require.False(t, seen[token], "token %s was generated twice", token)
+		seen[token] = true
+
+		decoded, err := base64.RawURLEncoding.DecodeString(token)
+		require.NoError(t, err)
+		assert.Len(t, decoded, tokenBytes)
+	}
+}
+
+// Checks that tokens are stored under a hash that doesn't give the token away.
+func TestHashToken(t *testing.T) {
+	token, err := newToken()
+	require.NoError(t, err)
+	assert.Equal(t, hashToken(token), hashToken(token))
+	assert.Len(t, hashToken(token), 64)
+	assert.NotContains(t, hashToken(token), token)
+	assert.NotEqual(t, hashToken(token), hashToken(token+"x"))
+}
+
+func (s *AuthTestSuite) TestTokens() {
+	// Issues a token for purpose in its own transaction.
+	issue := func(userID string, purpose string, ttl time.Duration) string {
+		tx, err := s.db.Begin()
+		s.Require().NoError(err)
+		defer tx.Rollback()
+		token, err := issueToken(tx, userID, purpose, ttl)
+		s.Require().NoError(err)
+		s.Require().NoError(tx.Commit())
+		return token
+	}
+	// Redeems a token for purpose in its own transaction.
+	redeem := func(purpose string, token string) (string, error) {
+		tx, err := s.db.Begin()
+		s.Require().NoError(err)
+		defer tx.Rollback()
+		userID, err := redeemToken(tx, purpose, token)
+		if err != nil {
+			return "", err
+		}
+		return userID, tx.Commit()
+	}
+
+	s.Run("Test No Collisions", func() {
+		s.SetupTest()
+		// Used to be seeded with the time, so signups in the same second got the same token
+		tokens := map[string]string{}
+		for i := 0; i < 20; i++ {
+			userID := uuid.New().String()
+			token := issue(userID, verifyPurpose, time.Hour)
+			s.Require().NotContains(tokens, token, "two users were issued the same token")
+			tokens[token] = userID
+		}
+		for token, userID := range tokens {
+			redeemed, err := redeem(verifyPurpose, token)
+			s.Require().NoError(err)
+			s.Assert().Equal(userID, redeemed, "token redeemed for the wrong user")
+		}
+	})
+
+	s.Run("Test Single Use", func() {
+		s.SetupTest()
+		token := issue("oski", resetPurpose, time.Hour)
+		_, err := redeem(verifyPurpose, token)
+		s.Assert().ErrorIs(err, errTokenUnknown, "token worked for the wrong purpose")
+
+		userID, err := redeem(resetPurpose, token)
+		s.Require().NoError(err)
+		s.Assert().Equal("oski", userID)
+		_, err = redeem(resetPurpose, token)
+		s.Assert().ErrorIs(err, errTokenUsed)
+		s.Assert().ErrorIs(err, errBadToken)
+	})
+
+	s.Run("Test Expiry", func() {
+		s.SetupTest()
+		token := issue("oski", verifyPurpose, -time.Second)
+		_, err := redeem(verifyPurpose, token)
+		s.Assert().ErrorIs(err, errTokenExpired)
+
+		// A failed redemption doesn't use the token up, so it still reads as expired
+		_, err = redeem(verifyPurpose, token)
+		s.Assert().ErrorIs(err, errTokenExpired)
+	})
+
+	s.Run("Test Reissue Revokes", func() {
+		s.SetupTest()
+		first := issue("oski", resetPurpose, time.Hour)
+		second := issue("oski", resetPurpose, time.Hour)
+		_, err := redeem(resetPurpose, first)
+		s.Assert().ErrorIs(err, errTokenUnknown, "earlier token still works")
+		_, err = redeem(resetPurpose, second)
+		s.Assert().NoError(err)
+
+		// Tokens for other purposes are left alone
+		verify := issue("oski", verifyPurpose, time.Hour)
+		issue("oski", resetPurpose, time.Hour)
+		_, err = redeem(verifyPurpose, verify)
+		s.Assert().NoError(err, "issuing a reset token revoked the verify token")
+	})
+}
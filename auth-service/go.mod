@@ -3,14 +3,17 @@ module github.com/BearCloud/sp21-bearchat/auth-service
 go 1.16
 
 require (
+	github.com/BearCloud/sp21-bearchat/common v0.0.0-00010101000000-000000000000
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/go-sql-driver/mysql v1.6.0
 	github.com/google/uuid v1.2.0
 	github.com/gorilla/mux v1.8.0
 	github.com/joho/godotenv v1.3.0
-	github.com/sendgrid/rest v2.6.3+incompatible // indirect
+	github.com/sendgrid/rest v2.6.3+incompatible
 	github.com/sendgrid/sendgrid-go v3.8.0+incompatible
 	github.com/stretchr/testify v1.7.0
 	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	golang.org/x/net v0.0.0-20210405180319-a5a99cb37ef4 // indirect
 )
+
+replace github.com/BearCloud/sp21-bearchat/common => ../common
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/BearCloud/sp21-bearchat/auth-service/api"
+	"github.com/BearCloud/sp21-bearchat/common/auth"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 )
@@ -16,8 +19,29 @@ func main() {
 		log.Fatal(err.Error())
 	}
 
-	// Initialize the sendgrid client
-	mailer := api.NewSendGridMailer()
+	// Load the keys used to sign access and refresh tokens
+	err = api.InitKeys()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// Parse the email templates, making sure every email can be rendered before anything is sent
+	templates, err := api.LoadTemplates()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// Work out where links in emails should point, from PUBLIC_SCHEME, PUBLIC_HOST and FRONTEND_HOST
+	links, err := api.NewLinks()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
+	// Initialize the mailer picked by MAILER, which sends through SendGrid unless told otherwise
+	mailer, err := api.NewMailer(templates, links)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 
 	// Initialize our database connection
 	db := api.InitDB()
@@ -30,12 +54,22 @@ func main() {
 		panic(err.Error())
 	}
 
+	// Send queued emails in the background, so requests never wait on the mailer
+	outbox := api.NewOutbox(db, mailer)
+	go outbox.Run(context.Background())
+
+	// Delete the sessions table's rows for tokens that have expired, so it doesn't grow forever
+	go api.PurgeSessions(context.Background(), db, time.Hour)
+
 	// Create a new mux for routing api calls
 	router := mux.NewRouter()
 	router.Use(CORS)
 	router.Methods(http.MethodOptions)
 
-	api.RegisterRoutes(router, mailer, db)
+	api.RegisterRoutes(router, outbox, db, links)
+
+	// Admin endpoints are only for the users listed in AUTH_ADMINS
+	api.RegisterAdminRoutes(router, db, outbox, auth.Admins("AUTH_ADMINS"))
 
 	log.Println("starting go server")
 	http.ListenAndServe(":80", router)
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Admins returns the IDs of the users allowed to call a service's admin endpoints, which are listed in
+// the comma separated environment variable named envVar.
+func Admins(envVar string) []string {
+	admins := []string{}
+	for _, admin := range strings.Split(os.Getenv(envVar), ",") {
+		if admin = strings.TrimSpace(admin); admin != "" {
+			admins = append(admins, admin)
+		}
+	}
+	return admins
+}
+
+// AdminMiddleware returns a mux middleware that only lets requests from one of admins through. Anyone
+// else gets a 403 Forbidden. It must run inside Middleware, which works out who the request is from.
+func AdminMiddleware(admins []string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			for _, admin := range admins {
+				if admin == UserID(r) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			http.Error(w, "admins only", http.StatusForbidden)
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmins(t *testing.T) {
+	os.Setenv("TEST_ADMINS", " oski, ,stanfurd ")
+	defer os.Unsetenv("TEST_ADMINS")
+	assert.Equal(t, []string{"oski", "stanfurd"}, Admins("TEST_ADMINS"))
+	assert.Empty(t, Admins("UNSET_ADMINS"))
+}
+
+func TestAdminMiddleware(t *testing.T) {
+	handler := AdminMiddleware([]string{"oski"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for userID, want := range map[string]int{"oski": http.StatusOK, "stanfurd": http.StatusForbidden, "": http.StatusForbidden} {
+		r := httptest.NewRequest(http.MethodGet, "/admin", nil)
+		r = r.WithContext(NewContext(r.Context(), &AuthClaims{UserID: userID}))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		assert.Equal(t, want, rr.Code, "wrong status for %q", userID)
+	}
+}
@@ -0,0 +1,42 @@
+// Package auth lets BearChat services authenticate requests using the access tokens minted by the
+// auth service.
+package auth
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// AuthClaims represents the claims in the tokens minted by the auth service.
+type AuthClaims struct {
+	UserID    string
+	SessionID string
+	jwt.StandardClaims
+}
+
+type contextKey int
+
+const claimsKey contextKey = 0
+
+// NewContext returns a copy of ctx carrying the given claims.
+func NewContext(ctx context.Context, claims *AuthClaims) context.Context {
+	return context.WithValue(ctx, claimsKey, claims)
+}
+
+// ClaimsFromContext returns the claims stored in ctx by Middleware, if any.
+func ClaimsFromContext(ctx context.Context) (*AuthClaims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*AuthClaims)
+	return claims, ok
+}
+
+// UserID returns the ID of the user who made an authenticated request, or "" if the request didn't
+// go through Middleware.
+func UserID(r *http.Request) string {
+	claims, ok := ClaimsFromContext(r.Context())
+	if !ok {
+		return ""
+	}
+	return claims.UserID
+}
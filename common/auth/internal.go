@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+)
+
+// InternalTokenHeader is the header services put the shared internal token in when they call each
+// other's internal endpoints.
+const InternalTokenHeader = "X-Internal-Token"
+
+// InternalToken returns the INTERNAL_TOKEN environment variable, the secret shared by every service.
+func InternalToken() string {
+	return os.Getenv("INTERNAL_TOKEN")
+}
+
+// InternalMiddleware returns a mux middleware that only lets through requests from other services,
+// which carry token in InternalTokenHeader. Any other request gets a 401 Unauthorized. If token is
+// empty, every request is rejected, so a service that isn't configured can't be called by anyone.
+func InternalMiddleware(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			given := r.Header.Get(InternalTokenHeader)
+			if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				http.Error(w, "invalid internal token", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInternalMiddleware(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name       string
+		configured string
+		given      string
+		code       int
+	}{
+		{"Matching Token", "secret", "secret", http.StatusOK},
+		{"Wrong Token", "secret", "guess", http.StatusUnauthorized},
+		{"Missing Token", "secret", "", http.StatusUnauthorized},
+		{"Not Configured", "", "", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/internal", nil)
+			if test.given != "" {
+				r.Header.Set(InternalTokenHeader, test.given)
+			}
+			rr := httptest.NewRecorder()
+			InternalMiddleware(test.configured)(ok).ServeHTTP(rr, r)
+			assert.Equal(t, test.code, rr.Code)
+		})
+	}
+}
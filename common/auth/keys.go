@@ -0,0 +1,160 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultJWKSURL is where the auth service publishes the public keys it signs tokens with.
+const DefaultJWKSURL = "http://172.28.1.1/.well-known/jwks.json"
+
+const (
+	// How long fetched keys are trusted before they are fetched again.
+	jwksCacheTTL = 5 * time.Minute
+	// How often an unknown kid is allowed to trigger a fetch, so bogus tokens can't hammer the auth service.
+	// Failed fetches count too, so an unreachable auth service isn't retried on every request.
+	jwksMinRefresh = 30 * time.Second
+)
+
+// KeyCache fetches the auth service's public keys and caches them by key ID. When the auth service
+// rotates its signing key, tokens with the new kid trigger a refetch while keys that are still
+// published keep working.
+type KeyCache struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	// triedAt is when the last fetch started, whether or not it worked.
+	triedAt time.Time
+	// fetching is closed once the fetch that is running finishes. It is nil when no fetch is running.
+	fetching chan struct{}
+}
+
+// NewKeyCache creates a KeyCache for the JWKS published at url.
+func NewKeyCache(url string) *KeyCache {
+	return &KeyCache{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+// JWKSURL returns the JWKS_URL environment variable, or DefaultJWKSURL if it isn't set.
+func JWKSURL() string {
+	if url := os.Getenv("JWKS_URL"); url != "" {
+		return url
+	}
+	return DefaultJWKSURL
+}
+
+// Key returns the public key with the given kid, fetching the key set if needed. Only one fetch runs at
+// a time: lookups of an unknown kid wait for the fetch that is running instead of starting their own,
+// and lookups of a cached kid never wait.
+func (c *KeyCache) Key(kid string) (*rsa.PublicKey, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key, ok := c.keys[kid]
+	if ok && time.Since(c.fetchedAt) <= jwksCacheTTL {
+		return key, nil
+	}
+
+	var err error
+	if c.fetching == nil && time.Since(c.triedAt) > jwksMinRefresh {
+		err = c.refresh()
+	} else if c.fetching != nil && !ok {
+		done := c.fetching
+		c.mu.Unlock()
+		<-done
+		c.mu.Lock()
+	}
+
+	// A failed fetch leaves the cached keys alone, so we keep using them while the auth service is
+	// briefly unreachable
+	key, ok = c.keys[kid]
+	if !ok {
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// refresh fetches the key set and caches it if the fetch works. c.mu must be held, but is let go of
+// while fetching so other lookups aren't held up.
+func (c *KeyCache) refresh() error {
+	done := make(chan struct{})
+	c.fetching = done
+	c.triedAt = time.Now()
+	c.mu.Unlock()
+
+	keys, err := c.fetch()
+
+	c.mu.Lock()
+	if err == nil {
+		c.keys = keys
+		c.fetchedAt = time.Now()
+	}
+	c.fetching = nil
+	close(done)
+	return err
+}
+
+// jwk is a single RSA public key in JSON Web Key format (RFC 7517).
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// fetch returns the keys that are currently published.
+func (c *KeyCache) fetch() (map[string]*rsa.PublicKey, error) {
+	resp, err := c.client.Get(c.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", c.url, resp.Status)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Serves a JWKS whose contents can be swapped out to simulate key rotation. If status is set, it is
+// returned instead of the keys, and if hold is set, requests wait until it is closed.
+type fakeJWKS struct {
+	mu       sync.Mutex
+	keys     map[string]*rsa.PublicKey
+	requests int
+	status   int
+	hold     chan struct{}
+}
+
+func (f *fakeJWKS) set(keys map[string]*rsa.PublicKey) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.keys = keys
+}
+
+func (f *fakeJWKS) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests
+}
+
+func (f *fakeJWKS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.requests++
+	hold := f.hold
+	f.mu.Unlock()
+	if hold != nil {
+		<-hold
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.status != 0 {
+		w.WriteHeader(f.status)
+		return
+	}
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for kid, key := range f.keys {
+		set.Keys = append(set.Keys, jwk{
+			Kty: "RSA",
+			Kid: kid,
+			N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		})
+	}
+	json.NewEncoder(w).Encode(set)
+}
+
+func TestKeyCache(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks := &fakeJWKS{keys: map[string]*rsa.PublicKey{"old": &oldKey.PublicKey}}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	cache := NewKeyCache(server.URL)
+
+	// The first lookup fetches the key set.
+	key, err := cache.Key("old")
+	require.NoError(t, err)
+	assert.Equal(t, oldKey.PublicKey.N, key.N)
+
+	// Cached keys don't cause another fetch.
+	_, err = cache.Key("old")
+	require.NoError(t, err)
+	assert.Equal(t, 1, jwks.requests)
+
+	// Unknown keys are rejected.
+	_, err = cache.Key("bogus")
+	assert.Error(t, err)
+
+	// The auth service rotates to a new key but keeps publishing the old one.
+	jwks.set(map[string]*rsa.PublicKey{"old": &oldKey.PublicKey, "new": &newKey.PublicKey})
+	cache.fetchedAt = time.Now().Add(-jwksMinRefresh - time.Second)
+	cache.triedAt = cache.fetchedAt
+
+	key, err = cache.Key("new")
+	require.NoError(t, err)
+	assert.Equal(t, newKey.PublicKey.N, key.N)
+	_, err = cache.Key("old")
+	assert.NoError(t, err, "old key should keep validating during rotation")
+}
+
+// Checks that a failed fetch isn't retried on every lookup, and that keys fetched before keep working.
+func TestKeyCacheFetchFails(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks := &fakeJWKS{keys: map[string]*rsa.PublicKey{"old": &key.PublicKey}}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	cache := NewKeyCache(server.URL)
+	_, err = cache.Key("old")
+	require.NoError(t, err)
+
+	// The auth service goes down after the cached keys have gone stale
+	jwks.mu.Lock()
+	jwks.status = http.StatusServiceUnavailable
+	jwks.mu.Unlock()
+	cache.fetchedAt = time.Now().Add(-jwksCacheTTL - time.Second)
+	cache.triedAt = cache.fetchedAt
+
+	_, err = cache.Key("old")
+	assert.NoError(t, err, "cached keys should keep working while the auth service is down")
+	_, err = cache.Key("new")
+	assert.Error(t, err)
+	_, err = cache.Key("new")
+	assert.Error(t, err)
+	assert.Equal(t, 2, jwks.count(), "a failed fetch shouldn't be retried straight away")
+}
+
+// Checks that a slow fetch doesn't hold up lookups of cached keys, and that lookups of an unknown kid
+// share the fetch that is running.
+func TestKeyCacheSlowFetch(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	jwks := &fakeJWKS{keys: map[string]*rsa.PublicKey{"old": &oldKey.PublicKey}}
+	server := httptest.NewServer(jwks)
+	defer server.Close()
+	cache := NewKeyCache(server.URL)
+	_, err = cache.Key("old")
+	require.NoError(t, err)
+
+	// The next fetch hangs until hold is closed
+	hold := make(chan struct{})
+	jwks.mu.Lock()
+	jwks.hold = hold
+	jwks.keys = map[string]*rsa.PublicKey{"old": &oldKey.PublicKey, "new": &newKey.PublicKey}
+	jwks.mu.Unlock()
+	cache.fetchedAt = time.Now().Add(-jwksCacheTTL - time.Second)
+	cache.triedAt = cache.fetchedAt
+
+	var wg sync.WaitGroup
+	lookup := func(kid string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.Key(kid)
+			assert.NoError(t, err, "looking up %q", kid)
+		}()
+	}
+	lookup("old")
+	require.Eventually(t, func() bool { return jwks.count() == 2 }, time.Second, time.Millisecond)
+
+	// Cached keys are returned while the fetch is running
+	start := time.Now()
+	_, err = cache.Key("old")
+	assert.NoError(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(100*time.Millisecond))
+
+	for i := 0; i < 5; i++ {
+		lookup("new")
+	}
+	close(hold)
+	wg.Wait()
+	assert.Equal(t, 2, jwks.count(), "lookups should share the running fetch")
+}
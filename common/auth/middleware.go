@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// A KeySource looks up the public key that signed a token by its key ID.
+type KeySource interface {
+	Key(kid string) (*rsa.PublicKey, error)
+}
+
+// A SessionChecker reports whether a token, identified by its jti claim, is still active.
+type SessionChecker interface {
+	TokenActive(tokenID string) (bool, error)
+}
+
+// SQLSessions checks tokens against the sessions table in the auth database.
+type SQLSessions struct {
+	DB *sql.DB
+}
+
+// TokenActive reports whether the token was issued by the auth service and hasn't been revoked.
+func (s SQLSessions) TokenActive(tokenID string) (bool, error) {
+	var active bool
+	err := s.DB.QueryRow("SELECT EXISTS(SELECT * FROM sessions WHERE tokenId=? AND revoked=FALSE)", tokenID).Scan(&active)
+	return active, err
+}
+
+// Issuer is the issuer the auth service puts in every token it signs.
+const Issuer = "CalChat"
+
+// NewValidator creates a Validator that checks tokens were signed with keys from keys by the auth
+// service, and haven't been revoked according to sessions.
+func NewValidator(keys KeySource, sessions SessionChecker) *Validator {
+	return &Validator{Keys: keys, Sessions: sessions, Issuer: Issuer}
+}
+
+// Validator checks the signature, expiry, issuer and revocation status of tokens.
+type Validator struct {
+	Keys KeySource
+	// Sessions is optional. If it is nil, revoked tokens are accepted until they expire.
+	Sessions SessionChecker
+	// Issuer is optional. If it is set, tokens from any other issuer are rejected.
+	Issuer string
+}
+
+// Validate parses tokenString and returns its claims if it is valid.
+func (v *Validator) Validate(tokenString string) (*AuthClaims, error) {
+	claims := &AuthClaims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		// Only accept the algorithm the auth service signs with
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return v.Keys.Key(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	if v.Issuer != "" && !claims.VerifyIssuer(v.Issuer, true) {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+
+	if v.Sessions != nil {
+		active, err := v.Sessions.TokenActive(claims.Id)
+		if err != nil {
+			return nil, err
+		}
+		if !active {
+			return nil, errors.New("token has been revoked")
+		}
+	}
+	return claims, nil
+}
+
+// Middleware returns a mux middleware that only lets requests with a valid access_token cookie
+// through. The token's claims are stored in the request context, where handlers can get them with
+// ClaimsFromContext or UserID. Any other request gets a 401 Unauthorized.
+func Middleware(v *Validator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cookie, err := r.Cookie("access_token")
+			if err != nil {
+				http.Error(w, "missing access token", http.StatusUnauthorized)
+				return
+			}
+
+			claims, err := v.Validate(cookie.Value)
+			if err == nil && claims.Subject != "access" {
+				err = fmt.Errorf("unexpected token subject %q", claims.Subject)
+			}
+			if err != nil {
+				http.Error(w, "invalid access token", http.StatusUnauthorized)
+				log.Print(err.Error())
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), claims)))
+		})
+	}
+}
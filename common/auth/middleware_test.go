@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A KeySource holding a fixed set of keys.
+type staticKeys map[string]*rsa.PublicKey
+
+func (k staticKeys) Key(kid string) (*rsa.PublicKey, error) {
+	key, ok := k[kid]
+	if !ok {
+		return nil, errors.New("unknown key")
+	}
+	return key, nil
+}
+
+// A SessionChecker that treats every token ID in the set as revoked.
+type revokedTokens map[string]bool
+
+func (r revokedTokens) TokenActive(tokenID string) (bool, error) {
+	return !r[tokenID], nil
+}
+
+func TestMiddleware(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	revoked := revokedTokens{"revoked": true}
+	validator := NewValidator(staticKeys{"kid": &key.PublicKey}, revoked)
+
+	sign := func(method jwt.SigningMethod, signingKey interface{}, claims AuthClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = "kid"
+		s, err := token.SignedString(signingKey)
+		require.NoError(t, err)
+		return s
+	}
+	claims := func(id string, subject string, issuer string) AuthClaims {
+		return AuthClaims{
+			UserID:    "oski",
+			SessionID: "session",
+			StandardClaims: jwt.StandardClaims{
+				Id:        id,
+				Subject:   subject,
+				Issuer:    issuer,
+				ExpiresAt: time.Now().Add(time.Hour).Unix(),
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		cookie string
+		status int
+	}{
+		{"Valid Token", sign(jwt.SigningMethodRS256, key, claims("ok", "access", "CalChat")), http.StatusOK},
+		{"No Cookie", "", http.StatusUnauthorized},
+		{"Garbage", "not a token", http.StatusUnauthorized},
+		{"Revoked", sign(jwt.SigningMethodRS256, key, claims("revoked", "access", "CalChat")), http.StatusUnauthorized},
+		{"Refresh Token", sign(jwt.SigningMethodRS256, key, claims("ok", "refresh", "CalChat")), http.StatusUnauthorized},
+		{"Wrong Issuer", sign(jwt.SigningMethodRS256, key, claims("ok", "access", "Stanford")), http.StatusUnauthorized},
+		{"HMAC Signed", sign(jwt.SigningMethodHS256, []byte("my_secret_key"), claims("ok", "access", "CalChat")), http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var userID string
+			handler := Middleware(validator)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userID = UserID(r)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: "access_token", Value: test.cookie})
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			assert.Equal(t, test.status, rr.Code)
+			if test.status == http.StatusOK {
+				assert.Equal(t, "oski", userID, "claims were not stored in the request context")
+			} else {
+				assert.Empty(t, userID, "handler ran for an unauthorized request")
+			}
+		})
+	}
+}
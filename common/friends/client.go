@@ -0,0 +1,73 @@
+// Package friends lets BearChat services ask the friends service about the relationships between
+// users through its internal endpoints.
+package friends
+
+import (
+	"context"
+	"net/url"
+	"os"
+
+	"github.com/BearCloud/sp21-bearchat/common/internal/client"
+)
+
+// DefaultURL is where the friends service listens inside the Docker network.
+const DefaultURL = "http://172.28.1.5"
+
+// URL returns the FRIENDS_URL environment variable, or DefaultURL if it isn't set.
+func URL() string {
+	if url := os.Getenv("FRIENDS_URL"); url != "" {
+		return url
+	}
+	return DefaultURL
+}
+
+// A BlockList reports who has blocked a user.
+type BlockList interface {
+	// Blockers returns the UUIDs of everyone who has blocked uuid.
+	Blockers(ctx context.Context, uuid string) ([]string, error)
+}
+
+// FeedSources reports whose posts belong in a user's feed.
+type FeedSources interface {
+	// FeedAuthors returns the UUIDs of uuid's friends and of the users uuid follows.
+	FeedAuthors(ctx context.Context, uuid string) ([]string, error)
+}
+
+// FriendLists reports who users have put in their named friend lists, such as "close friends".
+type FriendLists interface {
+	// InList reports whether owner has put member in their friend list with the given name. It is false
+	// if owner has no such list.
+	InList(ctx context.Context, owner, list, member string) (bool, error)
+}
+
+// Client calls the friends service's internal endpoints.
+type Client struct {
+	client *client.Client
+}
+
+// NewClient creates a Client for the friends service at url, authenticating with the shared internal
+// token.
+func NewClient(url, token string) *Client {
+	return &Client{client.New("friends service", url, token)}
+}
+
+// Blockers returns the UUIDs of everyone who has blocked uuid.
+func (c *Client) Blockers(ctx context.Context, uuid string) ([]string, error) {
+	blockers := []string{}
+	err := c.client.Get(ctx, "/internal/friends/"+url.PathEscape(uuid)+"/blockers", &blockers)
+	return blockers, err
+}
+
+// FeedAuthors returns the UUIDs of uuid's friends and of the users uuid follows.
+func (c *Client) FeedAuthors(ctx context.Context, uuid string) ([]string, error) {
+	authors := []string{}
+	err := c.client.Get(ctx, "/internal/friends/"+url.PathEscape(uuid)+"/feed-authors", &authors)
+	return authors, err
+}
+
+// InList reports whether owner has put member in their friend list with the given name.
+func (c *Client) InList(ctx context.Context, owner, list, member string) (bool, error) {
+	var found bool
+	err := c.client.Get(ctx, "/internal/friends/"+url.PathEscape(owner)+"/lists/"+url.PathEscape(list)+"/"+url.PathEscape(member), &found)
+	return found, err
+}
@@ -0,0 +1,81 @@
+package friends
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(auth.InternalTokenHeader) != "secret" {
+			http.Error(w, "invalid internal token", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/internal/friends/oski/blockers" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]string{"stanfurd", "bruin"})
+	}))
+	defer server.Close()
+
+	blockers, err := NewClient(server.URL, "secret").Blockers(context.Background(), "oski")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"stanfurd", "bruin"}, blockers)
+
+	// Errors from the friends service are passed on rather than treated as an empty list.
+	_, err = NewClient(server.URL, "guess").Blockers(context.Background(), "oski")
+	assert.Error(t, err)
+}
+
+func TestInList(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(auth.InternalTokenHeader) != "secret" {
+			http.Error(w, "invalid internal token", http.StatusUnauthorized)
+			return
+		}
+		// List names are escaped, so ones with spaces reach the right endpoint
+		json.NewEncoder(w).Encode(r.URL.EscapedPath() == "/internal/friends/oski/lists/close%20friends/bruin")
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "secret")
+	found, err := client.InList(context.Background(), "oski", "close friends", "bruin")
+	require.NoError(t, err)
+	assert.True(t, found)
+	found, err = client.InList(context.Background(), "oski", "close friends", "stanfurd")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	_, err = NewClient(server.URL, "guess").InList(context.Background(), "oski", "close friends", "bruin")
+	assert.Error(t, err)
+}
+
+func TestFeedAuthors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(auth.InternalTokenHeader) != "secret" {
+			http.Error(w, "invalid internal token", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/internal/friends/oski/feed-authors" {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode([]string{"bruin", "stanfurd"})
+	}))
+	defer server.Close()
+
+	authors, err := NewClient(server.URL, "secret").FeedAuthors(context.Background(), "oski")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bruin", "stanfurd"}, authors)
+
+	_, err = NewClient(server.URL, "guess").FeedAuthors(context.Background(), "oski")
+	assert.Error(t, err)
+}
@@ -0,0 +1,8 @@
+module github.com/BearCloud/sp21-bearchat/common
+
+go 1.16
+
+require (
+	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/stretchr/testify v1.7.0
+)
@@ -0,0 +1,60 @@
+// Package client calls other BearChat services' internal endpoints. It is shared by the clients for
+// each service, so they all authenticate, time out and report errors the same way.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+)
+
+// Timeout is the most time a request to another service can take.
+const Timeout = 5 * time.Second
+
+// Client sends requests to one service's internal endpoints, authenticating with the shared internal
+// token.
+type Client struct {
+	service string
+	url     string
+	token   string
+	client  *http.Client
+}
+
+// New creates a Client for the service at url. service names the service in errors, such as
+// "friends service".
+func New(service, url, token string) *Client {
+	return &Client{
+		service: service,
+		url:     url,
+		token:   token,
+		client:  &http.Client{Timeout: Timeout},
+	}
+}
+
+// Get sends a GET request for path, which may include a query, and decodes the JSON response into v.
+// Any status other than 200 OK is an error.
+func (c *Client) Get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(auth.InternalTokenHeader, c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: GET %s: %s", c.service, req.URL.Path, resp.Status)
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("%s: GET %s: %w", c.service, req.URL.Path, err)
+	}
+	return nil
+}
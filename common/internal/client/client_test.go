@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGet(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(auth.InternalTokenHeader) != "secret" {
+			http.Error(w, "invalid internal token", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/internal/echo":
+			json.NewEncoder(w).Encode(r.URL.Query()["value"])
+		case "/internal/broken":
+			w.Write([]byte("not json"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	// The token is sent along and the response decoded
+	values := []string{}
+	err := New("echo service", server.URL, "secret").Get(ctx, "/internal/echo?value=a&value=b", &values)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, values)
+
+	// Errors name the service and the path, but not the query
+	err = New("echo service", server.URL, "wrong").Get(ctx, "/internal/echo?value=a", &values)
+	if assert.Error(t, err) {
+		assert.Equal(t, "echo service: GET /internal/echo: 401 Unauthorized", err.Error())
+	}
+	err = New("echo service", server.URL, "secret").Get(ctx, "/internal/broken", &values)
+	assert.Error(t, err)
+}
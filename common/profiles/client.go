@@ -0,0 +1,73 @@
+// Package profiles lets BearChat services look up user profiles through the profiles service's
+// internal endpoints.
+package profiles
+
+import (
+	"context"
+	"net/url"
+	"os"
+
+	"github.com/BearCloud/sp21-bearchat/common/internal/client"
+)
+
+// DefaultURL is where the profiles service listens inside the Docker network.
+const DefaultURL = "http://172.28.1.4"
+
+// MaxBatch is the most profiles that can be looked up in one request.
+const MaxBatch = 100
+
+// URL returns the PROFILES_URL environment variable, or DefaultURL if it isn't set.
+func URL() string {
+	if url := os.Getenv("PROFILES_URL"); url != "" {
+		return url
+	}
+	return DefaultURL
+}
+
+// Profile is the public profile of a user.
+type Profile struct {
+	Firstname string `json:"firstName"`
+	Lastname  string `json:"lastName"`
+	Email     string `json:"email"`
+	UUID      string `json:"uuid"`
+}
+
+// A Directory looks up the profiles of many users at once.
+type Directory interface {
+	// Profiles returns the profiles of the given users, keyed by UUID. Users who haven't created a
+	// profile are left out.
+	Profiles(ctx context.Context, uuids []string) (map[string]Profile, error)
+}
+
+// Client calls the profiles service's internal endpoints.
+type Client struct {
+	client *client.Client
+}
+
+// NewClient creates a Client for the profiles service at url, authenticating with the shared internal
+// token.
+func NewClient(url, token string) *Client {
+	return &Client{client.New("profiles service", url, token)}
+}
+
+// Profiles returns the profiles of the given users, keyed by UUID, looking up at most MaxBatch of them
+// per request.
+func (c *Client) Profiles(ctx context.Context, uuids []string) (map[string]Profile, error) {
+	found := make(map[string]Profile, len(uuids))
+	for start := 0; start < len(uuids); start += MaxBatch {
+		end := start + MaxBatch
+		if end > len(uuids) {
+			end = len(uuids)
+		}
+
+		batch := []Profile{}
+		err := c.client.Get(ctx, "/internal/profiles?"+url.Values{"uuid": uuids[start:end]}.Encode(), &batch)
+		if err != nil {
+			return nil, err
+		}
+		for _, profile := range batch {
+			found[profile.UUID] = profile
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,66 @@
+package profiles
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProfiles(t *testing.T) {
+	// Only oski and every other numbered user have profiles
+	withProfiles := map[string]bool{"oski": true}
+	for i := 0; i <= MaxBatch; i += 2 {
+		withProfiles[fmt.Sprintf("user%d", i)] = true
+	}
+
+	var batches [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(auth.InternalTokenHeader) != "secret" {
+			http.Error(w, "invalid internal token", http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/internal/profiles" {
+			http.NotFound(w, r)
+			return
+		}
+		uuids := r.URL.Query()["uuid"]
+		batches = append(batches, uuids)
+
+		found := []Profile{}
+		for _, uuid := range uuids {
+			if withProfiles[uuid] {
+				found = append(found, Profile{Firstname: "Name of " + uuid, UUID: uuid})
+			}
+		}
+		json.NewEncoder(w).Encode(found)
+	}))
+	defer server.Close()
+
+	found, err := NewClient(server.URL, "secret").Profiles(context.Background(), []string{"oski", "bruin"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Profile{"oski": {Firstname: "Name of oski", UUID: "oski"}}, found)
+
+	// Long lists are split into batches of at most MaxBatch
+	batches = nil
+	uuids := []string{}
+	for i := 0; i <= MaxBatch; i++ {
+		uuids = append(uuids, fmt.Sprintf("user%d", i))
+	}
+	found, err = NewClient(server.URL, "secret").Profiles(context.Background(), uuids)
+	require.NoError(t, err)
+	assert.Len(t, found, MaxBatch/2+1)
+	require.Len(t, batches, 2)
+	assert.Len(t, batches[0], MaxBatch)
+	assert.Equal(t, []string{uuids[MaxBatch]}, batches[1])
+
+	// Errors from the profiles service are passed on rather than treated as missing profiles.
+	_, err = NewClient(server.URL, "guess").Profiles(context.Background(), []string{"oski"})
+	assert.Error(t, err)
+}
@@ -9,9 +9,43 @@ CREATE TABLE users (
     email VARCHAR(320),
     hashedPassword TEXT,
     verified boolean,
-    resetToken TEXT,
-    verifiedToken TEXT,
-    userId VARCHAR(128) PRIMARY KEY
+    userId VARCHAR(128) PRIMARY KEY,
+    locale VARCHAR(16) NOT NULL DEFAULT 'en'
+);
+
+CREATE TABLE userTokens (
+    tokenHash CHAR(64) PRIMARY KEY,
+    userId VARCHAR(128) NOT NULL,
+    purpose VARCHAR(16) NOT NULL,
+    expiresAt DATETIME(3) NOT NULL,
+    usedAt DATETIME(3),
+    INDEX (userId, purpose)
+);
+
+CREATE TABLE sessions (
+    tokenId VARCHAR(36) PRIMARY KEY,
+    sessionId VARCHAR(36),
+    userId VARCHAR(128),
+    expiresAt DATETIME,
+    revoked boolean,
+    INDEX (sessionId),
+    INDEX (userId),
+    INDEX (expiresAt)
+);
+
+CREATE TABLE emailOutbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    recipient VARCHAR(320) NOT NULL,
+    locale VARCHAR(16) NOT NULL,
+    template VARCHAR(64) NOT NULL,
+    data TEXT,
+    status VARCHAR(16) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    nextAttempt DATETIME(3) NOT NULL,
+    lastError TEXT,
+    createdAt DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    sentAt DATETIME(3),
+    INDEX (status, nextAttempt)
 );
 
 CREATE DATABASE postsDB;
@@ -36,4 +70,54 @@ CREATE TABLE users (
     uuid VARCHAR(36) PRIMARY KEY
 );
 
+CREATE DATABASE friendsDB;
+
+USE friendsDB;
+
+CREATE TABLE users (
+    uuid VARCHAR(36) PRIMARY KEY
+);
+
+CREATE TABLE friendships (
+    userId VARCHAR(36),
+    friendId VARCHAR(36),
+    createdAt DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+    PRIMARY KEY (userId, friendId),
+    INDEX (userId, createdAt)
+);
+
+CREATE TABLE friendRequests (
+    senderId VARCHAR(36),
+    recipientId VARCHAR(36),
+    state VARCHAR(9) NOT NULL,
+    PRIMARY KEY (senderId, recipientId),
+    INDEX (recipientId)
+);
+
+CREATE TABLE blocks (
+    blockerId VARCHAR(36),
+    blockedId VARCHAR(36),
+    PRIMARY KEY (blockerId, blockedId),
+    INDEX (blockedId)
+);
 
+CREATE TABLE friendLists (
+    ownerId VARCHAR(36),
+    name VARCHAR(64),
+    PRIMARY KEY (ownerId, name)
+);
+
+CREATE TABLE friendListMembers (
+    ownerId VARCHAR(36),
+    name VARCHAR(64),
+    memberId VARCHAR(36),
+    PRIMARY KEY (ownerId, name, memberId),
+    INDEX (ownerId, memberId)
+);
+
+CREATE TABLE follows (
+    followerId VARCHAR(36),
+    followeeId VARCHAR(36),
+    PRIMARY KEY (followerId, followeeId),
+    INDEX (followeeId)
+);
@@ -1,167 +1,677 @@
 package api
 
 import (
-	"net/http"
-	"github.com/gorilla/mux"
-	"log"
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/BearCloud/sp21-bearchat/common/profiles"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultSuggestions is how many suggestions are returned when the request doesn't give a limit.
+	defaultSuggestions = 10
+	// maxSuggestions is the most suggestions that can be asked for at once.
+	maxSuggestions = 50
+	// defaultFriendsPage is how many friends are on a page when the request doesn't give a limit.
+	defaultFriendsPage = 50
+	// maxFriendsPage is the most friends that can be asked for at once.
+	maxFriendsPage = profiles.MaxBatch
+	// defaultFollows is how many users are on a page of followers or followed users when the request
+	// doesn't give a limit.
+	defaultFollows = 50
+	// maxFollows is the most followers or followed users that can be asked for at once.
+	maxFollows = 100
+	// maxListName is the longest a friend list's name can be, in characters. It matches the size of
+	// the name columns in friendsDB.
+	maxListName = 64
 )
 
-const NeptuneURL = "https://<your_neptune_writer_endpoint>:8182/gremlin"
+// A friendsPage is one page of the caller's friends. NextCursor is left out on the last page.
+type friendsPage struct {
+	Friends    []friendEntry `json:"friends"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+// A followsPage is one page of the users following a user, or followed by them. Count is how many
+// there are across all pages.
+type followsPage struct {
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
+
+// A friendEntry is a friend on a friendsPage, along with their profile if it was asked for and they
+// have one.
+type friendEntry struct {
+	Friend
+	Profile *profiles.Profile `json:"profile,omitempty"`
+}
 
-func RegisterRoutes(router *mux.Router) error {
-	router.HandleFunc("/api/friends/{uuid}", areFriends).Methods(http.MethodGet, http.MethodOptions)
-	router.HandleFunc("/api/friends/{uuid}", addFriend).Methods(http.MethodPost, http.MethodOptions)
-	// router.HandleFunc("/api/friends/{uuid}", deleteFriend).Methods(http.MethodDelete)
-	// router.HandleFunc("/api/friends/{uuid}/mutual", mutualFriends).Methods(http.MethodGet)
-	router.HandleFunc("/api/friends", getFriends).Methods(http.MethodGet, http.MethodOptions)
-	router.HandleFunc("/api/friends", addUser).Methods(http.MethodPost, http.MethodOptions)
+// RegisterRoutes maps the friends endpoints onto handlers backed by graph. Routes wrapped in
+// authenticate only run for requests with a valid access token, and can find the caller's UUID with
+// auth.UserID. Friends' profiles are looked up in directory when a list of friends asks for them.
+func RegisterRoutes(router *mux.Router, authenticate func(http.Handler) http.Handler, graph FriendGraph, directory profiles.Directory) error {
+	router.Handle("/api/friends/suggestions", authenticate(suggestFriends(graph))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends/requests/incoming", authenticate(incomingRequests(graph))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends/requests/outgoing", authenticate(outgoingRequests(graph))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends/requests/{uuid}/accept", authenticate(respondToRequest(graph, RequestAccepted))).Methods(http.MethodPost, http.MethodOptions)
+	router.Handle("/api/friends/requests/{uuid}/decline", authenticate(respondToRequest(graph, RequestDeclined))).Methods(http.MethodPost, http.MethodOptions)
+	router.Handle("/api/friends/requests/{uuid}", authenticate(cancelRequest(graph))).Methods(http.MethodDelete, http.MethodOptions)
+	router.Handle("/api/friends/lists", authenticate(getLists(graph))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends/lists/{name}", authenticate(getList(graph))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends/lists/{name}", authenticate(createList(graph))).Methods(http.MethodPut, http.MethodOptions)
+	router.Handle("/api/friends/lists/{name}", authenticate(deleteList(graph))).Methods(http.MethodDelete, http.MethodOptions)
+	router.Handle("/api/friends/lists/{name}/{uuid}", authenticate(addToList(graph))).Methods(http.MethodPut, http.MethodOptions)
+	router.Handle("/api/friends/lists/{name}/{uuid}", authenticate(removeFromList(graph))).Methods(http.MethodDelete, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}", authenticate(areFriends(graph))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}", authenticate(addFriend(graph))).Methods(http.MethodPost, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}", authenticate(deleteFriend(graph))).Methods(http.MethodDelete, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}/block", authenticate(blockUser(graph))).Methods(http.MethodPost, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}/block", authenticate(unblockUser(graph))).Methods(http.MethodDelete, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}/since", authenticate(friendsSince(graph))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}/follow", authenticate(followUser(graph))).Methods(http.MethodPost, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}/follow", authenticate(unfollowUser(graph))).Methods(http.MethodDelete, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}/followers", authenticate(getFollowers(graph))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}/following", authenticate(getFollowing(graph))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends/{uuid}/mutual", authenticate(mutualFriends(graph))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends", authenticate(getFriends(graph, directory))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/friends", authenticate(addUser(graph))).Methods(http.MethodPost, http.MethodOptions)
 
 	return nil
 }
 
-func getUUID (w http.ResponseWriter, r *http.Request) (uuid string) {
-	cookie, err := r.Cookie("access_token")
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		log.Print(err.Error())
+// RegisterInternalRoutes maps the endpoints other services call onto handlers backed by graph. Routes
+// wrapped in authorize only run for requests from other services.
+func RegisterInternalRoutes(router *mux.Router, authorize func(http.Handler) http.Handler, graph FriendGraph) {
+	router.Handle("/internal/friends/{uuid}/blockers", authorize(getBlockers(graph))).Methods(http.MethodGet)
+	router.Handle("/internal/friends/{uuid}/feed-authors", authorize(getFeedAuthors(graph))).Methods(http.MethodGet)
+	router.Handle("/internal/friends/{uuid}/lists/{name}/{member}", authorize(inList(graph))).Methods(http.MethodGet)
+	router.Handle("/internal/debug/vars", authorize(expvar.Handler())).Methods(http.MethodGet)
+}
+
+// RegisterAdminRoutes maps the endpoints for backing up and migrating the graph onto handlers backed by
+// graph. They only run for authenticated requests from one of the users in admins.
+func RegisterAdminRoutes(router *mux.Router, authenticate func(http.Handler) http.Handler, admins []string, graph FriendGraph) {
+	authorize := func(next http.Handler) http.Handler { return authenticate(auth.AdminMiddleware(admins)(next)) }
+	router.Handle("/admin/friends/export", authorize(exportGraph(graph))).Methods(http.MethodGet)
+	router.Handle("/admin/friends/import", authorize(importGraph(graph))).Methods(http.MethodPost)
+}
+
+// getFriends returns the caller's friends. Without any query parameters, the response is a plain list
+// of every friend's UUID. Otherwise it is a friendsPage of up to limit friends in the given order
+// ("uuid", "newest" or "oldest"), starting at cursor, which is the nextCursor of the previous page.
+// With include=profiles, each friend's profile is looked up too, in one batch.
+func getFriends(graph FriendGraph, directory profiles.Directory) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Query()) == 0 {
+			friends, err := graph.Friends(r.Context(), auth.UserID(r))
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+
+			json.NewEncoder(w).Encode(friends)
+			return
+		}
+
+		query := FriendQuery{Order: FriendOrder(r.URL.Query().Get("order"))}
+		switch query.Order {
+		case "":
+			query.Order = OrderByUUID
+		case OrderByUUID, OrderNewest, OrderOldest:
+		default:
+			http.Error(w, "order must be uuid, newest or oldest", http.StatusBadRequest)
+			return
+		}
+		limit, err := queryInt(r, "limit", defaultFriendsPage)
+		if err != nil || limit < 1 || limit > maxFriendsPage {
+			http.Error(w, fmt.Sprintf("limit must be an integer from 1 to %d", maxFriendsPage), http.StatusBadRequest)
+			return
+		}
+		if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+			query.After, err = decodeCursor(query.Order, cursor)
+			if err != nil {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+		}
+		include := r.URL.Query().Get("include")
+		if include != "" && include != "profiles" {
+			http.Error(w, "include must be profiles", http.StatusBadRequest)
+			return
+		}
+
+		// Ask for one more friend than fits on the page to find out whether there is another page
+		query.Limit = limit + 1
+		friends, err := graph.ListFriends(r.Context(), auth.UserID(r), query)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		page := friendsPage{Friends: []friendEntry{}}
+		if len(friends) > limit {
+			friends = friends[:limit]
+			page.NextCursor = encodeCursor(query.Order, friends[limit-1])
+		}
+		for _, friend := range friends {
+			page.Friends = append(page.Friends, friendEntry{Friend: friend})
+		}
+
+		if include == "profiles" && len(friends) > 0 {
+			uuids := make([]string, len(friends))
+			for i, friend := range friends {
+				uuids[i] = friend.UUID
+			}
+			found, err := directory.Profiles(r.Context(), uuids)
+			if err != nil {
+				http.Error(w, "error getting profiles", http.StatusBadGateway)
+				log.Print(err.Error())
+				return
+			}
+			for i := range page.Friends {
+				if profile, ok := found[page.Friends[i].UUID]; ok {
+					page.Friends[i].Profile = &profile
+				}
+			}
+		}
+
+		json.NewEncoder(w).Encode(page)
 	}
-	//validate the cookie
-	claims, err := ValidateToken(cookie.Value)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		log.Print(err.Error())
+}
+
+// areFriends reports whether the caller is friends with the user in the path.
+func areFriends(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		otherUUID := mux.Vars(r)["uuid"]
+		friends, err := graph.AreFriends(r.Context(), auth.UserID(r), otherUUID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		fmt.Fprint(w, friends)
+	}
+}
+
+// addFriend sends a friend request from the caller to the user in the path. They only become friends
+// once the other user accepts it.
+func addFriend(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		otherUUID := mux.Vars(r)["uuid"]
+		err := graph.SendRequest(r.Context(), auth.UserID(r), otherUUID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 	}
-	log.Println(claims)
+}
+
+// suggestFriends returns friends of the caller's friends, ranked by how many mutual friends they have
+// with the caller. The offset and limit query parameters page through the suggestions.
+func suggestFriends(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offset, err := queryInt(r, "offset", 0)
+		if err != nil || offset < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		limit, err := queryInt(r, "limit", defaultSuggestions)
+		if err != nil || limit < 1 || limit > maxSuggestions {
+			http.Error(w, fmt.Sprintf("limit must be an integer from 1 to %d", maxSuggestions), http.StatusBadRequest)
+			return
+		}
 
-	
+		suggestions, err := graph.Suggestions(r.Context(), auth.UserID(r), offset, limit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 
-	return claims["UserID"].(string)
+		json.NewEncoder(w).Encode(suggestions)
+	}
 }
 
-func getFriends (w http.ResponseWriter, r *http.Request) {
-	uuid := getUUID(w, r)
-	gq := "g.V().has('uuid', '" + uuid + "').out('friends with').values('uuid')"
-	response, err := makeNeptuneRequest(gq)
-	// var req_body map[string]string
-	// req_body["gremlin"] = "g.V().has('uuid', '" + uuid + "').out('friends with').values('uuid')"
+// respondToRequest accepts or declines, depending on state, the friend request the user in the path
+// sent to the caller.
+func respondToRequest(graph FriendGraph, state RequestState) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		senderUUID := mux.Vars(r)["uuid"]
+		err := graph.UpdateRequest(r.Context(), senderUUID, auth.UserID(r), state)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+}
 
-	// jsonValue, _ := json.Marshal(req_body)
+// cancelRequest cancels the friend request the caller sent to the user in the path.
+func cancelRequest(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recipientUUID := mux.Vars(r)["uuid"]
+		err := graph.UpdateRequest(r.Context(), auth.UserID(r), recipientUUID, RequestCancelled)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+}
 
-	// resp, err := http.Post(NeptuneURL, "application/json", bytes.NewBuffer(jsonValue))
-	// defer resp.Body.Close()
+// incomingRequests returns the UUIDs of everyone with a pending friend request to the caller.
+func incomingRequests(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		senders, err := graph.IncomingRequests(r.Context(), auth.UserID(r))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 
-	// var response map[string]interface{}
-	
-	// err = json.NewDecoder(resp.Body).Decode(&response)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		json.NewEncoder(w).Encode(senders)
 	}
+}
 
-	result := response["result"].(map[string]interface{})
-	data := result["data"].(map[string]interface{})
-	values := data["@value"].([]interface{})
+// outgoingRequests returns the UUIDs of everyone the caller has a pending friend request to.
+func outgoingRequests(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recipients, err := graph.OutgoingRequests(r.Context(), auth.UserID(r))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 
-	json.NewEncoder(w).Encode(values)
-	return
+		json.NewEncoder(w).Encode(recipients)
+	}
 }
 
-func areFriends(w http.ResponseWriter, r *http.Request) {
-	otherUUID := mux.Vars(r)["uuid"]
-	uuid := getUUID(w, r)
-	gq := "g.V().has('uuid', '" + uuid + "').outE('friends with').where(otherV().has('uuid', '" + otherUUID + "')).count()"
-	response, err := makeNeptuneRequest(gq)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// addUser adds the caller to the friends graph. The frontend calls this right after signing up, and
+// calling it again does nothing, so it is safe to retry.
+func addUser(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := graph.AddUser(r.Context(), auth.UserID(r))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 	}
+}
 
-	result := response["result"].(map[string]interface{})
-	data := result["data"].(map[string]interface{})
-	values := data["@value"].([]interface{})
-	value := values[0].(map[string]interface{})
-	edges := value["@value"].(float64)
+// deleteFriend ends the caller's friendship with the user in the path.
+func deleteFriend(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		otherUUID := mux.Vars(r)["uuid"]
+		err := graph.RemoveFriendship(r.Context(), auth.UserID(r), otherUUID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+}
 
-	if edges < 1 {
-		fmt.Fprint(w, false)
-	} else {
-		fmt.Fprint(w, true)
+// mutualFriends returns the UUIDs of everyone who is friends with both the caller and the user in the
+// path.
+func mutualFriends(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		otherUUID := mux.Vars(r)["uuid"]
+		mutuals, err := graph.Mutuals(r.Context(), auth.UserID(r), otherUUID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(mutuals)
 	}
+}
 
-	return
+// blockUser makes the caller block the user in the path, ending any friendship between them.
+func blockUser(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		otherUUID := mux.Vars(r)["uuid"]
+		if otherUUID == auth.UserID(r) {
+			http.Error(w, "you can't block yourself", http.StatusBadRequest)
+			return
+		}
 
+		err := graph.Block(r.Context(), auth.UserID(r), otherUUID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
 }
 
-func addFriend(w http.ResponseWriter, r *http.Request) {
-	otherUUID := mux.Vars(r)["uuid"]
-	uuid := getUUID(w, r)
-	gq := "g.addE('friends with').from(g.V().has('uuid', '" + uuid + "')).to(g.V().has('uuid', '" + otherUUID + "'))"
-	_, err := makeNeptuneRequest(gq)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// unblockUser lifts the caller's block on the user in the path.
+func unblockUser(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		otherUUID := mux.Vars(r)["uuid"]
+		err := graph.Unblock(r.Context(), auth.UserID(r), otherUUID)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 	}
+}
 
-	gq = "g.addE('friends with').from(g.V().has('uuid', '" + otherUUID + "')).to(g.V().has('uuid', '" + uuid + "'))"
-	_, err = makeNeptuneRequest(gq)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+// followUser makes the caller follow the user in the path.
+func followUser(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := graph.Follow(r.Context(), auth.UserID(r), mux.Vars(r)["uuid"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 	}
-	return
 }
 
-func addUser (w http.ResponseWriter, r *http.Request) {
-	uuid := getUUID(w, r)
-	gq := "g.addV().property('uuid', '" + uuid + "')"
-	_, err := makeNeptuneRequest(gq)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	
-	return
-}
-
-// func deleteFriend(w http.ResponseWriter, r *http.Request) {
-// 	otherUUID := mux.Vars(r)["uuid"]
-// 	uuid := getUUID(w, r)
-//   _, err := gremlinClient.Execute("g.V().bothE().filter(hasLabel('friends with')).where(inV().has('uuid', '" + uuid + "')).where(otherV().has('uuid', '" + otherUUID + "')).drop()")
-// 	if err != nil {
-// 		http.Error(w, err.Error(), http.StatusInternalServerError)
-// 		log.Print(err.Error())
-// 		return
-// 	}
-// }
-
-// func mutualFriends(w http.ResponseWriter, r *http.Request) {
-// 	otherUUID := mux.Vars(r)["uuid"]
-// 	uuid := getUUID(w, r)
-// 	isFriend, err := gremlinClient.Execute("g.V().has('uuid', '" + uuid + "').both('friends with').and(both('friends with').has('uuid', '" +  otherUUID + "'))")
-// 	if err != nil {
-// 		http.Error(w, err.Error(), http.StatusInternalServerError)
-// 		log.Print(err.Error())
-// 		return
-// 	}
-// 	json.NewEncoder(w).Encode(isFriend[0].Result.Data)
-// }
-
-func makeNeptuneRequest(gremlinQuery string) (map[string]interface{}, error) {
-	req_body := make(map[string]string)
-	req_body["gremlin"] = gremlinQuery
-	jsonValue, _ := json.Marshal(req_body)
-	resp, err := http.Post(NeptuneURL, "application/json", bytes.NewBuffer(jsonValue))
+// unfollowUser makes the caller stop following the user in the path.
+func unfollowUser(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := graph.Unfollow(r.Context(), auth.UserID(r), mux.Vars(r)["uuid"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+}
+
+// getFollowers returns a page of the users following the user in the path.
+func getFollowers(graph FriendGraph) http.HandlerFunc {
+	return listFollows(graph, graph.Followers, func(counts FollowCounts) int { return counts.Followers })
+}
+
+// getFollowing returns a page of the users the user in the path follows.
+func getFollowing(graph FriendGraph) http.HandlerFunc {
+	return listFollows(graph, graph.Following, func(counts FollowCounts) int { return counts.Following })
+}
+
+// listFollows returns a handler that responds with a followsPage of the users list finds for the user
+// in the path, starting at the offset query parameter, with the total from count.
+func listFollows(graph FriendGraph, list func(ctx context.Context, uuid string, offset, limit int) ([]string, error),
+	count func(FollowCounts) int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offset, err := queryInt(r, "offset", 0)
+		if err != nil || offset < 0 {
+			http.Error(w, "offset must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		limit, err := queryInt(r, "limit", defaultFollows)
+		if err != nil || limit < 1 || limit > maxFollows {
+			http.Error(w, fmt.Sprintf("limit must be an integer from 1 to %d", maxFollows), http.StatusBadRequest)
+			return
+		}
+
+		uuid := mux.Vars(r)["uuid"]
+		counts, err := graph.FollowCounts(r.Context(), uuid)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		users, err := list(r.Context(), uuid, offset, limit)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(followsPage{Count: count(counts), Users: users})
+	}
+}
+
+// getFeedAuthors returns the UUIDs of everyone whose posts belong in the feed of the user in the path:
+// their friends and the users they follow, sorted.
+func getFeedAuthors(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid := mux.Vars(r)["uuid"]
+		friends, err := graph.Friends(r.Context(), uuid)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		authors := make(map[string]bool)
+		for _, friend := range friends {
+			authors[friend] = true
+		}
+		for offset := 0; ; offset += maxFollows {
+			following, err := graph.Following(r.Context(), uuid, offset, maxFollows)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+			for _, followee := range following {
+				authors[followee] = true
+			}
+			if len(following) < maxFollows {
+				break
+			}
+		}
+
+		uuids := []string{}
+		for author := range authors {
+			uuids = append(uuids, author)
+		}
+		sort.Strings(uuids)
+		json.NewEncoder(w).Encode(uuids)
+	}
+}
+
+// getBlockers returns the UUIDs of everyone who has blocked the user in the path, so other services can
+// hide their content from that user.
+func getBlockers(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		blockers, err := graph.Blockers(r.Context(), mux.Vars(r)["uuid"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(blockers)
+	}
+}
+
+// friendsSince returns the user in the path as one of the caller's friends, including when they
+// became friends.
+func friendsSince(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		friend, err := graph.Friendship(r.Context(), auth.UserID(r), mux.Vars(r)["uuid"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(friend)
+	}
+}
+
+// getLists returns all of the caller's friend lists along with their members.
+func getLists(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lists, err := graph.Lists(r.Context(), auth.UserID(r))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(lists)
+	}
+}
+
+// getList returns the caller's friend list named in the path.
+func getList(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := graph.List(r.Context(), auth.UserID(r), mux.Vars(r)["name"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// createList creates an empty friend list for the caller with the name in the path.
+func createList(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := mux.Vars(r)["name"]
+		if strings.TrimSpace(name) == "" || utf8.RuneCountInString(name) > maxListName {
+			http.Error(w, fmt.Sprintf("list name must be 1 to %d characters", maxListName), http.StatusBadRequest)
+			return
+		}
+
+		err := graph.CreateList(r.Context(), auth.UserID(r), name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+}
+
+// deleteList deletes the caller's friend list named in the path.
+func deleteList(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := graph.DeleteList(r.Context(), auth.UserID(r), mux.Vars(r)["name"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+}
+
+// addToList puts the friend in the path into the caller's friend list named in the path.
+func addToList(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		err := graph.AddToList(r.Context(), auth.UserID(r), vars["name"], vars["uuid"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+}
+
+// removeFromList takes the user in the path out of the caller's friend list named in the path.
+func removeFromList(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		err := graph.RemoveFromList(r.Context(), auth.UserID(r), vars["name"], vars["uuid"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+}
+
+// inList reports whether the user in the path has put member into their friend list named in the
+// path, so other services can restrict content to the people in a list.
+func inList(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		found, err := graph.InList(r.Context(), vars["uuid"], vars["name"], vars["member"])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(found)
+	}
+}
+
+// exportGraph responds with an export of every user and friendship in the graph, one JSON object per
+// line.
+func exportGraph(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Export writes as it goes, so the graph is read into a buffer first to be able to report errors
+		var export bytes.Buffer
+		err := Export(r.Context(), graph, &export)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		export.WriteTo(w)
+	}
+}
+
+// importGraph replays the export in the request body into the graph and responds with the ImportStats.
+// Exports that can't be read, or that refer to users they don't hold, get a 400 Bad Request.
+func importGraph(graph FriendGraph) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := Import(r.Context(), graph, r.Body)
+		switch {
+		case errors.Is(err, ErrInvalidExport), errors.Is(err, ErrUserNotFound), errors.Is(err, ErrSelfFriend):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		json.NewEncoder(w).Encode(stats)
+	}
+}
+
+// queryInt parses the query parameter key as an integer, returning fallback if it isn't set.
+func queryInt(r *http.Request, key string, fallback int) (int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// encodeCursor returns an opaque cursor for the page that starts just after friend in the given order.
+func encodeCursor(order FriendOrder, friend Friend) string {
+	cursor := fmt.Sprintf("%s|%d|%s", order, toMillis(friend.CreatedAt), friend.UUID)
+	return base64.RawURLEncoding.EncodeToString([]byte(cursor))
+}
+
+// decodeCursor returns the friend a cursor made by encodeCursor points after. Cursors only work with
+// the order they were made for.
+func decodeCursor(order FriendOrder, cursor string) (*Friend, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
-	response := make(map[string]interface{})
-	err = json.NewDecoder(resp.Body).Decode(&response)
+	parts := strings.SplitN(string(decoded), "|", 3)
+	if len(parts) != 3 || FriendOrder(parts[0]) != order {
+		return nil, errors.New("cursor is for a different order")
+	}
+	createdAt, err := strconv.ParseInt(parts[1], 10, 64)
 	if err != nil {
 		return nil, err
 	}
-	return response, nil
+	return &Friend{UUID: parts[2], CreatedAt: fromMillis(createdAt)}, nil
+}
+
+// writeError responds with the HTTP status that matches err.
+func writeError(w http.ResponseWriter, err error) {
+	switch err {
+	case ErrUserNotFound, ErrRequestNotFound, ErrNotFriends, ErrListNotFound:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case ErrRequestPending, ErrAlreadyFriends:
+		http.Error(w, err.Error(), http.StatusConflict)
+	case ErrSelfFriend, ErrSelfFollow:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case ErrBlocked:
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		// Failed graph queries get a status that says whether retrying might help
+		status := http.StatusInternalServerError
+		var gremlinErr *GremlinError
+		if errors.As(err, &gremlinErr) {
+			status = gremlinErr.HTTPStatus()
+		}
+		http.Error(w, err.Error(), status)
+		log.Print(err.Error())
+	}
 }
@@ -0,0 +1,440 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/BearCloud/sp21-bearchat/common/profiles"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Sends a request to the friends routes as userID and returns the recorded response.
+func serveAs(t *testing.T, graph FriendGraph, userID, method, path string) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	require.NoError(t, RegisterRoutes(router, authenticateAs(userID), graph, profileDirectory{}))
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest(method, path, nil))
+	return rr
+}
+
+// Decodes a JSON list of UUIDs from a response.
+func decodeUUIDs(t *testing.T, rr *httptest.ResponseRecorder) []string {
+	uuids := []string{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&uuids))
+	return uuids
+}
+
+// A profiles.Directory holding a fixed set of profiles. Looking up "error" fails.
+type profileDirectory map[string]profiles.Profile
+
+func (d profileDirectory) Profiles(ctx context.Context, uuids []string) (map[string]profiles.Profile, error) {
+	found := map[string]profiles.Profile{}
+	for _, uuid := range uuids {
+		if uuid == "error" {
+			return nil, errors.New("profiles service is down")
+		}
+		if profile, ok := d[uuid]; ok {
+			found[uuid] = profile
+		}
+	}
+	return found, nil
+}
+
+// Walks a friend request through the endpoints, from being sent to being accepted.
+func TestFriendRequests(t *testing.T) {
+	ctx := context.Background()
+	graph := NewMemoryGraph()
+	for _, user := range []string{"oski", "stanfurd", "bruin"} {
+		require.NoError(t, graph.AddUser(ctx, user))
+	}
+
+	rr := serveAs(t, graph, "oski", http.MethodPost, "/api/friends/stanfurd")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, graph, "oski", http.MethodPost, "/api/friends/stanfurd")
+	assert.Equal(t, http.StatusOK, rr.Code, "sending the same request twice should do nothing")
+	rr = serveAs(t, graph, "stanfurd", http.MethodPost, "/api/friends/oski")
+	assert.Equal(t, http.StatusConflict, rr.Code, "a request back should conflict with the pending one")
+
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends/stanfurd")
+	assert.Equal(t, "false", rr.Body.String(), "they should not be friends before the request is accepted")
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends/requests/outgoing")
+	assert.Equal(t, []string{"stanfurd"}, decodeUUIDs(t, rr))
+	rr = serveAs(t, graph, "stanfurd", http.MethodGet, "/api/friends/requests/incoming")
+	assert.Equal(t, []string{"oski"}, decodeUUIDs(t, rr))
+
+	// Only the recipient can accept the request.
+	rr = serveAs(t, graph, "oski", http.MethodPost, "/api/friends/requests/stanfurd/accept")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = serveAs(t, graph, "stanfurd", http.MethodPost, "/api/friends/requests/oski/accept")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveAs(t, graph, "stanfurd", http.MethodGet, "/api/friends")
+	assert.Equal(t, []string{"oski"}, decodeUUIDs(t, rr))
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends/stanfurd")
+	assert.Equal(t, "true", rr.Body.String())
+	rr = serveAs(t, graph, "stanfurd", http.MethodGet, "/api/friends/requests/incoming")
+	assert.Empty(t, decodeUUIDs(t, rr))
+}
+
+// Checks that declined and cancelled requests don't make anyone friends.
+func TestDeclineAndCancelRequests(t *testing.T) {
+	ctx := context.Background()
+	graph := NewMemoryGraph()
+	for _, user := range []string{"oski", "stanfurd", "bruin"} {
+		require.NoError(t, graph.AddUser(ctx, user))
+	}
+
+	require.Equal(t, http.StatusOK, serveAs(t, graph, "stanfurd", http.MethodPost, "/api/friends/oski").Code)
+	rr := serveAs(t, graph, "oski", http.MethodPost, "/api/friends/requests/stanfurd/decline")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	require.Equal(t, http.StatusOK, serveAs(t, graph, "bruin", http.MethodPost, "/api/friends/oski").Code)
+	// Only the sender can cancel the request.
+	rr = serveAs(t, graph, "oski", http.MethodDelete, "/api/friends/requests/bruin")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = serveAs(t, graph, "bruin", http.MethodDelete, "/api/friends/requests/oski")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends")
+	assert.Empty(t, decodeUUIDs(t, rr))
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends/requests/incoming")
+	assert.Empty(t, decodeUUIDs(t, rr))
+}
+
+// Builds a graph where oski is friends with stanfurd and bruin, and stanfurd is friends with bruin and
+// tree.
+func newFriendsFixture(t *testing.T) *MemoryGraph {
+	ctx := context.Background()
+	graph := NewMemoryGraph()
+	for _, user := range []string{"oski", "stanfurd", "bruin", "tree"} {
+		require.NoError(t, graph.AddUser(ctx, user))
+	}
+	for _, pair := range [][2]string{{"oski", "stanfurd"}, {"oski", "bruin"}, {"stanfurd", "bruin"}, {"stanfurd", "tree"}} {
+		require.NoError(t, graph.AddFriendship(ctx, pair[0], pair[1]))
+	}
+	return graph
+}
+
+// Checks that unfriending someone removes the friendship from both sides.
+func TestDeleteFriend(t *testing.T) {
+	graph := newFriendsFixture(t)
+
+	rr := serveAs(t, graph, "stanfurd", http.MethodDelete, "/api/friends/oski")
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends/stanfurd")
+	assert.Equal(t, "false", rr.Body.String())
+	rr = serveAs(t, graph, "stanfurd", http.MethodGet, "/api/friends/oski")
+	assert.Equal(t, "false", rr.Body.String())
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends")
+	assert.Equal(t, []string{"bruin"}, decodeUUIDs(t, rr))
+	rr = serveAs(t, graph, "stanfurd", http.MethodGet, "/api/friends")
+	assert.Equal(t, []string{"bruin", "tree"}, decodeUUIDs(t, rr))
+
+	// Unfriending someone you aren't friends with does nothing.
+	rr = serveAs(t, graph, "oski", http.MethodDelete, "/api/friends/tree")
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+// Checks that the mutual friends endpoint returns the intersection of both friend lists.
+func TestMutualFriends(t *testing.T) {
+	graph := newFriendsFixture(t)
+
+	tests := []struct {
+		caller, other string
+		mutuals       []string
+	}{
+		{"oski", "stanfurd", []string{"bruin"}},
+		{"stanfurd", "oski", []string{"bruin"}},
+		{"oski", "tree", []string{"stanfurd"}},
+		{"bruin", "tree", []string{"stanfurd"}},
+		{"oski", "nobody", []string{}},
+	}
+	for _, test := range tests {
+		rr := serveAs(t, graph, test.caller, http.MethodGet, "/api/friends/"+test.other+"/mutual")
+		require.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, test.mutuals, decodeUUIDs(t, rr), "mutual friends of %s and %s", test.caller, test.other)
+	}
+}
+
+// Checks that suggestions are paginated with the offset and limit query parameters.
+func TestSuggestFriends(t *testing.T) {
+	graph := newSuggestionsFixture(t, NewMemoryGraph())
+
+	tests := []struct {
+		query       string
+		code        int
+		suggestions []Suggestion
+	}{
+		{"", http.StatusOK, []Suggestion{{"duck", 3}, {"husky", 2}, {"trojan", 1}}},
+		{"?limit=2", http.StatusOK, []Suggestion{{"duck", 3}, {"husky", 2}}},
+		{"?offset=2&limit=2", http.StatusOK, []Suggestion{{"trojan", 1}}},
+		{"?offset=-1", http.StatusBadRequest, nil},
+		{"?limit=0", http.StatusBadRequest, nil},
+		{"?limit=1000", http.StatusBadRequest, nil},
+		{"?limit=ten", http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		rr := serveAs(t, graph, "oski", http.MethodGet, "/api/friends/suggestions"+test.query)
+		require.Equal(t, test.code, rr.Code, "GET /api/friends/suggestions%s", test.query)
+		if test.code == http.StatusOK {
+			suggestions := []Suggestion{}
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&suggestions))
+			assert.Equal(t, test.suggestions, suggestions)
+		}
+	}
+}
+
+// Checks the block endpoints, and that other services can see who has blocked a user.
+func TestBlocking(t *testing.T) {
+	graph := newFriendsFixture(t)
+
+	rr := serveAs(t, graph, "oski", http.MethodPost, "/api/friends/oski/block")
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "users shouldn't be able to block themselves")
+	rr = serveAs(t, graph, "oski", http.MethodPost, "/api/friends/nobody/block")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	rr = serveAs(t, graph, "oski", http.MethodPost, "/api/friends/stanfurd/block")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends")
+	assert.Equal(t, []string{"bruin"}, decodeUUIDs(t, rr), "blocking should end the friendship")
+	rr = serveAs(t, graph, "stanfurd", http.MethodPost, "/api/friends/oski")
+	assert.Equal(t, http.StatusForbidden, rr.Code, "blocked users shouldn't be able to send friend requests")
+
+	router := mux.NewRouter()
+	RegisterInternalRoutes(router, auth.InternalMiddleware("secret"), graph)
+	r := httptest.NewRequest(http.MethodGet, "/internal/friends/stanfurd/blockers", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code, "internal routes need the internal token")
+
+	r.Header.Set(auth.InternalTokenHeader, "secret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"oski"}, decodeUUIDs(t, rr))
+
+	rr = serveAs(t, graph, "oski", http.MethodDelete, "/api/friends/stanfurd/block")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, graph, "stanfurd", http.MethodPost, "/api/friends/oski")
+	assert.Equal(t, http.StatusOK, rr.Code, "unblocked users should be able to send friend requests")
+}
+
+// Checks that addUser and addFriend can be retried, and that addFriend validates its target.
+func TestAddValidation(t *testing.T) {
+	graph := NewMemoryGraph()
+
+	for i := 0; i < 2; i++ {
+		rr := serveAs(t, graph, "oski", http.MethodPost, "/api/friends")
+		assert.Equal(t, http.StatusOK, rr.Code, "adding a user should be idempotent")
+	}
+	require.Equal(t, http.StatusOK, serveAs(t, graph, "stanfurd", http.MethodPost, "/api/friends").Code)
+
+	rr := serveAs(t, graph, "oski", http.MethodPost, "/api/friends/oski")
+	assert.Equal(t, http.StatusBadRequest, rr.Code, "users shouldn't be able to friend themselves")
+	rr = serveAs(t, graph, "oski", http.MethodPost, "/api/friends/nobody")
+	assert.Equal(t, http.StatusNotFound, rr.Code, "friending a missing user should 404")
+
+	for i := 0; i < 2; i++ {
+		rr = serveAs(t, graph, "oski", http.MethodPost, "/api/friends/stanfurd")
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	rr = serveAs(t, graph, "stanfurd", http.MethodPost, "/api/friends/requests/oski/accept")
+	require.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends")
+	assert.Equal(t, []string{"stanfurd"}, decodeUUIDs(t, rr))
+}
+
+// Pages through oski's friends with and without their profiles.
+func TestListFriends(t *testing.T) {
+	ctx := context.Background()
+	graph := NewMemoryGraph()
+	for _, user := range []string{"oski", "stanfurd", "bruin", "tree"} {
+		require.NoError(t, graph.AddUser(ctx, user))
+	}
+	start := time.Date(2020, time.August, 26, 9, 0, 0, 0, time.UTC)
+	useClock(t, start, start.Add(time.Minute), start.Add(2*time.Minute))
+	for _, friend := range []string{"tree", "stanfurd", "bruin"} {
+		require.NoError(t, graph.AddFriendship(ctx, "oski", friend))
+	}
+
+	// Decodes a page of friends from a response.
+	decodePage := func(rr *httptest.ResponseRecorder) friendsPage {
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		page := friendsPage{}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+		return page
+	}
+
+	page := decodePage(serveAs(t, graph, "oski", http.MethodGet, "/api/friends?order=newest&limit=2"))
+	assert.Equal(t, []friendEntry{
+		{Friend: Friend{UUID: "bruin", CreatedAt: start.Add(2 * time.Minute)}},
+		{Friend: Friend{UUID: "stanfurd", CreatedAt: start.Add(time.Minute)}},
+	}, page.Friends)
+	require.NotEmpty(t, page.NextCursor)
+
+	page = decodePage(serveAs(t, graph, "oski", http.MethodGet, "/api/friends?order=newest&limit=2&cursor="+page.NextCursor))
+	assert.Equal(t, []friendEntry{{Friend: Friend{UUID: "tree", CreatedAt: start}}}, page.Friends)
+	assert.Empty(t, page.NextCursor, "the last page should have no cursor")
+
+	// Cursors only work with the order they were made for
+	page = decodePage(serveAs(t, graph, "oski", http.MethodGet, "/api/friends?order=oldest&limit=1"))
+	rr := serveAs(t, graph, "oski", http.MethodGet, "/api/friends?order=newest&cursor="+page.NextCursor)
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	for _, query := range []string{"limit=0", "limit=101", "limit=ten", "order=random", "cursor=!!", "include=posts"} {
+		rr := serveAs(t, graph, "oski", http.MethodGet, "/api/friends?"+query)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "%s should be rejected", query)
+	}
+
+	// Without any parameters the response is still a plain list of UUIDs
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends")
+	assert.Equal(t, []string{"bruin", "stanfurd", "tree"}, decodeUUIDs(t, rr))
+}
+
+// Checks that friends' profiles are joined in when asked for.
+func TestListFriendsWithProfiles(t *testing.T) {
+	graph := newFriendsFixture(t)
+	directory := profileDirectory{"bruin": {Firstname: "Joe", Lastname: "Bruin", UUID: "bruin"}}
+	serve := func(path string) *httptest.ResponseRecorder {
+		router := mux.NewRouter()
+		require.NoError(t, RegisterRoutes(router, authenticateAs("oski"), graph, directory))
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	rr := serve("/api/friends?include=profiles")
+	require.Equal(t, http.StatusOK, rr.Code)
+	page := friendsPage{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+	require.Len(t, page.Friends, 2)
+	assert.Equal(t, "bruin", page.Friends[0].UUID)
+	assert.Equal(t, &profiles.Profile{Firstname: "Joe", Lastname: "Bruin", UUID: "bruin"}, page.Friends[0].Profile)
+	assert.Equal(t, "stanfurd", page.Friends[1].UUID)
+	assert.Nil(t, page.Friends[1].Profile, "friends without a profile should have none")
+
+	// The list isn't returned without the profiles it asked for
+	require.NoError(t, graph.AddUser(context.Background(), "error"))
+	require.NoError(t, graph.AddFriendship(context.Background(), "oski", "error"))
+	rr = serve("/api/friends?include=profiles")
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+}
+
+// Walks a friend list through its endpoints, and checks that other services can ask who is in it.
+func TestFriendLists(t *testing.T) {
+	graph := newFriendsFixture(t)
+
+	rr := serveAs(t, graph, "oski", http.MethodPut, "/api/friends/lists/close%20friends")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, graph, "oski", http.MethodPut, "/api/friends/lists/close%20friends/stanfurd")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, graph, "oski", http.MethodPut, "/api/friends/lists/close%20friends/tree")
+	assert.Equal(t, http.StatusNotFound, rr.Code, "only friends can be put in a list")
+	rr = serveAs(t, graph, "oski", http.MethodPut, "/api/friends/lists/enemies/stanfurd")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	rr = serveAs(t, graph, "oski", http.MethodPut, "/api/friends/lists/"+strings.Repeat("a", maxListName+1))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends/lists")
+	require.Equal(t, http.StatusOK, rr.Code)
+	lists := []FriendList{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&lists))
+	assert.Equal(t, []FriendList{{"close friends", []string{"stanfurd"}}}, lists)
+	rr = serveAs(t, graph, "stanfurd", http.MethodGet, "/api/friends/lists/close%20friends")
+	assert.Equal(t, http.StatusNotFound, rr.Code, "lists should only be visible to their owner")
+
+	router := mux.NewRouter()
+	RegisterInternalRoutes(router, auth.InternalMiddleware("secret"), graph)
+	inList := func(path string) string {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set(auth.InternalTokenHeader, "secret")
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+		require.Equal(t, http.StatusOK, rr.Code)
+		return strings.TrimSpace(rr.Body.String())
+	}
+	assert.Equal(t, "true", inList("/internal/friends/oski/lists/close%20friends/stanfurd"))
+	assert.Equal(t, "false", inList("/internal/friends/oski/lists/close%20friends/bruin"))
+	assert.Equal(t, "false", inList("/internal/friends/stanfurd/lists/close%20friends/oski"))
+
+	rr = serveAs(t, graph, "oski", http.MethodDelete, "/api/friends/lists/close%20friends/stanfurd")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "false", inList("/internal/friends/oski/lists/close%20friends/stanfurd"))
+	rr = serveAs(t, graph, "oski", http.MethodDelete, "/api/friends/lists/close%20friends")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends/lists/close%20friends")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+// Checks that the caller can find out when they became friends with someone.
+func TestFriendsSince(t *testing.T) {
+	start := time.Date(2020, time.August, 26, 9, 0, 0, 0, time.UTC)
+	useClock(t, start, start.Add(time.Hour), start.Add(2*time.Hour), start.Add(3*time.Hour))
+	graph := newFriendsFixture(t)
+
+	rr := serveAs(t, graph, "bruin", http.MethodGet, "/api/friends/oski/since")
+	require.Equal(t, http.StatusOK, rr.Code)
+	friend := Friend{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&friend))
+	assert.Equal(t, Friend{UUID: "oski", CreatedAt: start.Add(time.Hour)}, friend)
+
+	rr = serveAs(t, graph, "oski", http.MethodGet, "/api/friends/tree/since")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+// Checks the follow endpoints, and that other services can find whose posts belong in a user's feed.
+func TestFollows(t *testing.T) {
+	graph := newFriendsFixture(t)
+
+	for _, follower := range []string{"bruin", "tree"} {
+		rr := serveAs(t, graph, follower, http.MethodPost, "/api/friends/oski/follow")
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	rr := serveAs(t, graph, "oski", http.MethodPost, "/api/friends/oski/follow")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	rr = serveAs(t, graph, "oski", http.MethodPost, "/api/friends/nobody/follow")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+
+	// Decodes a page of follows from a response.
+	decodeFollows := func(rr *httptest.ResponseRecorder) followsPage {
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		page := followsPage{}
+		require.NoError(t, json.NewDecoder(rr.Body).Decode(&page))
+		return page
+	}
+	assert.Equal(t, followsPage{Count: 2, Users: []string{"bruin", "tree"}}, decodeFollows(serveAs(t, graph, "stanfurd", http.MethodGet, "/api/friends/oski/followers")))
+	assert.Equal(t, followsPage{Count: 2, Users: []string{"tree"}}, decodeFollows(serveAs(t, graph, "stanfurd", http.MethodGet, "/api/friends/oski/followers?offset=1&limit=1")))
+	assert.Equal(t, followsPage{Count: 1, Users: []string{"oski"}}, decodeFollows(serveAs(t, graph, "oski", http.MethodGet, "/api/friends/tree/following")))
+	for _, query := range []string{"offset=-1", "limit=0", "limit=101"} {
+		rr := serveAs(t, graph, "oski", http.MethodGet, "/api/friends/oski/followers?"+query)
+		assert.Equal(t, http.StatusBadRequest, rr.Code, "%s should be rejected", query)
+	}
+
+	// Following someone doesn't make them a friend
+	rr = serveAs(t, graph, "tree", http.MethodGet, "/api/friends/oski")
+	assert.Equal(t, "false", rr.Body.String())
+
+	// tree's feed has the friend stanfurd and the followed oski
+	router := mux.NewRouter()
+	RegisterInternalRoutes(router, auth.InternalMiddleware("secret"), graph)
+	r := httptest.NewRequest(http.MethodGet, "/internal/friends/tree/feed-authors", nil)
+	r.Header.Set(auth.InternalTokenHeader, "secret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []string{"oski", "stanfurd"}, decodeUUIDs(t, rr))
+
+	rr = serveAs(t, graph, "tree", http.MethodDelete, "/api/friends/oski/follow")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, followsPage{Count: 0, Users: []string{}}, decodeFollows(serveAs(t, graph, "oski", http.MethodGet, "/api/friends/tree/following")))
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	// MySQL driver
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// InitDB creates a connection to the given database on the MySQL server. The friends service always
+// connects to auth, which holds the sessions table used to check whether a token has been revoked,
+// and to friendsDB when the graph is stored in MySQL.
+func InitDB(database string) *sql.DB {
+	log.Println("attempting connections")
+	DB, err := sql.Open("mysql", "root:root@tcp(172.28.1.2:3306)/"+database+"?parseTime=true")
+
+	if err != nil {
+		log.Print(err.Error())
+		panic(err)
+	}
+
+	// Repeatedly Ping the database until no error to ensure it is up.
+	for err = DB.Ping(); err != nil; err = DB.Ping() {
+		log.Println("couldnt connect, waiting 10 seconds before retrying")
+		time.Sleep(10 * time.Second)
+	}
+
+	return DB
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// An export is a JSON object on each line. The first line is a header naming the format and its
+// version, followed by a line for each user and then a line for each friendship:
+//
+//	{"type":"header","format":"bearchat-friends","version":1}
+//	{"type":"user","uuid":"..."}
+//	{"type":"friendship","a":"...","b":"...","createdAt":"2021-03-01T12:00:00.000Z"}
+//
+// Exports only hold users and friendships. Friend requests, blocks, follows and friend lists are left
+// out.
+const (
+	// ExportFormat is the name every export's header carries.
+	ExportFormat = "bearchat-friends"
+	// ExportVersion is the version of the format Export writes. Import reads any version up to it.
+	ExportVersion = 1
+)
+
+// Record types, which tell what each line of an export holds.
+const (
+	recordHeader     = "header"
+	recordUser       = "user"
+	recordFriendship = "friendship"
+)
+
+// ErrInvalidExport is returned by Import when its input isn't an export it can read.
+var ErrInvalidExport = errors.New("invalid export")
+
+// An exportRecord is one line of an export. Which fields are set depends on Type.
+type exportRecord struct {
+	Type      string     `json:"type"`
+	Format    string     `json:"format,omitempty"`
+	Version   int        `json:"version,omitempty"`
+	UUID      string     `json:"uuid,omitempty"`
+	A         string     `json:"a,omitempty"`
+	B         string     `json:"b,omitempty"`
+	CreatedAt *time.Time `json:"createdAt,omitempty"`
+}
+
+// ImportStats counts the records an import replayed. Records for users and friendships that were
+// already in the graph are counted too.
+type ImportStats struct {
+	Users       int `json:"users"`
+	Friendships int `json:"friendships"`
+}
+
+// Export writes every user and friendship in graph to w, in the current version of the export format.
+func Export(ctx context.Context, graph FriendGraph, w io.Writer) error {
+	users, err := graph.Users(ctx)
+	if err != nil {
+		return err
+	}
+	friendships, err := graph.Friendships(ctx)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(w)
+	err = encoder.Encode(exportRecord{Type: recordHeader, Format: ExportFormat, Version: ExportVersion})
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		err = encoder.Encode(exportRecord{Type: recordUser, UUID: user})
+		if err != nil {
+			return err
+		}
+	}
+	for _, friendship := range friendships {
+		createdAt := friendship.CreatedAt.UTC()
+		err = encoder.Encode(exportRecord{Type: recordFriendship, A: friendship.A, B: friendship.B, CreatedAt: &createdAt})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import replays an export read from r into graph. Users and friendships that are already in the graph
+// are left as they are, so importing the same export again changes nothing, and an import that failed
+// partway can simply be run again. Errors name the line they happened on, and wrap ErrInvalidExport if
+// the line couldn't be read.
+func Import(ctx context.Context, graph FriendGraph, r io.Reader) (ImportStats, error) {
+	stats := ImportStats{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+
+	line, header := 0, false
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		record := exportRecord{}
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return stats, fmt.Errorf("line %d: %w: %s", line, ErrInvalidExport, err)
+		}
+
+		if !header {
+			if record.Type != recordHeader || record.Format != ExportFormat {
+				return stats, fmt.Errorf("line %d: %w: missing %s header", line, ErrInvalidExport, ExportFormat)
+			}
+			if record.Version < 1 || record.Version > ExportVersion {
+				return stats, fmt.Errorf("line %d: %w: unsupported version %d", line, ErrInvalidExport, record.Version)
+			}
+			header = true
+			continue
+		}
+
+		switch record.Type {
+		case recordUser:
+			if record.UUID == "" {
+				return stats, fmt.Errorf("line %d: %w: user has no uuid", line, ErrInvalidExport)
+			}
+			err = graph.AddUser(ctx, record.UUID)
+			if err != nil {
+				return stats, fmt.Errorf("line %d: %w", line, err)
+			}
+			stats.Users++
+		case recordFriendship:
+			if record.A == "" || record.B == "" || record.CreatedAt == nil {
+				return stats, fmt.Errorf("line %d: %w: friendship needs a, b and createdAt", line, ErrInvalidExport)
+			}
+			err = graph.RestoreFriendship(ctx, FriendshipEdge{A: record.A, B: record.B, CreatedAt: *record.CreatedAt})
+			if err != nil {
+				return stats, fmt.Errorf("line %d: %w", line, err)
+			}
+			stats.Friendships++
+		default:
+			return stats, fmt.Errorf("line %d: %w: unknown record type %q", line, ErrInvalidExport, record.Type)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return stats, err
+	}
+	if !header {
+		return stats, fmt.Errorf("%w: empty export", ErrInvalidExport)
+	}
+	return stats, nil
+}
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// An export of the friends fixture, as of the times it was made at.
+const fixtureExport = `{"type":"header","format":"bearchat-friends","version":1}
+{"type":"user","uuid":"bruin"}
+{"type":"user","uuid":"oski"}
+{"type":"user","uuid":"stanfurd"}
+{"type":"user","uuid":"tree"}
+{"type":"friendship","a":"bruin","b":"oski","createdAt":"2021-03-01T12:00:00.001Z"}
+{"type":"friendship","a":"bruin","b":"stanfurd","createdAt":"2021-03-01T12:00:00.002Z"}
+{"type":"friendship","a":"oski","b":"stanfurd","createdAt":"2021-03-01T12:00:00Z"}
+{"type":"friendship","a":"stanfurd","b":"tree","createdAt":"2021-03-01T12:00:00.003Z"}
+`
+
+// Exports a graph, then imports it into an empty one twice.
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	useClock(t, start, start.Add(time.Millisecond), start.Add(2*time.Millisecond), start.Add(3*time.Millisecond))
+	graph := newFriendsFixture(t)
+
+	var export bytes.Buffer
+	require.NoError(t, Export(ctx, graph, &export))
+	assert.Equal(t, fixtureExport, export.String())
+
+	restored := NewMemoryGraph()
+	for i := 0; i < 2; i++ {
+		stats, err := Import(ctx, restored, strings.NewReader(fixtureExport))
+		require.NoError(t, err)
+		assert.Equal(t, ImportStats{Users: 4, Friendships: 4}, stats)
+	}
+	export.Reset()
+	require.NoError(t, Export(ctx, restored, &export))
+	assert.Equal(t, fixtureExport, export.String(), "importing twice should give the same graph")
+}
+
+// Checks that Import rejects what it can't read, naming the line at fault.
+func TestImportInvalid(t *testing.T) {
+	header := `{"type":"header","format":"bearchat-friends","version":1}` + "\n"
+	for name, test := range map[string]struct {
+		export string
+		line   string
+	}{
+		"Empty":           {"", ""},
+		"No Header":       {`{"type":"user","uuid":"oski"}`, "line 1"},
+		"Newer Version":   {`{"type":"header","format":"bearchat-friends","version":2}`, "line 1"},
+		"Not JSON":        {header + "oski", "line 2"},
+		"Unknown Type":    {header + `{"type":"follow","a":"oski","b":"tree"}`, "line 2"},
+		"No UUID":         {header + `{"type":"user"}`, "line 2"},
+		"No Created Time": {header + `{"type":"user","uuid":"oski"}` + "\n" + `{"type":"friendship","a":"oski","b":"tree"}`, "line 3"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Import(context.Background(), NewMemoryGraph(), strings.NewReader(test.export))
+			assert.True(t, errors.Is(err, ErrInvalidExport), "expected an invalid export, got %v", err)
+			assert.Contains(t, err.Error(), test.line)
+		})
+	}
+
+	// A friendship with a user the export doesn't hold stops the import
+	_, err := Import(context.Background(), NewMemoryGraph(), strings.NewReader(header+`{"type":"friendship","a":"oski","b":"tree","createdAt":"2021-03-01T12:00:00Z"}`))
+	assert.True(t, errors.Is(err, ErrUserNotFound), "expected a missing user, got %v", err)
+}
+
+// Checks that only admins can reach the export and import endpoints.
+func TestAdminRoutes(t *testing.T) {
+	graph := newFriendsFixture(t)
+
+	// Sends a request to the admin routes as userID.
+	serveAdmin := func(userID, method, path, body string) *httptest.ResponseRecorder {
+		router := mux.NewRouter()
+		RegisterAdminRoutes(router, authenticateAs(userID), []string{"oski"}, graph)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := serveAdmin("stanfurd", http.MethodGet, "/admin/friends/export", "")
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+	rr = serveAdmin("stanfurd", http.MethodPost, "/admin/friends/import", fixtureExport)
+	assert.Equal(t, http.StatusForbidden, rr.Code)
+
+	rr = serveAdmin("oski", http.MethodGet, "/admin/friends/export", "")
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/x-ndjson", rr.Header().Get("Content-Type"))
+	assert.Contains(t, rr.Body.String(), `{"type":"user","uuid":"tree"}`)
+
+	rr = serveAdmin("oski", http.MethodPost, "/admin/friends/import", rr.Body.String())
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	stats := ImportStats{}
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&stats))
+	assert.Equal(t, ImportStats{Users: 4, Friendships: 4}, stats)
+
+	rr = serveAdmin("oski", http.MethodPost, "/admin/friends/import", "not an export")
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+}
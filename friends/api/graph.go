@@ -0,0 +1,241 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+var (
+	// ErrUserNotFound is returned when an operation refers to a user that isn't in the graph.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrRequestNotFound is returned when there is no pending friend request to accept, decline or cancel.
+	ErrRequestNotFound = errors.New("friend request not found")
+	// ErrRequestPending is returned when sending a friend request to someone who already has a pending
+	// request to the sender.
+	ErrRequestPending = errors.New("friend request already pending")
+	// ErrAlreadyFriends is returned when sending a friend request to someone who is already a friend.
+	ErrAlreadyFriends = errors.New("already friends")
+	// ErrSelfFriend is returned when a user tries to befriend themselves.
+	ErrSelfFriend = errors.New("you can't be friends with yourself")
+	// ErrBlocked is returned when sending a friend request between two users where one has blocked the
+	// other.
+	ErrBlocked = errors.New("user is blocked")
+	// ErrSelfFollow is returned when a user tries to follow themselves.
+	ErrSelfFollow = errors.New("you can't follow yourself")
+	// ErrNotFriends is returned when an operation needs two users to be friends and they aren't.
+	ErrNotFriends = errors.New("not friends")
+	// ErrListNotFound is returned when a user has no friend list with the given name.
+	ErrListNotFound = errors.New("friend list not found")
+)
+
+// RequestState is the state of a friend request. Every request starts out pending, and moves to one
+// of the other states exactly once.
+type RequestState string
+
+const (
+	RequestPending   RequestState = "pending"
+	RequestAccepted  RequestState = "accepted"
+	RequestDeclined  RequestState = "declined"
+	RequestCancelled RequestState = "cancelled"
+)
+
+// A Suggestion is someone a user might know: a friend of a friend who isn't already their friend.
+type Suggestion struct {
+	UUID string `json:"uuid"`
+	// MutualFriends is the number of friends the suggested user has in common with the user.
+	MutualFriends int `json:"mutualFriends"`
+}
+
+// FriendOrder is an order friends can be listed in. Friends created at the same time are always
+// ordered by UUID.
+type FriendOrder string
+
+const (
+	// OrderByUUID lists friends by UUID.
+	OrderByUUID FriendOrder = "uuid"
+	// OrderNewest lists the most recent friendships first.
+	OrderNewest FriendOrder = "newest"
+	// OrderOldest lists the oldest friendships first.
+	OrderOldest FriendOrder = "oldest"
+)
+
+// A Friend is one of a user's friends, along with when they became friends.
+type Friend struct {
+	UUID      string    `json:"uuid"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// FollowCounts is how many users follow a user, and how many users they follow.
+type FollowCounts struct {
+	Followers int `json:"followers"`
+	Following int `json:"following"`
+}
+
+// A FriendshipEdge is a friendship between two users, as it is exported and imported. A is always
+// the lesser of the two UUIDs.
+type FriendshipEdge struct {
+	A         string    `json:"a"`
+	B         string    `json:"b"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// A FriendList is a named group of friends, such as "close friends", that a user has put together.
+// Only the owner can see their lists.
+type FriendList struct {
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// A FriendQuery picks out one page of a user's friends.
+type FriendQuery struct {
+	Order FriendOrder
+	// After is the last friend on the previous page, or nil for the first page.
+	After *Friend
+	Limit int
+}
+
+// before reports whether a comes before b in q's order.
+func (q FriendQuery) before(a, b Friend) bool {
+	switch {
+	case q.Order == OrderNewest && !a.CreatedAt.Equal(b.CreatedAt):
+		return a.CreatedAt.After(b.CreatedAt)
+	case q.Order == OrderOldest && !a.CreatedAt.Equal(b.CreatedAt):
+		return a.CreatedAt.Before(b.CreatedAt)
+	default:
+		return a.UUID < b.UUID
+	}
+}
+
+// sortFriendships sorts friendships by A and then by B.
+func sortFriendships(friendships []FriendshipEdge) {
+	sort.Slice(friendships, func(i, j int) bool {
+		if friendships[i].A != friendships[j].A {
+			return friendships[i].A < friendships[j].A
+		}
+		return friendships[i].B < friendships[j].B
+	})
+}
+
+// friendshipTime returns the time to record as the start of a friendship made now. Every backend keeps
+// times to the millisecond, so they compare the same no matter where they were stored.
+func friendshipTime() time.Time {
+	return now().UTC().Truncate(time.Millisecond)
+}
+
+// now is the clock friendships are timed with. Tests replace it to control the order of friendships.
+var now = time.Now
+
+// A FriendGraph stores users, the friendships between them, the friend requests that lead to
+// friendships, and who has blocked whom. Friendships are symmetric: once a and b are friends,
+// AreFriends and Friends see the friendship from both sides. Follows are one-way, need no request, and
+// are kept apart from friendships, so following someone never makes them a friend.
+type FriendGraph interface {
+	// AddUser adds a user to the graph. Adding a user who is already in the graph does nothing.
+	AddUser(ctx context.Context, uuid string) error
+	// AddFriendship makes a and b friends with each other. Making them friends again does nothing.
+	AddFriendship(ctx context.Context, a, b string) error
+	// RemoveFriendship ends the friendship between a and b, and takes each of them out of the other's
+	// friend lists.
+	RemoveFriendship(ctx context.Context, a, b string) error
+	// AreFriends reports whether a and b are friends.
+	AreFriends(ctx context.Context, a, b string) (bool, error)
+	// Friendship returns b as one of a's friends, including when they became friends, or
+	// ErrNotFriends if they aren't.
+	Friendship(ctx context.Context, a, b string) (Friend, error)
+	// Friends returns the UUIDs of everyone uuid is friends with.
+	Friends(ctx context.Context, uuid string) ([]string, error)
+	// ListFriends returns up to query.Limit of uuid's friends in query.Order, starting just after
+	// query.After.
+	ListFriends(ctx context.Context, uuid string, query FriendQuery) ([]Friend, error)
+	// Mutuals returns the UUIDs of everyone who is friends with both a and b.
+	Mutuals(ctx context.Context, a, b string) ([]string, error)
+
+	// SendRequest sends a friend request from one user to another. Sending the same request while it is
+	// still pending does nothing. A request that was declined or cancelled can be sent again, but no
+	// request can be sent if either user has blocked the other.
+	SendRequest(ctx context.Context, from, to string) error
+	// UpdateRequest moves the pending request from one user to another into state, which must be
+	// RequestAccepted, RequestDeclined or RequestCancelled. Accepting a request makes the two users
+	// friends.
+	UpdateRequest(ctx context.Context, from, to string, state RequestState) error
+	// IncomingRequests returns the UUIDs of everyone with a pending friend request to uuid.
+	IncomingRequests(ctx context.Context, uuid string) ([]string, error)
+	// OutgoingRequests returns the UUIDs of everyone uuid has a pending friend request to.
+	OutgoingRequests(ctx context.Context, uuid string) ([]string, error)
+
+	// Suggestions returns up to limit friends of uuid's friends, skipping the first offset. Users who
+	// are already friends with uuid, have a pending friend request to or from uuid, or have blocked or
+	// been blocked by uuid, are left out.
+	// The suggestions are ordered by number of mutual friends, most first, then by UUID.
+	Suggestions(ctx context.Context, uuid string, offset, limit int) ([]Suggestion, error)
+
+	// Follow makes follower follow followee. Following someone again does nothing, and no one can
+	// follow a user they have blocked or been blocked by.
+	Follow(ctx context.Context, follower, followee string) error
+	// Unfollow makes follower stop following followee.
+	Unfollow(ctx context.Context, follower, followee string) error
+	// Followers returns up to limit UUIDs of the users following uuid, sorted, skipping the first
+	// offset.
+	Followers(ctx context.Context, uuid string, offset, limit int) ([]string, error)
+	// Following returns up to limit UUIDs of the users uuid follows, sorted, skipping the first offset.
+	Following(ctx context.Context, uuid string, offset, limit int) ([]string, error)
+	// FollowCounts returns how many users follow uuid and how many uuid follows.
+	FollowCounts(ctx context.Context, uuid string) (FollowCounts, error)
+
+	// Block makes blocker block blocked. Any friendship between them ends, any pending friend requests
+	// between them are cancelled or declined, and neither follows the other anymore.
+	Block(ctx context.Context, blocker, blocked string) error
+	// Unblock lifts blocker's block on blocked. It doesn't bring back the friendship.
+	Unblock(ctx context.Context, blocker, blocked string) error
+	// Blockers returns the UUIDs of everyone who has blocked uuid.
+	Blockers(ctx context.Context, uuid string) ([]string, error)
+
+	// CreateList creates an empty friend list for owner. Creating a list that already exists does
+	// nothing.
+	CreateList(ctx context.Context, owner, name string) error
+	// DeleteList deletes one of owner's friend lists.
+	DeleteList(ctx context.Context, owner, name string) error
+	// Lists returns all of owner's friend lists, ordered by name, with their members sorted.
+	Lists(ctx context.Context, owner string) ([]FriendList, error)
+	// List returns one of owner's friend lists, with its members sorted.
+	List(ctx context.Context, owner, name string) (FriendList, error)
+	// AddToList puts one of owner's friends into one of their lists. Only friends can be put in a list,
+	// and adding someone who is already in it does nothing.
+	AddToList(ctx context.Context, owner, name, member string) error
+	// RemoveFromList takes member out of one of owner's friend lists.
+	RemoveFromList(ctx context.Context, owner, name, member string) error
+	// InList reports whether owner has put member in the list with the given name. It is false if
+	// there is no such list.
+	InList(ctx context.Context, owner, name, member string) (bool, error)
+
+	// Users returns the UUIDs of every user in the graph, sorted.
+	Users(ctx context.Context) ([]string, error)
+	// Friendships returns every friendship in the graph once, sorted by A and then by B.
+	Friendships(ctx context.Context) ([]FriendshipEdge, error)
+	// RestoreFriendship makes edge.A and edge.B friends as of edge.CreatedAt, so an exported friendship
+	// can be brought back as it was. If they are already friends it does nothing, and the friendship
+	// keeps the time it already had.
+	RestoreFriendship(ctx context.Context, edge FriendshipEdge) error
+}
+
+// NewFriendGraph creates the FriendGraph backend selected by the FRIENDS_GRAPH environment variable:
+//
+//   - "gremlin" (the default) talks to Neptune over the Gremlin HTTP or WebSocket endpoint at NEPTUNE_URL.
+//   - "memory" keeps the graph in memory, which is handy for local development.
+//   - "mysql" stores the graph as an adjacency table in friendsDB.
+func NewFriendGraph() (FriendGraph, error) {
+	switch backend := os.Getenv("FRIENDS_GRAPH"); backend {
+	case "", "gremlin":
+		return NewGremlinGraph(NeptuneURL()), nil
+	case "memory":
+		return NewMemoryGraph(), nil
+	case "mysql":
+		return NewMySQLGraph(InitDB("friendsDB")), nil
+	default:
+		return nil, fmt.Errorf("unknown FRIENDS_GRAPH backend %q", backend)
+	}
+}
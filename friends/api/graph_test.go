@@ -0,0 +1,502 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Runs the FriendGraph tests against the in-memory backend.
+func TestMemoryGraph(t *testing.T) {
+	testFriendGraph(t, func() FriendGraph { return NewMemoryGraph() })
+}
+
+// Runs the FriendGraph tests against the MySQL backend. This needs the MySQL Docker container to be
+// running, and is skipped otherwise.
+func TestMySQLGraph(t *testing.T) {
+	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/friendsDB?parseTime=true")
+	require.NoError(t, err)
+	defer db.Close()
+	if err = db.Ping(); err != nil {
+		t.Skipf("could not connect to database. skipping test. %s", err)
+	}
+
+	testFriendGraph(t, func() FriendGraph {
+		for _, table := range []string{"users", "friendships", "friendRequests", "blocks", "friendLists", "friendListMembers", "follows"} {
+			_, err := db.Exec("TRUNCATE TABLE " + table)
+			require.NoError(t, err)
+		}
+		return NewMySQLGraph(db)
+	})
+}
+
+// testFriendGraph checks the behavior every FriendGraph backend must share. newGraph must return an
+// empty graph each time it is called.
+func testFriendGraph(t *testing.T, newGraph func() FriendGraph) {
+	ctx := context.Background()
+
+	// Builds a graph with oski, stanfurd and bruin, where oski is friends with the other two.
+	setup := func(t *testing.T) FriendGraph {
+		g := newGraph()
+		for _, user := range []string{"oski", "stanfurd", "bruin"} {
+			require.NoError(t, g.AddUser(ctx, user))
+		}
+		require.NoError(t, g.AddFriendship(ctx, "oski", "stanfurd"))
+		require.NoError(t, g.AddFriendship(ctx, "bruin", "oski"))
+		return g
+	}
+
+	t.Run("Friendships Are Symmetric", func(t *testing.T) {
+		g := setup(t)
+		for _, pair := range [][2]string{{"oski", "stanfurd"}, {"stanfurd", "oski"}, {"oski", "bruin"}, {"bruin", "oski"}} {
+			friends, err := g.AreFriends(ctx, pair[0], pair[1])
+			require.NoError(t, err)
+			assert.True(t, friends, "%s and %s should be friends", pair[0], pair[1])
+		}
+		friends, err := g.AreFriends(ctx, "stanfurd", "bruin")
+		require.NoError(t, err)
+		assert.False(t, friends)
+	})
+
+	t.Run("Friends", func(t *testing.T) {
+		g := setup(t)
+		friends, err := g.Friends(ctx, "oski")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"stanfurd", "bruin"}, friends)
+
+		friends, err = g.Friends(ctx, "nobody")
+		require.NoError(t, err)
+		assert.Empty(t, friends)
+	})
+
+	t.Run("Mutuals", func(t *testing.T) {
+		g := setup(t)
+		mutuals, err := g.Mutuals(ctx, "stanfurd", "bruin")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"oski"}, mutuals)
+
+		mutuals, err = g.Mutuals(ctx, "oski", "stanfurd")
+		require.NoError(t, err)
+		assert.Empty(t, mutuals)
+	})
+
+	t.Run("Remove Friendship", func(t *testing.T) {
+		g := setup(t)
+		require.NoError(t, g.RemoveFriendship(ctx, "stanfurd", "oski"))
+		for _, pair := range [][2]string{{"oski", "stanfurd"}, {"stanfurd", "oski"}} {
+			friends, err := g.AreFriends(ctx, pair[0], pair[1])
+			require.NoError(t, err)
+			assert.False(t, friends, "%s and %s are still friends", pair[0], pair[1])
+		}
+		friends, err := g.Friends(ctx, "oski")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bruin"}, friends)
+	})
+
+	t.Run("Unknown User", func(t *testing.T) {
+		g := setup(t)
+		err := g.AddFriendship(ctx, "oski", "nobody")
+		assert.Equal(t, ErrUserNotFound, err)
+		err = g.SendRequest(ctx, "oski", "nobody")
+		assert.Equal(t, ErrUserNotFound, err)
+	})
+
+	t.Run("Accept Request", func(t *testing.T) {
+		g := setup(t)
+		require.NoError(t, g.SendRequest(ctx, "stanfurd", "bruin"))
+
+		incoming, err := g.IncomingRequests(ctx, "bruin")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"stanfurd"}, incoming)
+		outgoing, err := g.OutgoingRequests(ctx, "stanfurd")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bruin"}, outgoing)
+
+		friends, err := g.AreFriends(ctx, "stanfurd", "bruin")
+		require.NoError(t, err)
+		assert.False(t, friends, "a pending request should not make them friends")
+
+		require.NoError(t, g.UpdateRequest(ctx, "stanfurd", "bruin", RequestAccepted))
+		for _, pair := range [][2]string{{"stanfurd", "bruin"}, {"bruin", "stanfurd"}} {
+			friends, err := g.AreFriends(ctx, pair[0], pair[1])
+			require.NoError(t, err)
+			assert.True(t, friends, "%s and %s should be friends", pair[0], pair[1])
+		}
+
+		incoming, err = g.IncomingRequests(ctx, "bruin")
+		require.NoError(t, err)
+		assert.Empty(t, incoming)
+		outgoing, err = g.OutgoingRequests(ctx, "stanfurd")
+		require.NoError(t, err)
+		assert.Empty(t, outgoing)
+	})
+
+	t.Run("Decline And Cancel Request", func(t *testing.T) {
+		for _, state := range []RequestState{RequestDeclined, RequestCancelled} {
+			g := setup(t)
+			require.NoError(t, g.SendRequest(ctx, "stanfurd", "bruin"))
+			require.NoError(t, g.UpdateRequest(ctx, "stanfurd", "bruin", state))
+
+			friends, err := g.AreFriends(ctx, "stanfurd", "bruin")
+			require.NoError(t, err)
+			assert.False(t, friends, "a %s request should not make them friends", state)
+			incoming, err := g.IncomingRequests(ctx, "bruin")
+			require.NoError(t, err)
+			assert.Empty(t, incoming)
+
+			// The request is no longer pending, so it can't change state again, but it can be resent.
+			err = g.UpdateRequest(ctx, "stanfurd", "bruin", RequestAccepted)
+			assert.Equal(t, ErrRequestNotFound, err)
+			require.NoError(t, g.SendRequest(ctx, "stanfurd", "bruin"))
+		}
+	})
+
+	t.Run("Duplicate Requests", func(t *testing.T) {
+		g := setup(t)
+		require.NoError(t, g.SendRequest(ctx, "stanfurd", "bruin"))
+		require.NoError(t, g.SendRequest(ctx, "stanfurd", "bruin"))
+		outgoing, err := g.OutgoingRequests(ctx, "stanfurd")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bruin"}, outgoing)
+
+		assert.Equal(t, ErrRequestPending, g.SendRequest(ctx, "bruin", "stanfurd"))
+		assert.Equal(t, ErrAlreadyFriends, g.SendRequest(ctx, "oski", "stanfurd"))
+		assert.Equal(t, ErrSelfFriend, g.SendRequest(ctx, "oski", "oski"))
+	})
+
+	t.Run("Crossed Requests", func(t *testing.T) {
+		g := setup(t)
+		// stanfurd and bruin send each other requests at the same time, and only one should be sent
+		var wg sync.WaitGroup
+		for _, pair := range [][2]string{{"stanfurd", "bruin"}, {"bruin", "stanfurd"}} {
+			wg.Add(1)
+			go func(from, to string) {
+				defer wg.Done()
+				err := g.SendRequest(ctx, from, to)
+				if err != ErrRequestPending {
+					assert.NoError(t, err)
+				}
+			}(pair[0], pair[1])
+		}
+		wg.Wait()
+
+		fromStanfurd, err := g.OutgoingRequests(ctx, "stanfurd")
+		require.NoError(t, err)
+		fromBruin, err := g.OutgoingRequests(ctx, "bruin")
+		require.NoError(t, err)
+		assert.Len(t, append(fromStanfurd, fromBruin...), 1, "both requests were sent")
+	})
+
+	t.Run("Idempotent Adds", func(t *testing.T) {
+		g := setup(t)
+		require.NoError(t, g.AddUser(ctx, "oski"))
+		require.NoError(t, g.AddFriendship(ctx, "oski", "stanfurd"))
+		friends, err := g.Friends(ctx, "oski")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"stanfurd", "bruin"}, friends, "friendships were duplicated")
+
+		assert.Equal(t, ErrSelfFriend, g.AddFriendship(ctx, "oski", "oski"))
+	})
+
+	t.Run("Suggestions", func(t *testing.T) {
+		g := newSuggestionsFixture(t, newGraph())
+		suggestions, err := g.Suggestions(ctx, "oski", 0, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []Suggestion{{"duck", 3}, {"husky", 2}, {"trojan", 1}}, suggestions)
+
+		suggestions, err = g.Suggestions(ctx, "oski", 1, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []Suggestion{{"husky", 2}}, suggestions)
+
+		suggestions, err = g.Suggestions(ctx, "oski", 5, 10)
+		require.NoError(t, err)
+		assert.Empty(t, suggestions)
+	})
+
+	t.Run("Blocking", func(t *testing.T) {
+		g := setup(t)
+		require.NoError(t, g.SendRequest(ctx, "stanfurd", "bruin"))
+		require.NoError(t, g.Block(ctx, "oski", "stanfurd"))
+		require.NoError(t, g.Block(ctx, "bruin", "stanfurd"))
+
+		// Blocking ends the friendship and the pending request, and no new request can be sent.
+		friends, err := g.AreFriends(ctx, "oski", "stanfurd")
+		require.NoError(t, err)
+		assert.False(t, friends)
+		outgoing, err := g.OutgoingRequests(ctx, "stanfurd")
+		require.NoError(t, err)
+		assert.Empty(t, outgoing)
+		assert.Equal(t, ErrBlocked, g.SendRequest(ctx, "stanfurd", "oski"))
+		assert.Equal(t, ErrBlocked, g.SendRequest(ctx, "oski", "stanfurd"))
+
+		blockers, err := g.Blockers(ctx, "stanfurd")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bruin", "oski"}, blockers)
+		blockers, err = g.Blockers(ctx, "oski")
+		require.NoError(t, err)
+		assert.Empty(t, blockers)
+
+		// Unblocking allows requests again, but doesn't bring the friendship back.
+		require.NoError(t, g.Unblock(ctx, "oski", "stanfurd"))
+		friends, err = g.AreFriends(ctx, "oski", "stanfurd")
+		require.NoError(t, err)
+		assert.False(t, friends)
+		require.NoError(t, g.SendRequest(ctx, "oski", "stanfurd"))
+		blockers, err = g.Blockers(ctx, "stanfurd")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bruin"}, blockers)
+
+		assert.Equal(t, ErrUserNotFound, g.Block(ctx, "oski", "nobody"))
+	})
+
+	t.Run("Suggestions Leave Out Blocks", func(t *testing.T) {
+		g := newSuggestionsFixture(t, newGraph())
+		require.NoError(t, g.Block(ctx, "oski", "husky"))
+		require.NoError(t, g.Block(ctx, "trojan", "oski"))
+		suggestions, err := g.Suggestions(ctx, "oski", 0, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []Suggestion{{"duck", 3}}, suggestions)
+	})
+
+	t.Run("List Friends", func(t *testing.T) {
+		g := newGraph()
+		for _, user := range []string{"oski", "stanfurd", "bruin", "tree", "duck"} {
+			require.NoError(t, g.AddUser(ctx, user))
+		}
+		// bruin and tree became friends with oski at the same time, so they are ordered by UUID
+		start := time.Date(2020, time.August, 26, 9, 0, 0, 0, time.UTC)
+		times := []time.Time{start, start.Add(time.Hour), start.Add(time.Hour), start.Add(2 * time.Hour)}
+		useClock(t, times...)
+		for _, friend := range []string{"duck", "bruin", "tree", "stanfurd"} {
+			require.NoError(t, g.AddFriendship(ctx, "oski", friend))
+		}
+		created := map[string]time.Time{"duck": times[0], "bruin": times[1], "tree": times[2], "stanfurd": times[3]}
+
+		for order, want := range map[FriendOrder][]string{
+			OrderByUUID: {"bruin", "duck", "stanfurd", "tree"},
+			OrderNewest: {"stanfurd", "bruin", "tree", "duck"},
+			OrderOldest: {"duck", "bruin", "tree", "stanfurd"},
+		} {
+			// Page through the friends two at a time
+			var pages [][]string
+			query := FriendQuery{Order: order, Limit: 2}
+			for {
+				friends, err := g.ListFriends(ctx, "oski", query)
+				require.NoError(t, err)
+				if len(friends) == 0 {
+					break
+				}
+				page := []string{}
+				for _, friend := range friends {
+					assert.True(t, created[friend.UUID].Equal(friend.CreatedAt), "%s has the wrong creation time", friend.UUID)
+					page = append(page, friend.UUID)
+				}
+				pages = append(pages, page)
+				query.After = &friends[len(friends)-1]
+			}
+			assert.Equal(t, [][]string{want[:2], want[2:]}, pages, "wrong pages in %s order", order)
+		}
+
+		// The friendship is timed once, so it looks the same from both sides
+		friends, err := g.ListFriends(ctx, "tree", FriendQuery{Order: OrderNewest, Limit: 10})
+		require.NoError(t, err)
+		require.Len(t, friends, 1)
+		assert.True(t, times[2].Equal(friends[0].CreatedAt))
+	})
+
+	t.Run("Friendship", func(t *testing.T) {
+		start := time.Date(2020, time.August, 26, 9, 0, 0, 0, time.UTC)
+		useClock(t, start, start.Add(time.Hour))
+		g := setup(t)
+
+		friend, err := g.Friendship(ctx, "bruin", "oski")
+		require.NoError(t, err)
+		assert.Equal(t, "oski", friend.UUID)
+		assert.True(t, start.Add(time.Hour).Equal(friend.CreatedAt), "bruin and oski became friends at %v, not %v", start.Add(time.Hour), friend.CreatedAt)
+
+		_, err = g.Friendship(ctx, "stanfurd", "bruin")
+		assert.Equal(t, ErrNotFriends, err)
+	})
+
+	t.Run("Friend Lists", func(t *testing.T) {
+		g := setup(t)
+		require.NoError(t, g.CreateList(ctx, "oski", "close friends"))
+		require.NoError(t, g.CreateList(ctx, "oski", "close friends"), "creating a list twice should do nothing")
+		require.NoError(t, g.CreateList(ctx, "oski", "bears"))
+		assert.Equal(t, ErrUserNotFound, g.CreateList(ctx, "nobody", "close friends"))
+
+		require.NoError(t, g.AddToList(ctx, "oski", "close friends", "stanfurd"))
+		require.NoError(t, g.AddToList(ctx, "oski", "close friends", "stanfurd"), "adding someone twice should do nothing")
+		require.NoError(t, g.AddToList(ctx, "oski", "close friends", "bruin"))
+		assert.Equal(t, ErrListNotFound, g.AddToList(ctx, "stanfurd", "close friends", "bruin"), "stanfurd has no lists yet")
+		require.NoError(t, g.CreateList(ctx, "stanfurd", "close friends"))
+		assert.Equal(t, ErrNotFriends, g.AddToList(ctx, "stanfurd", "close friends", "bruin"))
+		assert.Equal(t, ErrListNotFound, g.AddToList(ctx, "oski", "enemies", "bruin"))
+
+		lists, err := g.Lists(ctx, "oski")
+		require.NoError(t, err)
+		assert.Equal(t, []FriendList{{"bears", []string{}}, {"close friends", []string{"bruin", "stanfurd"}}}, lists)
+		list, err := g.List(ctx, "oski", "close friends")
+		require.NoError(t, err)
+		assert.Equal(t, FriendList{"close friends", []string{"bruin", "stanfurd"}}, list)
+		_, err = g.List(ctx, "oski", "enemies")
+		assert.Equal(t, ErrListNotFound, err)
+
+		// Lists belong to their owner, so stanfurd's list of the same name is separate
+		found, err := g.InList(ctx, "oski", "close friends", "bruin")
+		require.NoError(t, err)
+		assert.True(t, found)
+		found, err = g.InList(ctx, "stanfurd", "close friends", "oski")
+		require.NoError(t, err)
+		assert.False(t, found)
+		found, err = g.InList(ctx, "oski", "enemies", "bruin")
+		require.NoError(t, err)
+		assert.False(t, found)
+
+		require.NoError(t, g.RemoveFromList(ctx, "oski", "close friends", "bruin"))
+		assert.Equal(t, ErrListNotFound, g.RemoveFromList(ctx, "oski", "enemies", "bruin"))
+		require.NoError(t, g.DeleteList(ctx, "oski", "bears"))
+		assert.Equal(t, ErrListNotFound, g.DeleteList(ctx, "oski", "bears"))
+		lists, err = g.Lists(ctx, "oski")
+		require.NoError(t, err)
+		assert.Equal(t, []FriendList{{"close friends", []string{"stanfurd"}}}, lists)
+	})
+
+	t.Run("Unfriending Leaves Lists", func(t *testing.T) {
+		g := setup(t)
+		require.NoError(t, g.AddFriendship(ctx, "stanfurd", "bruin"))
+		for _, owner := range []string{"oski", "stanfurd", "bruin"} {
+			require.NoError(t, g.CreateList(ctx, owner, "close friends"))
+		}
+		for _, pair := range [][2]string{{"oski", "stanfurd"}, {"oski", "bruin"}, {"stanfurd", "oski"}, {"bruin", "oski"}, {"bruin", "stanfurd"}} {
+			require.NoError(t, g.AddToList(ctx, pair[0], "close friends", pair[1]))
+		}
+
+		// Ending a friendship takes both users out of each other's lists, and blocking ends it too
+		require.NoError(t, g.RemoveFriendship(ctx, "oski", "stanfurd"))
+		require.NoError(t, g.Block(ctx, "bruin", "oski"))
+		for owner, want := range map[string][]string{"oski": {}, "stanfurd": {}, "bruin": {"stanfurd"}} {
+			list, err := g.List(ctx, owner, "close friends")
+			require.NoError(t, err)
+			assert.Equal(t, want, list.Members, "wrong members left in %s's list", owner)
+		}
+	})
+
+	t.Run("Follows", func(t *testing.T) {
+		g := setup(t)
+		require.NoError(t, g.AddUser(ctx, "tree"))
+		for _, follower := range []string{"stanfurd", "bruin", "tree"} {
+			require.NoError(t, g.Follow(ctx, follower, "oski"))
+		}
+		require.NoError(t, g.Follow(ctx, "tree", "oski"), "following someone twice should do nothing")
+		require.NoError(t, g.Follow(ctx, "oski", "tree"))
+		assert.Equal(t, ErrSelfFollow, g.Follow(ctx, "oski", "oski"))
+		assert.Equal(t, ErrUserNotFound, g.Follow(ctx, "oski", "nobody"))
+
+		followers, err := g.Followers(ctx, "oski", 0, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bruin", "stanfurd", "tree"}, followers)
+		followers, err = g.Followers(ctx, "oski", 1, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"stanfurd"}, followers)
+		following, err := g.Following(ctx, "tree", 0, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"oski"}, following)
+		counts, err := g.FollowCounts(ctx, "oski")
+		require.NoError(t, err)
+		assert.Equal(t, FollowCounts{Followers: 3, Following: 1}, counts)
+
+		// Following each other doesn't make tree and oski friends
+		friends, err := g.AreFriends(ctx, "tree", "oski")
+		require.NoError(t, err)
+		assert.False(t, friends)
+
+		require.NoError(t, g.Unfollow(ctx, "stanfurd", "oski"))
+		require.NoError(t, g.Block(ctx, "oski", "tree"))
+		assert.Equal(t, ErrBlocked, g.Follow(ctx, "tree", "oski"))
+		followers, err = g.Followers(ctx, "oski", 0, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bruin"}, followers, "unfollowing and blocking should end follows")
+		counts, err = g.FollowCounts(ctx, "tree")
+		require.NoError(t, err)
+		assert.Equal(t, FollowCounts{}, counts)
+	})
+
+	t.Run("Export And Import", func(t *testing.T) {
+		start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+		useClock(t, start, start.Add(time.Millisecond))
+		g := setup(t)
+		require.NoError(t, g.AddUser(ctx, "tree"))
+
+		users, err := g.Users(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"bruin", "oski", "stanfurd", "tree"}, users)
+		want := []FriendshipEdge{{"bruin", "oski", start.Add(time.Millisecond)}, {"oski", "stanfurd", start}}
+		friendships, err := g.Friendships(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, friendships)
+
+		// Restoring a friendship keeps its time, and restoring one that exists leaves it alone
+		restored := newGraph()
+		for _, user := range users {
+			require.NoError(t, restored.AddUser(ctx, user))
+		}
+		for _, edge := range append(friendships, friendships...) {
+			require.NoError(t, restored.RestoreFriendship(ctx, edge))
+		}
+		require.NoError(t, restored.RestoreFriendship(ctx, FriendshipEdge{"oski", "stanfurd", start.Add(time.Hour)}))
+		friendships, err = restored.Friendships(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, friendships)
+		friend, err := restored.Friendship(ctx, "stanfurd", "oski")
+		require.NoError(t, err)
+		assert.Equal(t, start, friend.CreatedAt)
+		assert.Equal(t, ErrUserNotFound, restored.RestoreFriendship(ctx, FriendshipEdge{"oski", "nobody", start}))
+	})
+
+	t.Run("Missing Request", func(t *testing.T) {
+		g := setup(t)
+		require.NoError(t, g.SendRequest(ctx, "stanfurd", "bruin"))
+		// Only the request from stanfurd to bruin exists, not the other way around.
+		err := g.UpdateRequest(ctx, "bruin", "stanfurd", RequestAccepted)
+		assert.Equal(t, ErrRequestNotFound, err)
+	})
+}
+
+// useClock makes friendships be timed with each of times in turn, until the test ends.
+func useClock(t *testing.T, times ...time.Time) {
+	next := 0
+	now = func() time.Time {
+		next++
+		return times[next-1]
+	}
+	t.Cleanup(func() { now = time.Now })
+}
+
+// newSuggestionsFixture fills the empty graph g with a fixed set of users for testing suggestions.
+// oski's friends are stanfurd, bruin and tree. Among their friends, duck is friends with all three,
+// husky with two and trojan with one. oski has sent beaver a friend request and has one from cougar,
+// so neither of them should be suggested.
+func newSuggestionsFixture(t *testing.T, g FriendGraph) FriendGraph {
+	ctx := context.Background()
+	for _, user := range []string{"oski", "stanfurd", "bruin", "tree", "duck", "husky", "trojan", "beaver", "cougar"} {
+		require.NoError(t, g.AddUser(ctx, user))
+	}
+	friendships := [][2]string{
+		{"oski", "stanfurd"}, {"oski", "bruin"}, {"oski", "tree"},
+		{"stanfurd", "bruin"}, {"stanfurd", "duck"}, {"stanfurd", "husky"},
+		{"bruin", "duck"}, {"bruin", "husky"}, {"bruin", "trojan"},
+		{"tree", "duck"}, {"tree", "beaver"}, {"tree", "cougar"},
+	}
+	for _, pair := range friendships {
+		require.NoError(t, g.AddFriendship(ctx, pair[0], pair[1]))
+	}
+	require.NoError(t, g.SendRequest(ctx, "oski", "beaver"))
+	require.NoError(t, g.SendRequest(ctx, "cougar", "oski"))
+	return g
+}
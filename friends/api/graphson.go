@@ -0,0 +1,277 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// Gremlin status codes, from the Gremlin Server protocol.
+const (
+	gremlinSuccess        = 200
+	gremlinNoContent      = 204
+	gremlinPartialContent = 206
+	gremlinTimeout        = 598
+)
+
+// A GremlinError is an error reported by the Gremlin server, either in the status of a response or,
+// for errors Neptune reports before running the query, as the HTTP status of the response.
+type GremlinError struct {
+	// Code is the Gremlin status code, or the HTTP status code if that is all the server gave.
+	Code int
+	// Exception names the exception the server threw, such as ConstraintViolationException, if it
+	// said which.
+	Exception string
+	Message   string
+}
+
+func (e *GremlinError) Error() string {
+	if e.Exception != "" {
+		return fmt.Sprintf("gremlin error %d (%s): %s", e.Code, e.Exception, e.Message)
+	}
+	return fmt.Sprintf("gremlin error %d: %s", e.Code, e.Message)
+}
+
+// HTTPStatus returns the status the friends service should respond with when a query fails with e.
+// Timeouts and throttling are passed on as such, so clients know to retry, and anything else means the
+// graph database misbehaved.
+func (e *GremlinError) HTTPStatus() int {
+	switch {
+	case e.Code == gremlinTimeout || e.Exception == "TimeLimitExceededException":
+		return http.StatusGatewayTimeout
+	case e.Code == http.StatusTooManyRequests || e.Code == http.StatusServiceUnavailable ||
+		e.Exception == "ThrottlingException" || e.Exception == "ConcurrentModificationException":
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// gremlinResponse is the body of a successful response from the Gremlin HTTP endpoint, or a successful
+// response message on the WebSocket endpoint.
+type gremlinResponse struct {
+	RequestID string `json:"requestId"`
+	Status    struct {
+		Message string `json:"message"`
+		Code    int    `json:"code"`
+	} `json:"status"`
+	Result struct {
+		Data json.RawMessage `json:"data"`
+	} `json:"result"`
+}
+
+// gremlinErrorBody is the body of an error response from the Gremlin HTTP endpoint. Neptune sets Code
+// and DetailedMessage, while Gremlin Server sets Message and ExceptionClass.
+type gremlinErrorBody struct {
+	Code            string `json:"code"`
+	DetailedMessage string `json:"detailedMessage"`
+	Message         string `json:"message"`
+	ExceptionClass  string `json:"Exception-Class"`
+}
+
+// decodeGremlinResponse decodes the body of a response from the Gremlin HTTP endpoint, turning any
+// error it reports into a *GremlinError.
+func decodeGremlinResponse(httpStatus int, body []byte) (*gremlinResponse, error) {
+	if httpStatus != http.StatusOK {
+		errBody := gremlinErrorBody{}
+		if json.Unmarshal(body, &errBody) != nil {
+			return nil, &GremlinError{Code: httpStatus, Message: strings.TrimSpace(string(body))}
+		}
+		gremlinErr := &GremlinError{Code: httpStatus, Exception: errBody.Code, Message: errBody.DetailedMessage}
+		if gremlinErr.Exception == "" {
+			gremlinErr.Exception = errBody.ExceptionClass
+		}
+		if gremlinErr.Message == "" {
+			gremlinErr.Message = errBody.Message
+		}
+		return nil, gremlinErr
+	}
+
+	response := &gremlinResponse{}
+	err := json.Unmarshal(body, response)
+	if err != nil {
+		return nil, fmt.Errorf("decoding gremlin response: %w", err)
+	}
+	switch response.Status.Code {
+	case gremlinSuccess, gremlinNoContent, gremlinPartialContent:
+		return response, nil
+	default:
+		return nil, &GremlinError{Code: response.Status.Code, Message: response.Status.Message}
+	}
+}
+
+// decode decodes the results of the query into v, which must point to a slice: a []string for
+// values(), an []int64 for count(), or a slice of structs for project(), whose JSON tags name the keys.
+// The results are decoded like plain JSON once their GraphSON types are taken off, so a result of the
+// wrong type is an error, as is a map with keys missing or left over.
+func (r *gremlinResponse) decode(v interface{}) error {
+	data := json.RawMessage("[]")
+	if len(r.Result.Data) != 0 && string(r.Result.Data) != "null" {
+		var err error
+		data, err = plainGraphSON(r.Result.Data)
+		if err != nil {
+			return fmt.Errorf("decoding graphson: %w", err)
+		}
+	}
+
+	err := checkKeys(data, reflect.TypeOf(v))
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(v)
+	if err != nil {
+		return fmt.Errorf("decoding gremlin results: %w", err)
+	}
+	return nil
+}
+
+// strings returns the results of a query that only returns strings, such as values('uuid').
+func (r *gremlinResponse) strings() ([]string, error) {
+	strings := []string{}
+	err := r.decode(&strings)
+	if err != nil {
+		return nil, err
+	}
+	return strings, nil
+}
+
+// count returns the result of a query ending in count().
+func (r *gremlinResponse) count() (int64, error) {
+	counts := []int64{}
+	err := r.decode(&counts)
+	if err != nil {
+		return 0, err
+	}
+	if len(counts) != 1 {
+		return 0, fmt.Errorf("count returned %d results", len(counts))
+	}
+	return counts[0], nil
+}
+
+// checkKeys returns an error if results, a JSON array, is to be decoded into a pointer to a slice of
+// structs and one of its maps lacks a key the struct has a field for. encoding/json would leave the
+// field as its zero value.
+func checkKeys(results json.RawMessage, typ reflect.Type) error {
+	if typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Slice || typ.Elem().Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	elem := typ.Elem().Elem()
+	maps := []map[string]json.RawMessage{}
+	err := json.Unmarshal(results, &maps)
+	if err != nil {
+		return fmt.Errorf("decoding gremlin results: %w", err)
+	}
+	for _, m := range maps {
+		for i := 0; i < elem.NumField(); i++ {
+			key := strings.Split(elem.Field(i).Tag.Get("json"), ",")[0]
+			if _, ok := m[key]; key != "" && key != "-" && !ok {
+				return fmt.Errorf("gremlin result has no %q", key)
+			}
+		}
+	}
+	return nil
+}
+
+// plainGraphSON turns GraphSON v2 or v3 into the plain JSON it stands for, so it can be decoded into
+// Go types: lists and sets become arrays, maps become objects with their keys as strings, numbers
+// become JSON numbers and dates become milliseconds since the epoch.
+func plainGraphSON(data json.RawMessage) (json.RawMessage, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return nil, errors.New("empty graphson value")
+	}
+	switch trimmed[0] {
+	case '{':
+		object := map[string]json.RawMessage{}
+		err := json.Unmarshal(trimmed, &object)
+		if err != nil {
+			return nil, err
+		}
+		typ, typed := object["@type"]
+		raw, hasValue := object["@value"]
+		if typed && hasValue && len(object) == 2 {
+			var name string
+			err = json.Unmarshal(typ, &name)
+			if err != nil {
+				return nil, fmt.Errorf("graphson @type: %w", err)
+			}
+			return plainTypedGraphSON(name, raw)
+		}
+		// GraphSON v2 writes maps as plain objects
+		for key, value := range object {
+			object[key], err = plainGraphSON(value)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return json.Marshal(object)
+	case '[':
+		return plainGraphSONList(trimmed)
+	default:
+		return trimmed, nil
+	}
+}
+
+// plainTypedGraphSON turns the value of a GraphSON object with the given @type into plain JSON.
+func plainTypedGraphSON(typ string, raw json.RawMessage) (json.RawMessage, error) {
+	switch typ {
+	case "g:List", "g:Set":
+		return plainGraphSONList(raw)
+	case "g:Map":
+		entries := []json.RawMessage{}
+		err := json.Unmarshal(raw, &entries)
+		if err != nil || len(entries)%2 != 0 {
+			return nil, errors.New("graphson g:Map is not an array of keys and values")
+		}
+		object := make(map[string]json.RawMessage, len(entries)/2)
+		for i := 0; i < len(entries); i += 2 {
+			key, err := plainGraphSON(entries[i])
+			if err != nil {
+				return nil, err
+			}
+			value, err := plainGraphSON(entries[i+1])
+			if err != nil {
+				return nil, err
+			}
+			// Keys that aren't strings, such as numbers, are keyed by their JSON
+			var name string
+			if json.Unmarshal(key, &name) != nil {
+				name = string(key)
+			}
+			object[name] = value
+		}
+		return json.Marshal(object)
+	case "g:Int32", "g:Int64", "g:Float", "g:Double", "g:Date", "g:Timestamp":
+		var n json.Number
+		err := json.Unmarshal(raw, &n)
+		if err != nil {
+			return nil, fmt.Errorf("graphson %s is not a number", typ)
+		}
+		return json.RawMessage(n), nil
+	default:
+		// Anything else, such as a g:UUID or a g:Vertex, is just its value
+		return plainGraphSON(raw)
+	}
+}
+
+// plainGraphSONList turns every element of a GraphSON list into plain JSON.
+func plainGraphSONList(raw json.RawMessage) (json.RawMessage, error) {
+	list := []json.RawMessage{}
+	err := json.Unmarshal(raw, &list)
+	if err != nil {
+		return nil, errors.New("graphson list is not an array")
+	}
+	for i, value := range list {
+		list[i], err = plainGraphSON(value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(list)
+}
@@ -0,0 +1,206 @@
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Reads a recorded Gremlin response from testdata/graphson.
+func readFixture(t *testing.T, name string) []byte {
+	body, err := ioutil.ReadFile(filepath.Join("testdata", "graphson", name))
+	require.NoError(t, err)
+	return body
+}
+
+// suggestionResult is what the friend suggestions query projects each suggestion into.
+type suggestionResult struct {
+	UUID          string `json:"uuid"`
+	MutualFriends int64  `json:"mutualFriends"`
+}
+
+func decodeSuggestions(r *gremlinResponse) (interface{}, error) {
+	results := []suggestionResult{}
+	err := r.decode(&results)
+	return results, err
+}
+
+func TestDecodeGremlinResponse(t *testing.T) {
+	oski := "6e9fc8a4-6a0d-4bda-a6b3-2f6e1c0f8a11"
+	stanfurd := "0b2b4c3d-9e8f-4a7b-8c6d-5e4f3a2b1c0d"
+	suggestions := []suggestionResult{{UUID: "duck", MutualFriends: 3}, {UUID: "husky", MutualFriends: 2}}
+
+	tests := []struct {
+		name       string
+		fixture    string
+		httpStatus int
+		// decode pulls the results out of the response, as the matching GremlinGraph method would.
+		decode func(r *gremlinResponse) (interface{}, error)
+		want   interface{}
+		// If wantErr is set, decoding the response should fail with a GremlinError like it, which the
+		// friends service should report with wantStatus.
+		wantErr    *GremlinError
+		wantStatus int
+	}{
+		{
+			name: "v3 strings", fixture: "v3_strings.json", httpStatus: http.StatusOK,
+			decode: func(r *gremlinResponse) (interface{}, error) { return r.strings() },
+			want:   []string{oski, stanfurd},
+		},
+		{
+			name: "v2 strings", fixture: "v2_strings.json", httpStatus: http.StatusOK,
+			decode: func(r *gremlinResponse) (interface{}, error) { return r.strings() },
+			want:   []string{oski, stanfurd},
+		},
+		{
+			name: "v3 count", fixture: "v3_count.json", httpStatus: http.StatusOK,
+			decode: func(r *gremlinResponse) (interface{}, error) { return r.count() },
+			want:   int64(2),
+		},
+		{
+			name: "v2 count", fixture: "v2_count.json", httpStatus: http.StatusOK,
+			decode: func(r *gremlinResponse) (interface{}, error) { return r.count() },
+			want:   int64(2),
+		},
+		{
+			name: "v3 no content", fixture: "v3_empty.json", httpStatus: http.StatusOK,
+			decode: func(r *gremlinResponse) (interface{}, error) { return r.strings() },
+			want:   []string{},
+		},
+		{
+			name: "v3 maps", fixture: "v3_suggestions.json", httpStatus: http.StatusOK,
+			decode: decodeSuggestions,
+			want:   suggestions,
+		},
+		{
+			name: "v2 maps", fixture: "v2_suggestions.json", httpStatus: http.StatusOK,
+			decode: decodeSuggestions,
+			want:   suggestions,
+		},
+		{
+			name: "script error", fixture: "script_error.json", httpStatus: http.StatusOK,
+			wantErr:    &GremlinError{Code: 597, Message: "No such property: uuidd for class: Script1"},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "neptune malformed query", fixture: "neptune_malformed.json", httpStatus: http.StatusBadRequest,
+			wantErr:    &GremlinError{Code: http.StatusBadRequest, Exception: "MalformedQueryException"},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "neptune concurrent modification", fixture: "neptune_concurrent.json", httpStatus: http.StatusInternalServerError,
+			wantErr:    &GremlinError{Code: http.StatusInternalServerError, Exception: "ConcurrentModificationException"},
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "neptune timeout", fixture: "neptune_timeout.json", httpStatus: http.StatusInternalServerError,
+			wantErr:    &GremlinError{Code: http.StatusInternalServerError, Exception: "TimeLimitExceededException"},
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name: "gremlin server error", fixture: "gremlin_server_error.json", httpStatus: http.StatusInternalServerError,
+			wantErr:    &GremlinError{Code: http.StatusInternalServerError, Exception: "groovy.lang.MissingMethodException"},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name: "proxy error", fixture: "proxy_error.html", httpStatus: http.StatusBadGateway,
+			wantErr:    &GremlinError{Code: http.StatusBadGateway, Message: "<html><body><h1>502 Bad Gateway</h1></body></html>"},
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, err := decodeGremlinResponse(test.httpStatus, readFixture(t, test.fixture))
+			if test.wantErr != nil {
+				var gremlinErr *GremlinError
+				require.True(t, errors.As(err, &gremlinErr), "expected a GremlinError, got %v", err)
+				assert.Equal(t, test.wantErr.Code, gremlinErr.Code)
+				assert.Equal(t, test.wantErr.Exception, gremlinErr.Exception)
+				if test.wantErr.Message != "" {
+					assert.Equal(t, test.wantErr.Message, gremlinErr.Message)
+				}
+				assert.NotEmpty(t, gremlinErr.Message)
+				assert.Equal(t, test.wantStatus, gremlinErr.HTTPStatus())
+				return
+			}
+
+			require.NoError(t, err)
+			got, err := test.decode(response)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+// Checks that results of the wrong shape are reported as errors instead of panicking.
+func TestDecodeUnexpectedResults(t *testing.T) {
+	response, err := decodeGremlinResponse(http.StatusOK, readFixture(t, "v3_count.json"))
+	require.NoError(t, err)
+	_, err = response.strings()
+	assert.Error(t, err, "a count isn't a list of strings")
+	_, err = decodeSuggestions(response)
+	assert.Error(t, err, "a count isn't a list of maps")
+
+	// Maps that don't match what was asked for aren't decoded into zero values
+	response = &gremlinResponse{}
+	response.Result.Data = []byte(`{"@type":"g:List","@value":[{"@type":"g:Map","@value":["uuid","duck"]}]}`)
+	_, err = decodeSuggestions(response)
+	assert.Error(t, err, "a suggestion without mutualFriends")
+	response.Result.Data = []byte(`[{"uuid":"duck","mutualFriends":"3"}]`)
+	_, err = decodeSuggestions(response)
+	assert.Error(t, err, "a suggestion with a string count")
+	response.Result.Data = []byte(`[{"uuid":"duck","mutualFriends":3,"score":1}]`)
+	_, err = decodeSuggestions(response)
+	assert.Error(t, err, "a suggestion with a key left over")
+	response.Result.Data = []byte(`{"@type":"g:List","@value":[{"@type":"g:Double","@value":1.5}]}`)
+	_, err = response.count()
+	assert.Error(t, err, "a count that isn't a whole number")
+
+	response, err = decodeGremlinResponse(http.StatusOK, readFixture(t, "v3_empty.json"))
+	require.NoError(t, err)
+	_, err = response.count()
+	assert.Error(t, err, "an empty result has no count")
+
+	_, err = decodeGremlinResponse(http.StatusOK, readFixture(t, "truncated.json"))
+	assert.Error(t, err)
+}
+
+// Checks that Gremlin errors reach clients as HTTP errors rather than crashing the handler.
+func TestGremlinErrorResponses(t *testing.T) {
+	tests := []struct {
+		fixture    string
+		httpStatus int
+		wantStatus int
+	}{
+		{"script_error.json", http.StatusOK, http.StatusBadGateway},
+		{"neptune_timeout.json", http.StatusInternalServerError, http.StatusGatewayTimeout},
+		{"neptune_concurrent.json", http.StatusInternalServerError, http.StatusServiceUnavailable},
+		{"proxy_error.html", http.StatusBadGateway, http.StatusBadGateway},
+		{"v3_empty.json", http.StatusOK, http.StatusOK},
+	}
+	for _, test := range tests {
+		body := readFixture(t, test.fixture)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.httpStatus)
+			w.Write(body)
+		}))
+
+		router := mux.NewRouter()
+		require.NoError(t, RegisterRoutes(router, authenticateAs("oski"), NewGremlinGraph(server.URL), profileDirectory{}))
+		for _, path := range []string{"/api/friends", "/api/friends/stanfurd/mutual"} {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+			assert.Equal(t, test.wantStatus, rr.Code, "GET %s with %s", path, test.fixture)
+		}
+
+		server.Close()
+	}
+}
@@ -0,0 +1,715 @@
+package api
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+)
+
+// GremlinTransport sends Gremlin queries to a Gremlin server. Errors reported by the server are
+// returned as a *GremlinError.
+type GremlinTransport interface {
+	Query(ctx context.Context, gremlinQuery string, params bindings) (*gremlinResponse, error)
+}
+
+// SessionTransport is a GremlinTransport that can also run queries in a session, whose writes only
+// take effect once the session is committed.
+type SessionTransport interface {
+	GremlinTransport
+	Session(ctx context.Context) (GremlinSession, error)
+}
+
+// GremlinSession runs queries in a single transaction. Once it is committed or rolled back, the
+// session is over and can't be used again.
+type GremlinSession interface {
+	GremlinTransport
+	Commit(ctx context.Context) error
+	Rollback(ctx context.Context) error
+}
+
+// GremlinGraph is a FriendGraph stored in Neptune (or any other Gremlin server), which it talks to
+// through Transport. Users are vertices with a 'uuid' property, and each friendship is a pair of
+// 'friends with' edges, one in each direction. A friend request is a 'friend request' edge from the
+// sender to the recipient with a 'state' property, and a block is a 'blocks' edge from the blocker to
+// the blocked user. A follow is a 'follows' edge from the follower to the followee, which AreFriends
+// and Friends never look at. A friend list is a 'friend list' vertex with an 'owns' edge from its
+// owner and an 'includes' edge to each member.
+type GremlinGraph struct {
+	Transport GremlinTransport
+}
+
+// defaultWebSocketPoolSize is how many connections a GremlinGraph keeps open to a WebSocket endpoint.
+const defaultWebSocketPoolSize = 8
+
+// NewGremlinGraph creates a GremlinGraph that sends its queries to url. ws:// and wss:// URLs are
+// reached over the Gremlin WebSocket protocol, which supports transactions, and anything else over the
+// Gremlin HTTP endpoint.
+func NewGremlinGraph(url string) *GremlinGraph {
+	if strings.HasPrefix(url, "ws://") || strings.HasPrefix(url, "wss://") {
+		return &GremlinGraph{Transport: NewWebSocketClient(url, defaultWebSocketPoolSize)}
+	}
+	return &GremlinGraph{Transport: NewNeptuneClient(url)}
+}
+
+// AddUser adds a user vertex to the graph, unless there already is one with the same UUID.
+func (g *GremlinGraph) AddUser(ctx context.Context, uuid string) error {
+	_, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', uuid).fold().coalesce(unfold(), addV().property('uuid', uuid))", bindings{"uuid": uuid})
+	return err
+}
+
+// AddFriendship adds a 'friends with' edge in each direction between a and b, skipping any edge that
+// already exists. Both edges get a 'createdAt' property holding the time in milliseconds since the
+// epoch. Over a transport with sessions both edges are added in one transaction, so there is
+// never only one of them.
+func (g *GremlinGraph) AddFriendship(ctx context.Context, a, b string) error {
+	return g.addFriendship(ctx, a, b, friendshipTime())
+}
+
+// addFriendship adds the 'friends with' edges between a and b that don't exist yet, with createdAt as
+// their 'createdAt' property.
+func (g *GremlinGraph) addFriendship(ctx context.Context, a, b string, createdAt time.Time) error {
+	if a == b {
+		return ErrSelfFriend
+	}
+	err := g.usersExist(ctx, a, b)
+	if err != nil {
+		return err
+	}
+
+	return g.transaction(ctx, func(q GremlinTransport) error {
+		return addFriendshipEdges(ctx, q, a, b, createdAt)
+	})
+}
+
+// addFriendshipEdges adds the 'friends with' edges between a and b that don't exist yet through q, so
+// callers can add them as part of a bigger transaction.
+func addFriendshipEdges(ctx context.Context, q GremlinTransport, a, b string, createdAt time.Time) error {
+	gq := "g.V().has('uuid', fromUUID).as('from').V().has('uuid', toUUID)" +
+		".coalesce(inE('friends with').where(outV().as('from')), addE('friends with').from('from').property('createdAt', createdAt))"
+	millis := toMillis(createdAt)
+	_, err := q.Query(ctx, gq, bindings{"fromUUID": a, "toUUID": b, "createdAt": millis})
+	if err != nil {
+		return err
+	}
+	_, err = q.Query(ctx, gq, bindings{"fromUUID": b, "toUUID": a, "createdAt": millis})
+	return err
+}
+
+// RemoveFriendship drops the 'friends with' edges between a and b, along with the 'includes' edges
+// that put them in each other's friend lists. Everything is dropped by a single traversal, so it all
+// goes away together.
+func (g *GremlinGraph) RemoveFriendship(ctx context.Context, a, b string) error {
+	return removeFriendshipEdges(ctx, g.Transport, a, b)
+}
+
+// removeFriendshipEdges drops the friendship between a and b through q, so callers can drop it as part
+// of a bigger transaction.
+func removeFriendshipEdges(ctx context.Context, q GremlinTransport, a, b string) error {
+	gq := "g.V().has('uuid', uuid).union(" +
+		"bothE('friends with').where(otherV().has('uuid', otherUUID)), " +
+		"out('owns').outE('includes').where(inV().has('uuid', otherUUID)), " +
+		"inE('includes').where(outV().in('owns').has('uuid', otherUUID))).drop()"
+	_, err := q.Query(ctx, gq, bindings{"uuid": a, "otherUUID": b})
+	return err
+}
+
+// AreFriends reports whether there is a 'friends with' edge from a to b.
+func (g *GremlinGraph) AreFriends(ctx context.Context, a, b string) (bool, error) {
+	count, err := g.queryCount(ctx, "g.V().has('uuid', uuid).outE('friends with').where(otherV().has('uuid', otherUUID)).count()", bindings{"uuid": a, "otherUUID": b})
+	if err != nil {
+		return false, err
+	}
+
+	return count >= 1, nil
+}
+
+// Friendship returns b as one of a's friends, reading when they became friends from the 'createdAt'
+// property of the 'friends with' edge from a to b.
+func (g *GremlinGraph) Friendship(ctx context.Context, a, b string) (Friend, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', uuid).outE('friends with').where(inV().has('uuid', otherUUID))"+
+		".coalesce(values('createdAt'), constant(0L))", bindings{"uuid": a, "otherUUID": b})
+	if err != nil {
+		return Friend{}, err
+	}
+	times := []int64{}
+	err = response.decode(&times)
+	if err != nil {
+		return Friend{}, err
+	}
+	if len(times) == 0 {
+		return Friend{}, ErrNotFriends
+	}
+	return Friend{UUID: b, CreatedAt: fromMillis(times[0])}, nil
+}
+
+// Friends returns the UUIDs of everyone uuid is friends with.
+func (g *GremlinGraph) Friends(ctx context.Context, uuid string) ([]string, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', uuid).out('friends with').values('uuid')", bindings{"uuid": uuid})
+	if err != nil {
+		return nil, err
+	}
+	return response.strings()
+}
+
+// ListFriends returns a page of uuid's friends. Edges added before friendships were timed count as
+// created at the epoch.
+func (g *GremlinGraph) ListFriends(ctx context.Context, uuid string, query FriendQuery) ([]Friend, error) {
+	gq := "g.V().has('uuid', uuid).outE('friends with')" +
+		".project('uuid', 'createdAt').by(inV().values('uuid')).by(coalesce(values('createdAt'), constant(0L)))"
+	params := bindings{"uuid": uuid, "limit": query.Limit}
+	if query.After != nil {
+		params["afterUUID"] = query.After.UUID
+		params["afterTime"] = toMillis(query.After.CreatedAt)
+	}
+
+	switch query.Order {
+	case OrderNewest, OrderOldest:
+		after, direction := "lt", "desc"
+		if query.Order == OrderOldest {
+			after, direction = "gt", "asc"
+		}
+		if query.After != nil {
+			gq += ".where(or(select('createdAt').is(" + after + "(afterTime))," +
+				" and(select('createdAt').is(eq(afterTime)), select('uuid').is(gt(afterUUID)))))"
+		}
+		gq += ".order().by(select('createdAt'), " + direction + ").by(select('uuid'), asc)"
+	default:
+		if query.After != nil {
+			gq += ".where(select('uuid').is(gt(afterUUID)))"
+		}
+		gq += ".order().by(select('uuid'), asc)"
+	}
+	gq += ".limit(limit)"
+
+	response, err := g.makeNeptuneRequest(ctx, gq, params)
+	if err != nil {
+		return nil, err
+	}
+	results := []struct {
+		UUID      string `json:"uuid"`
+		CreatedAt int64  `json:"createdAt"`
+	}{}
+	err = response.decode(&results)
+	if err != nil {
+		return nil, err
+	}
+	friends := []Friend{}
+	for _, result := range results {
+		friends = append(friends, Friend{UUID: result.UUID, CreatedAt: fromMillis(result.CreatedAt)})
+	}
+	return friends, nil
+}
+
+// Mutuals returns the UUIDs of everyone who is friends with both a and b.
+func (g *GremlinGraph) Mutuals(ctx context.Context, a, b string) ([]string, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', uuid).out('friends with').where(out('friends with').has('uuid', otherUUID)).values('uuid')", bindings{"uuid": a, "otherUUID": b})
+	if err != nil {
+		return nil, err
+	}
+	return response.strings()
+}
+
+// SendRequest adds a pending 'friend request' edge from one user to another, replacing any earlier
+// request that was declined or cancelled. Over a transport with sessions, checking for pending requests
+// and adding the edge happen in one transaction, so if two users send each other requests at once,
+// Neptune lets only one of them commit. Without sessions they are separate queries, and both requests
+// can get through.
+func (g *GremlinGraph) SendRequest(ctx context.Context, from, to string) error {
+	if from == to {
+		return ErrSelfFriend
+	}
+	err := g.usersExist(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	count, err := g.queryCount(ctx, "g.V().has('uuid', fromUUID).bothE('blocks').where(otherV().has('uuid', toUUID)).count()", bindings{"fromUUID": from, "toUUID": to})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrBlocked
+	}
+
+	friends, err := g.AreFriends(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	if friends {
+		return ErrAlreadyFriends
+	}
+
+	gq := "g.V().has('uuid', fromUUID).outE('friend request').has('state', pending).where(inV().has('uuid', toUUID)).count()"
+	return g.transaction(ctx, func(q GremlinTransport) error {
+		count, err := countQuery(ctx, q, gq, bindings{"fromUUID": from, "toUUID": to, "pending": RequestPending})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		count, err = countQuery(ctx, q, gq, bindings{"fromUUID": to, "toUUID": from, "pending": RequestPending})
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrRequestPending
+		}
+
+		_, err = q.Query(ctx, "g.V().has('uuid', fromUUID).outE('friend request').where(inV().has('uuid', toUUID)).drop()", bindings{"fromUUID": from, "toUUID": to})
+		if err != nil {
+			return err
+		}
+		_, err = q.Query(ctx, "g.addE('friend request').from(g.V().has('uuid', fromUUID)).to(g.V().has('uuid', toUUID)).property('state', pending)",
+			bindings{"fromUUID": from, "toUUID": to, "pending": RequestPending})
+		return err
+	})
+}
+
+// UpdateRequest sets the state of the pending 'friend request' edge from one user to another, adding
+// the friendship if the request was accepted. Over a transport with sessions it all happens in one
+// transaction. Without one, the friendship is added before the request stops being pending, so if
+// setting the state fails the request can simply be accepted again.
+func (g *GremlinGraph) UpdateRequest(ctx context.Context, from, to string, state RequestState) error {
+	pending := "g.V().has('uuid', fromUUID).outE('friend request').has('state', pending).where(inV().has('uuid', toUUID))"
+	params := bindings{"fromUUID": from, "toUUID": to, "pending": RequestPending, "state": state}
+	return g.transaction(ctx, func(q GremlinTransport) error {
+		count, err := countQuery(ctx, q, pending+".count()", params)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrRequestNotFound
+		}
+
+		if state == RequestAccepted {
+			err = addFriendshipEdges(ctx, q, from, to, friendshipTime())
+			if err != nil {
+				return err
+			}
+		}
+		count, err = countQuery(ctx, q, pending+".property('state', state).count()", params)
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrRequestNotFound
+		}
+		return nil
+	})
+}
+
+// IncomingRequests returns the UUIDs of everyone with a pending friend request to uuid.
+func (g *GremlinGraph) IncomingRequests(ctx context.Context, uuid string) ([]string, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', uuid).inE('friend request').has('state', pending).outV().values('uuid')", bindings{"uuid": uuid, "pending": RequestPending})
+	if err != nil {
+		return nil, err
+	}
+	return response.strings()
+}
+
+// OutgoingRequests returns the UUIDs of everyone uuid has a pending friend request to.
+func (g *GremlinGraph) OutgoingRequests(ctx context.Context, uuid string) ([]string, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', uuid).outE('friend request').has('state', pending).inV().values('uuid')", bindings{"uuid": uuid, "pending": RequestPending})
+	if err != nil {
+		return nil, err
+	}
+	return response.strings()
+}
+
+// Suggestions returns up to limit friends of uuid's friends, skipping the first offset. Every path
+// from uuid to a candidate through a friend counts as one mutual friend.
+func (g *GremlinGraph) Suggestions(ctx context.Context, uuid string, offset, limit int) ([]Suggestion, error) {
+	gq := "g.V().has('uuid', uuid).as('me')" +
+		".out('friends with').aggregate('friends')" +
+		".out('friends with').where(neq('me')).where(without('friends'))" +
+		".where(__.not(bothE('friend request').has('state', pending).otherV().where(eq('me'))))" +
+		".where(__.not(bothE('blocks').otherV().where(eq('me'))))" +
+		".groupCount().by('uuid').unfold()" +
+		".order().by(values, desc).by(keys, asc)" +
+		".range(low, high)" +
+		".project('uuid', 'mutualFriends').by(keys).by(values)"
+	response, err := g.makeNeptuneRequest(ctx, gq, bindings{"uuid": uuid, "pending": RequestPending, "low": offset, "high": offset + limit})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []struct {
+		UUID          string `json:"uuid"`
+		MutualFriends int64  `json:"mutualFriends"`
+	}{}
+	err = response.decode(&results)
+	if err != nil {
+		return nil, err
+	}
+	suggestions := []Suggestion{}
+	for _, result := range results {
+		suggestions = append(suggestions, Suggestion{UUID: result.UUID, MutualFriends: int(result.MutualFriends)})
+	}
+	return suggestions, nil
+}
+
+// Follow adds a 'follows' edge from follower to followee, unless there already is one.
+func (g *GremlinGraph) Follow(ctx context.Context, follower, followee string) error {
+	if follower == followee {
+		return ErrSelfFollow
+	}
+	err := g.usersExist(ctx, follower, followee)
+	if err != nil {
+		return err
+	}
+
+	count, err := g.queryCount(ctx, "g.V().has('uuid', fromUUID).bothE('blocks').where(otherV().has('uuid', toUUID)).count()", bindings{"fromUUID": follower, "toUUID": followee})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrBlocked
+	}
+
+	_, err = g.makeNeptuneRequest(ctx, "g.V().has('uuid', fromUUID).as('from').V().has('uuid', toUUID)"+
+		".coalesce(inE('follows').where(outV().as('from')), addE('follows').from('from'))",
+		bindings{"fromUUID": follower, "toUUID": followee})
+	return err
+}
+
+// Unfollow drops the 'follows' edge from follower to followee.
+func (g *GremlinGraph) Unfollow(ctx context.Context, follower, followee string) error {
+	_, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', fromUUID).outE('follows').where(inV().has('uuid', toUUID)).drop()",
+		bindings{"fromUUID": follower, "toUUID": followee})
+	return err
+}
+
+// Followers returns a page of the users with a 'follows' edge to uuid, sorted.
+func (g *GremlinGraph) Followers(ctx context.Context, uuid string, offset, limit int) ([]string, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', uuid).in('follows').values('uuid').order().range(low, high)",
+		bindings{"uuid": uuid, "low": offset, "high": offset + limit})
+	if err != nil {
+		return nil, err
+	}
+	return response.strings()
+}
+
+// Following returns a page of the users uuid has a 'follows' edge to, sorted.
+func (g *GremlinGraph) Following(ctx context.Context, uuid string, offset, limit int) ([]string, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', uuid).out('follows').values('uuid').order().range(low, high)",
+		bindings{"uuid": uuid, "low": offset, "high": offset + limit})
+	if err != nil {
+		return nil, err
+	}
+	return response.strings()
+}
+
+// FollowCounts counts the 'follows' edges into and out of uuid.
+func (g *GremlinGraph) FollowCounts(ctx context.Context, uuid string) (FollowCounts, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', uuid).project('followers', 'following')"+
+		".by(inE('follows').count()).by(outE('follows').count())", bindings{"uuid": uuid})
+	if err != nil {
+		return FollowCounts{}, err
+	}
+	results := []struct {
+		Followers int64 `json:"followers"`
+		Following int64 `json:"following"`
+	}{}
+	err = response.decode(&results)
+	if err != nil || len(results) == 0 {
+		return FollowCounts{}, err
+	}
+	return FollowCounts{Followers: int(results[0].Followers), Following: int(results[0].Following)}, nil
+}
+
+// Block adds a 'blocks' edge from blocker to blocked unless there already is one, then ends their
+// friendship, their follows and any pending requests between them. Over a transport with sessions it
+// all happens in one transaction, so there is never a block with any of those left in place.
+func (g *GremlinGraph) Block(ctx context.Context, blocker, blocked string) error {
+	err := g.usersExist(ctx, blocker, blocked)
+	if err != nil {
+		return err
+	}
+
+	return g.transaction(ctx, func(q GremlinTransport) error {
+		_, err := q.Query(ctx, "g.V().has('uuid', blockerUUID).as('blocker').V().has('uuid', blockedUUID)"+
+			".coalesce(inE('blocks').where(outV().as('blocker')), addE('blocks').from('blocker'))",
+			bindings{"blockerUUID": blocker, "blockedUUID": blocked})
+		if err != nil {
+			return err
+		}
+
+		err = removeFriendshipEdges(ctx, q, blocker, blocked)
+		if err != nil {
+			return err
+		}
+		_, err = q.Query(ctx, "g.V().has('uuid', blockerUUID).bothE('follows').where(otherV().has('uuid', blockedUUID)).drop()",
+			bindings{"blockerUUID": blocker, "blockedUUID": blocked})
+		if err != nil {
+			return err
+		}
+		gq := "g.V().has('uuid', fromUUID).outE('friend request').has('state', pending).where(inV().has('uuid', toUUID)).property('state', state)"
+		_, err = q.Query(ctx, gq, bindings{"fromUUID": blocker, "toUUID": blocked, "pending": RequestPending, "state": RequestCancelled})
+		if err != nil {
+			return err
+		}
+		_, err = q.Query(ctx, gq, bindings{"fromUUID": blocked, "toUUID": blocker, "pending": RequestPending, "state": RequestDeclined})
+		return err
+	})
+}
+
+// Unblock drops the 'blocks' edge from blocker to blocked.
+func (g *GremlinGraph) Unblock(ctx context.Context, blocker, blocked string) error {
+	_, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', blockerUUID).outE('blocks').where(inV().has('uuid', blockedUUID)).drop()", bindings{"blockerUUID": blocker, "blockedUUID": blocked})
+	return err
+}
+
+// Blockers returns the UUIDs of everyone who has blocked uuid.
+func (g *GremlinGraph) Blockers(ctx context.Context, uuid string) ([]string, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid', uuid).in('blocks').values('uuid')", bindings{"uuid": uuid})
+	if err != nil {
+		return nil, err
+	}
+	return response.strings()
+}
+
+// CreateList adds a 'friend list' vertex with a 'name' property, and an 'owns' edge to it from owner,
+// unless owner already has a list with that name.
+func (g *GremlinGraph) CreateList(ctx context.Context, owner, name string) error {
+	count, err := g.queryCount(ctx, "g.V().has('uuid', owner).as('owner')"+
+		".coalesce(out('owns').has('name', name), addV('friend list').property('name', name).addE('owns').from('owner').inV()).count()",
+		bindings{"owner": owner, "name": name})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// DeleteList drops one of owner's 'friend list' vertices, which drops its edges with it.
+func (g *GremlinGraph) DeleteList(ctx context.Context, owner, name string) error {
+	return g.transaction(ctx, func(q GremlinTransport) error {
+		err := g.listExists(ctx, q, owner, name)
+		if err != nil {
+			return err
+		}
+		_, err = q.Query(ctx, "g.V().has('uuid', owner).out('owns').has('name', name).drop()", bindings{"owner": owner, "name": name})
+		return err
+	})
+}
+
+// Lists returns all of owner's friend lists, ordered by name.
+func (g *GremlinGraph) Lists(ctx context.Context, owner string) ([]FriendList, error) {
+	return g.lists(ctx, "g.V().has('uuid', owner).out('owns').order().by('name')"+
+		".project('name', 'members').by('name').by(out('includes').values('uuid').order().fold())", bindings{"owner": owner})
+}
+
+// List returns one of owner's friend lists.
+func (g *GremlinGraph) List(ctx context.Context, owner, name string) (FriendList, error) {
+	lists, err := g.lists(ctx, "g.V().has('uuid', owner).out('owns').has('name', name)"+
+		".project('name', 'members').by('name').by(out('includes').values('uuid').order().fold())", bindings{"owner": owner, "name": name})
+	if err != nil {
+		return FriendList{}, err
+	}
+	if len(lists) == 0 {
+		return FriendList{}, ErrListNotFound
+	}
+	return lists[0], nil
+}
+
+// lists runs a query that projects friend lists into maps of their name and members.
+func (g *GremlinGraph) lists(ctx context.Context, gremlinQuery string, params bindings) ([]FriendList, error) {
+	response, err := g.makeNeptuneRequest(ctx, gremlinQuery, params)
+	if err != nil {
+		return nil, err
+	}
+	results := []struct {
+		Name    string   `json:"name"`
+		Members []string `json:"members"`
+	}{}
+	err = response.decode(&results)
+	if err != nil {
+		return nil, err
+	}
+
+	lists := []FriendList{}
+	for _, result := range results {
+		list := FriendList{Name: result.Name, Members: result.Members}
+		if list.Members == nil {
+			list.Members = []string{}
+		}
+		lists = append(lists, list)
+	}
+	return lists, nil
+}
+
+// AddToList adds an 'includes' edge from one of owner's lists to member, unless there already is one.
+// Over a transport with sessions, the checks and the insert run in one transaction.
+func (g *GremlinGraph) AddToList(ctx context.Context, owner, name, member string) error {
+	return g.transaction(ctx, func(q GremlinTransport) error {
+		err := g.listExists(ctx, q, owner, name)
+		if err != nil {
+			return err
+		}
+		count, err := countQuery(ctx, q, "g.V().has('uuid', owner).outE('friends with').where(inV().has('uuid', member)).count()",
+			bindings{"owner": owner, "member": member})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			return ErrNotFriends
+		}
+
+		_, err = q.Query(ctx, "g.V().has('uuid', owner).out('owns').has('name', name).as('list').V().has('uuid', member)"+
+			".coalesce(inE('includes').where(outV().as('list')), addE('includes').from('list'))",
+			bindings{"owner": owner, "name": name, "member": member})
+		return err
+	})
+}
+
+// RemoveFromList drops the 'includes' edge from one of owner's lists to member.
+func (g *GremlinGraph) RemoveFromList(ctx context.Context, owner, name, member string) error {
+	return g.transaction(ctx, func(q GremlinTransport) error {
+		err := g.listExists(ctx, q, owner, name)
+		if err != nil {
+			return err
+		}
+		_, err = q.Query(ctx, "g.V().has('uuid', owner).out('owns').has('name', name).outE('includes').where(inV().has('uuid', member)).drop()",
+			bindings{"owner": owner, "name": name, "member": member})
+		return err
+	})
+}
+
+// InList reports whether there is an 'includes' edge from owner's list with the given name to member.
+func (g *GremlinGraph) InList(ctx context.Context, owner, name, member string) (bool, error) {
+	count, err := g.queryCount(ctx, "g.V().has('uuid', owner).out('owns').has('name', name).out('includes').has('uuid', member).count()",
+		bindings{"owner": owner, "name": name, "member": member})
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// listExists returns ErrListNotFound unless owner has a friend list with the given name, asking
+// through q.
+func (g *GremlinGraph) listExists(ctx context.Context, q GremlinTransport, owner, name string) error {
+	count, err := countQuery(ctx, q, "g.V().has('uuid', owner).out('owns').has('name', name).count()", bindings{"owner": owner, "name": name})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return ErrListNotFound
+	}
+	return nil
+}
+
+// Users returns the UUIDs of every user vertex, sorted.
+func (g *GremlinGraph) Users(ctx context.Context) ([]string, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.V().has('uuid').values('uuid').order()", bindings{})
+	if err != nil {
+		return nil, err
+	}
+	return response.strings()
+}
+
+// Friendships returns every friendship once. Each one is stored as an edge in each direction, so only
+// the edge from the lesser UUID is kept.
+func (g *GremlinGraph) Friendships(ctx context.Context) ([]FriendshipEdge, error) {
+	response, err := g.makeNeptuneRequest(ctx, "g.E().hasLabel('friends with').project('a', 'b', 'createdAt')"+
+		".by(outV().values('uuid')).by(inV().values('uuid')).by(coalesce(values('createdAt'), constant(0L)))", bindings{})
+	if err != nil {
+		return nil, err
+	}
+	results := []struct {
+		A         string `json:"a"`
+		B         string `json:"b"`
+		CreatedAt int64  `json:"createdAt"`
+	}{}
+	err = response.decode(&results)
+	if err != nil {
+		return nil, err
+	}
+	friendships := []FriendshipEdge{}
+	for _, result := range results {
+		if result.A < result.B {
+			friendships = append(friendships, FriendshipEdge{A: result.A, B: result.B, CreatedAt: fromMillis(result.CreatedAt)})
+		}
+	}
+	sortFriendships(friendships)
+	return friendships, nil
+}
+
+// RestoreFriendship adds the 'friends with' edges between edge.A and edge.B that don't exist yet, with
+// edge.CreatedAt as their 'createdAt' property.
+func (g *GremlinGraph) RestoreFriendship(ctx context.Context, edge FriendshipEdge) error {
+	return g.addFriendship(ctx, edge.A, edge.B, edge.CreatedAt)
+}
+
+// usersExist returns ErrUserNotFound unless there are vertices for both a and b.
+func (g *GremlinGraph) usersExist(ctx context.Context, a, b string) error {
+	count, err := g.queryCount(ctx, "g.V().has('uuid', within(a, b)).dedup().by('uuid').count()", bindings{"a": a, "b": b})
+	if err != nil {
+		return err
+	}
+	if count < 2 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// toMillis converts t to the milliseconds since the epoch that times are stored as in the graph.
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// fromMillis converts milliseconds since the epoch back into a time.
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+// bindings are the parameters of a Gremlin query. User input must only ever reach a query through its
+// bindings, never by being pasted into the query string, so it can't add traversal steps of its own.
+type bindings map[string]interface{}
+
+// makeNeptuneRequest runs a Gremlin query with the given bindings and returns the decoded response.
+// Errors reported by the Gremlin server are returned as a *GremlinError.
+func (g *GremlinGraph) makeNeptuneRequest(ctx context.Context, gremlinQuery string, params bindings) (*gremlinResponse, error) {
+	return g.Transport.Query(ctx, gremlinQuery, params)
+}
+
+// transaction calls fn with a session to run its queries in, then commits the session if fn succeeds
+// and rolls it back if it doesn't. If the transport has no sessions, fn's queries are sent as usual and
+// each one commits on its own.
+func (g *GremlinGraph) transaction(ctx context.Context, fn func(q GremlinTransport) error) error {
+	transport, ok := g.Transport.(SessionTransport)
+	if !ok {
+		return fn(g.Transport)
+	}
+
+	session, err := transport.Session(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(session)
+	if err != nil {
+		if rollbackErr := session.Rollback(ctx); rollbackErr != nil {
+			log.Print(rollbackErr.Error())
+		}
+		return err
+	}
+	return session.Commit(ctx)
+}
+
+// queryCount runs a Gremlin query ending in count() and returns the count.
+func (g *GremlinGraph) queryCount(ctx context.Context, gremlinQuery string, params bindings) (int64, error) {
+	return countQuery(ctx, g.Transport, gremlinQuery, params)
+}
+
+// countQuery runs a Gremlin query ending in count() through q and returns the count. It is used for
+// queries that may run in a session.
+func countQuery(ctx context.Context, q GremlinTransport, gremlinQuery string, params bindings) (int64, error) {
+	response, err := q.Query(ctx, gremlinQuery, params)
+	if err != nil {
+		return 0, err
+	}
+	return response.count()
+}
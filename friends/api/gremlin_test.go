@@ -0,0 +1,267 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gremlinRequest is the body of a request to the Gremlin HTTP endpoint.
+type gremlinRequest struct {
+	Gremlin  string                 `json:"gremlin"`
+	Bindings map[string]interface{} `json:"bindings"`
+}
+
+// fakeGremlin stands in for the Gremlin HTTP endpoint. It records every query it is sent and answers
+// with whatever respond returns, or an empty list if respond is nil.
+type fakeGremlin struct {
+	mu       sync.Mutex
+	requests []gremlinRequest
+	respond  func(req gremlinRequest) string
+}
+
+func (f *fakeGremlin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := gremlinRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f.mu.Lock()
+	f.requests = append(f.requests, req)
+	respond := f.respond
+	f.mu.Unlock()
+
+	data := `{"@type":"g:List","@value":[]}`
+	if respond != nil {
+		data = respond(req)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"requestId":"test","status":{"message":"","code":200,"attributes":{}},"result":{"data":` + data + `,"meta":{}}}`))
+}
+
+// Returns every request the fake has received so far.
+func (f *fakeGremlin) received() []gremlinRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]gremlinRequest(nil), f.requests...)
+}
+
+// Returns a middleware that authenticates every request as userID.
+func authenticateAs(userID string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims := &auth.AuthClaims{UserID: userID}
+			next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), claims)))
+		})
+	}
+}
+
+// Makes sure user input only ever reaches Gremlin through bindings, no matter what it contains.
+func TestGremlinInjection(t *testing.T) {
+	malicious := []string{
+		"x').V().drop().V().has('uuid', 'y",
+		"x\\'); g.V().drop(); //",
+		"' + g.V().count() + '",
+		"x\").V().drop().V().has(\"uuid\", \"y",
+	}
+
+	for _, payload := range malicious {
+		fake := &fakeGremlin{respond: func(req gremlinRequest) string {
+			// Both users exist, so requests go through every query they can make.
+			if strings.Contains(req.Gremlin, "within(") {
+				return `{"@type":"g:List","@value":[{"@type":"g:Int64","@value":2}]}`
+			}
+			if strings.HasSuffix(req.Gremlin, ".count()") {
+				return `{"@type":"g:List","@value":[{"@type":"g:Int64","@value":0}]}`
+			}
+			return `{"@type":"g:List","@value":[]}`
+		}}
+		server := httptest.NewServer(fake)
+
+		// The payload is used as the UUID in the path, and a variation of it as the caller's UUID.
+		caller := payload + "' //"
+		router := mux.NewRouter()
+		require.NoError(t, RegisterRoutes(router, authenticateAs(caller), NewGremlinGraph(server.URL), profileDirectory{}))
+
+		requests := []*http.Request{
+			httptest.NewRequest(http.MethodGet, "/api/friends", nil),
+			httptest.NewRequest(http.MethodPost, "/api/friends", nil),
+			httptest.NewRequest(http.MethodGet, "/api/friends/"+url.PathEscape(payload), nil),
+			httptest.NewRequest(http.MethodPost, "/api/friends/"+url.PathEscape(payload), nil),
+			httptest.NewRequest(http.MethodDelete, "/api/friends/"+url.PathEscape(payload), nil),
+			httptest.NewRequest(http.MethodGet, "/api/friends/"+url.PathEscape(payload)+"/mutual", nil),
+			httptest.NewRequest(http.MethodPost, "/api/friends/"+url.PathEscape(payload)+"/block", nil),
+			httptest.NewRequest(http.MethodDelete, "/api/friends/"+url.PathEscape(payload)+"/block", nil),
+			httptest.NewRequest(http.MethodGet, "/api/friends?order=newest&cursor="+encodeCursor(OrderNewest, Friend{UUID: payload}), nil),
+			httptest.NewRequest(http.MethodGet, "/api/friends/lists", nil),
+			httptest.NewRequest(http.MethodPost, "/api/friends/"+url.PathEscape(payload)+"/follow", nil),
+			httptest.NewRequest(http.MethodGet, "/api/friends/"+url.PathEscape(payload)+"/followers", nil),
+		}
+		for _, r := range requests {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+			assert.NotEqual(t, http.StatusNotFound, rr.Code, "%s %s did not reach a handler", r.Method, r.URL)
+		}
+
+		received := fake.received()
+		assert.NotEmpty(t, received, "no queries were sent")
+		for _, req := range received {
+			assert.NotContains(t, req.Gremlin, payload, "user input was pasted into the query")
+			found := false
+			for _, value := range req.Bindings {
+				if value == payload || value == caller {
+					found = true
+				}
+			}
+			assert.True(t, found, "user input was not passed as a binding to %q", req.Gremlin)
+		}
+
+		server.Close()
+	}
+}
+
+// Checks that suggestions are read out of the projected GraphSON maps Neptune returns.
+func TestGremlinSuggestions(t *testing.T) {
+	fake := &fakeGremlin{respond: func(req gremlinRequest) string {
+		return `{"@type":"g:List","@value":[` +
+			`{"@type":"g:Map","@value":["uuid","duck","mutualFriends",{"@type":"g:Int64","@value":3}]},` +
+			`{"@type":"g:Map","@value":["uuid","husky","mutualFriends",{"@type":"g:Int64","@value":2}]}` +
+			`]}`
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	suggestions, err := NewGremlinGraph(server.URL).Suggestions(context.Background(), "oski", 20, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []Suggestion{{"duck", 3}, {"husky", 2}}, suggestions)
+
+	received := fake.received()
+	require.Len(t, received, 1)
+	assert.Equal(t, "oski", received[0].Bindings["uuid"])
+	assert.EqualValues(t, 20, received[0].Bindings["low"])
+	assert.EqualValues(t, 30, received[0].Bindings["high"])
+}
+
+// Checks that pages of friends are read out of the projected GraphSON maps, with creation times in
+// milliseconds, and that the cursor is passed as bindings.
+func TestGremlinListFriends(t *testing.T) {
+	fake := &fakeGremlin{respond: func(req gremlinRequest) string {
+		return `{"@type":"g:List","@value":[` +
+			`{"@type":"g:Map","@value":["uuid","duck","createdAt",{"@type":"g:Int64","@value":1598432400000}]},` +
+			`{"@type":"g:Map","@value":["uuid","husky","createdAt",{"@type":"g:Int64","@value":0}]}` +
+			`]}`
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	after := &Friend{UUID: "bruin", CreatedAt: time.Date(2020, time.August, 27, 9, 0, 0, 0, time.UTC)}
+	friends, err := NewGremlinGraph(server.URL).ListFriends(context.Background(), "oski", FriendQuery{Order: OrderNewest, After: after, Limit: 3})
+	require.NoError(t, err)
+	assert.Equal(t, []Friend{
+		{UUID: "duck", CreatedAt: time.Date(2020, time.August, 26, 9, 0, 0, 0, time.UTC)},
+		{UUID: "husky", CreatedAt: time.Unix(0, 0).UTC()},
+	}, friends)
+
+	received := fake.received()
+	require.Len(t, received, 1)
+	assert.Contains(t, received[0].Gremlin, "order().by(select('createdAt'), desc)")
+	assert.Equal(t, "bruin", received[0].Bindings["afterUUID"])
+	assert.EqualValues(t, 1598518800000, received[0].Bindings["afterTime"])
+	assert.EqualValues(t, 3, received[0].Bindings["limit"])
+}
+
+// Checks that each friendship is exported once, from the edge leaving the lesser UUID.
+func TestGremlinFriendships(t *testing.T) {
+	fake := &fakeGremlin{respond: func(req gremlinRequest) string {
+		return `{"@type":"g:List","@value":[` +
+			`{"@type":"g:Map","@value":["a","oski","b","duck","createdAt",{"@type":"g:Int64","@value":1598432400000}]},` +
+			`{"@type":"g:Map","@value":["a","duck","b","oski","createdAt",{"@type":"g:Int64","@value":1598432400000}]},` +
+			`{"@type":"g:Map","@value":["a","bruin","b","oski","createdAt",{"@type":"g:Int64","@value":0}]}` +
+			`]}`
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	friendships, err := NewGremlinGraph(server.URL).Friendships(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []FriendshipEdge{
+		{A: "bruin", B: "oski", CreatedAt: time.Unix(0, 0).UTC()},
+		{A: "duck", B: "oski", CreatedAt: time.Date(2020, time.August, 26, 9, 0, 0, 0, time.UTC)},
+	}, friendships)
+}
+
+// Checks that friend lists are read out of their projected GraphSON maps, and friendship times out of
+// their edges.
+func TestGremlinFriendLists(t *testing.T) {
+	fake := &fakeGremlin{respond: func(req gremlinRequest) string {
+		switch {
+		case strings.Contains(req.Gremlin, "project('name', 'members')"):
+			return `{"@type":"g:List","@value":[` +
+				`{"@type":"g:Map","@value":["name","bears","members",{"@type":"g:List","@value":[]}]},` +
+				`{"@type":"g:Map","@value":["name","close friends","members",{"@type":"g:List","@value":["bruin","stanfurd"]}]}` +
+				`]}`
+		case req.Bindings["otherUUID"] == "stanfurd":
+			return `{"@type":"g:List","@value":[{"@type":"g:Int64","@value":1598432400000}]}`
+		default:
+			return `{"@type":"g:List","@value":[]}`
+		}
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	ctx := context.Background()
+	graph := NewGremlinGraph(server.URL)
+
+	lists, err := graph.Lists(ctx, "oski")
+	require.NoError(t, err)
+	assert.Equal(t, []FriendList{{"bears", []string{}}, {"close friends", []string{"bruin", "stanfurd"}}}, lists)
+
+	friend, err := graph.Friendship(ctx, "oski", "stanfurd")
+	require.NoError(t, err)
+	assert.Equal(t, Friend{UUID: "stanfurd", CreatedAt: time.Date(2020, time.August, 26, 9, 0, 0, 0, time.UTC)}, friend)
+	_, err = graph.Friendship(ctx, "oski", "tree")
+	assert.Equal(t, ErrNotFriends, err)
+}
+
+// Checks that vertices and edges are upserted, and that friendships with missing users are rejected
+// before any edges are added.
+func TestGremlinUpserts(t *testing.T) {
+	users := int32(2)
+	fake := &fakeGremlin{respond: func(req gremlinRequest) string {
+		if strings.HasSuffix(req.Gremlin, ".count()") {
+			count := strconv.Itoa(int(atomic.LoadInt32(&users)))
+			return `{"@type":"g:List","@value":[{"@type":"g:Int64","@value":` + count + `}]}`
+		}
+		return `{"@type":"g:List","@value":[]}`
+	}}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	ctx := context.Background()
+	graph := NewGremlinGraph(server.URL)
+
+	require.NoError(t, graph.AddUser(ctx, "oski"))
+	require.NoError(t, graph.AddFriendship(ctx, "oski", "stanfurd"))
+	for _, req := range fake.received() {
+		if strings.Contains(req.Gremlin, "addV") || strings.Contains(req.Gremlin, "addE") {
+			assert.Contains(t, req.Gremlin, "coalesce(", "%q adds without checking for an existing element", req.Gremlin)
+		}
+	}
+
+	atomic.StoreInt32(&users, 1)
+	sent := len(fake.received())
+	assert.Equal(t, ErrUserNotFound, graph.AddFriendship(ctx, "oski", "nobody"))
+	assert.Len(t, fake.received(), sent+1, "only the existence check should have been sent")
+}
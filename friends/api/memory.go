@@ -0,0 +1,513 @@
+package api
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryGraph is a FriendGraph that lives entirely in memory. Nothing is persisted, so it is meant for
+// tests and local development.
+type MemoryGraph struct {
+	mu       sync.RWMutex
+	friends  map[string]map[string]time.Time       // user -> friend -> when they became friends
+	requests map[string]map[string]RequestState    // sender -> recipient -> state
+	blocks   map[string]map[string]bool            // blocker -> blocked
+	lists    map[string]map[string]map[string]bool // owner -> list name -> members
+	follows  map[string]map[string]bool            // follower -> followee
+}
+
+// NewMemoryGraph creates an empty MemoryGraph.
+func NewMemoryGraph() *MemoryGraph {
+	return &MemoryGraph{
+		friends:  make(map[string]map[string]time.Time),
+		requests: make(map[string]map[string]RequestState),
+		blocks:   make(map[string]map[string]bool),
+		lists:    make(map[string]map[string]map[string]bool),
+		follows:  make(map[string]map[string]bool),
+	}
+}
+
+// AddUser adds a user to the graph.
+func (g *MemoryGraph) AddUser(ctx context.Context, uuid string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.friends[uuid]; !ok {
+		g.friends[uuid] = make(map[string]time.Time)
+	}
+	return nil
+}
+
+// AddFriendship makes a and b friends with each other.
+func (g *MemoryGraph) AddFriendship(ctx context.Context, a, b string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.addFriendship(a, b, friendshipTime())
+}
+
+// addFriendship makes a and b friends as of createdAt, unless they already are. g.mu must be held.
+func (g *MemoryGraph) addFriendship(a, b string, createdAt time.Time) error {
+	if a == b {
+		return ErrSelfFriend
+	}
+	if g.friends[a] == nil || g.friends[b] == nil {
+		return ErrUserNotFound
+	}
+	if g.friendsWith(a, b) {
+		return nil
+	}
+	g.friends[a][b] = createdAt
+	g.friends[b][a] = createdAt
+	return nil
+}
+
+// RemoveFriendship ends the friendship between a and b.
+func (g *MemoryGraph) RemoveFriendship(ctx context.Context, a, b string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeFriendship(a, b)
+	return nil
+}
+
+// removeFriendship does the work of RemoveFriendship. g.mu must be held.
+func (g *MemoryGraph) removeFriendship(a, b string) {
+	delete(g.friends[a], b)
+	delete(g.friends[b], a)
+	for _, members := range g.lists[a] {
+		delete(members, b)
+	}
+	for _, members := range g.lists[b] {
+		delete(members, a)
+	}
+}
+
+// AreFriends reports whether a and b are friends.
+func (g *MemoryGraph) AreFriends(ctx context.Context, a, b string) (bool, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.friendsWith(a, b), nil
+}
+
+// Friendship returns b as one of a's friends.
+func (g *MemoryGraph) Friendship(ctx context.Context, a, b string) (Friend, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	createdAt, ok := g.friends[a][b]
+	if !ok {
+		return Friend{}, ErrNotFriends
+	}
+	return Friend{UUID: b, CreatedAt: createdAt}, nil
+}
+
+// friendsWith reports whether a and b are friends. g.mu must be held.
+func (g *MemoryGraph) friendsWith(a, b string) bool {
+	_, ok := g.friends[a][b]
+	return ok
+}
+
+// Friends returns the UUIDs of everyone uuid is friends with, sorted.
+func (g *MemoryGraph) Friends(ctx context.Context, uuid string) ([]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	friends := []string{}
+	for friend := range g.friends[uuid] {
+		friends = append(friends, friend)
+	}
+	sort.Strings(friends)
+	return friends, nil
+}
+
+// ListFriends returns a page of uuid's friends.
+func (g *MemoryGraph) ListFriends(ctx context.Context, uuid string, query FriendQuery) ([]Friend, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	friends := []Friend{}
+	for friend, createdAt := range g.friends[uuid] {
+		f := Friend{UUID: friend, CreatedAt: createdAt}
+		if query.After == nil || query.before(*query.After, f) {
+			friends = append(friends, f)
+		}
+	}
+	sort.Slice(friends, func(i, j int) bool { return query.before(friends[i], friends[j]) })
+	if len(friends) > query.Limit {
+		friends = friends[:query.Limit]
+	}
+	return friends, nil
+}
+
+// Mutuals returns the UUIDs of everyone who is friends with both a and b, sorted.
+func (g *MemoryGraph) Mutuals(ctx context.Context, a, b string) ([]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	mutuals := []string{}
+	for friend := range g.friends[a] {
+		if g.friendsWith(b, friend) {
+			mutuals = append(mutuals, friend)
+		}
+	}
+	sort.Strings(mutuals)
+	return mutuals, nil
+}
+
+// SendRequest sends a friend request from one user to another.
+func (g *MemoryGraph) SendRequest(ctx context.Context, from, to string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if from == to {
+		return ErrSelfFriend
+	}
+	if g.friends[from] == nil || g.friends[to] == nil {
+		return ErrUserNotFound
+	}
+	if g.blocks[from][to] || g.blocks[to][from] {
+		return ErrBlocked
+	}
+	if g.friendsWith(from, to) {
+		return ErrAlreadyFriends
+	}
+	if g.requests[from][to] == RequestPending {
+		return nil
+	}
+	if g.requests[to][from] == RequestPending {
+		return ErrRequestPending
+	}
+	if g.requests[from] == nil {
+		g.requests[from] = make(map[string]RequestState)
+	}
+	g.requests[from][to] = RequestPending
+	return nil
+}
+
+// UpdateRequest moves the pending request from one user to another into state.
+func (g *MemoryGraph) UpdateRequest(ctx context.Context, from, to string, state RequestState) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.requests[from][to] != RequestPending {
+		return ErrRequestNotFound
+	}
+	if state == RequestAccepted {
+		err := g.addFriendship(from, to, friendshipTime())
+		if err != nil {
+			return err
+		}
+	}
+	g.requests[from][to] = state
+	return nil
+}
+
+// IncomingRequests returns the UUIDs of everyone with a pending friend request to uuid, sorted.
+func (g *MemoryGraph) IncomingRequests(ctx context.Context, uuid string) ([]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	senders := []string{}
+	for sender, requests := range g.requests {
+		if requests[uuid] == RequestPending {
+			senders = append(senders, sender)
+		}
+	}
+	sort.Strings(senders)
+	return senders, nil
+}
+
+// OutgoingRequests returns the UUIDs of everyone uuid has a pending friend request to, sorted.
+func (g *MemoryGraph) OutgoingRequests(ctx context.Context, uuid string) ([]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	recipients := []string{}
+	for recipient, state := range g.requests[uuid] {
+		if state == RequestPending {
+			recipients = append(recipients, recipient)
+		}
+	}
+	sort.Strings(recipients)
+	return recipients, nil
+}
+
+// Suggestions returns up to limit friends of uuid's friends, skipping the first offset.
+func (g *MemoryGraph) Suggestions(ctx context.Context, uuid string, offset, limit int) ([]Suggestion, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	mutuals := make(map[string]int)
+	for friend := range g.friends[uuid] {
+		for candidate := range g.friends[friend] {
+			if candidate == uuid || g.friendsWith(uuid, candidate) ||
+				g.requests[uuid][candidate] == RequestPending || g.requests[candidate][uuid] == RequestPending ||
+				g.blocks[uuid][candidate] || g.blocks[candidate][uuid] {
+				continue
+			}
+			mutuals[candidate]++
+		}
+	}
+
+	suggestions := []Suggestion{}
+	for candidate, count := range mutuals {
+		suggestions = append(suggestions, Suggestion{UUID: candidate, MutualFriends: count})
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].MutualFriends != suggestions[j].MutualFriends {
+			return suggestions[i].MutualFriends > suggestions[j].MutualFriends
+		}
+		return suggestions[i].UUID < suggestions[j].UUID
+	})
+
+	if offset >= len(suggestions) {
+		return []Suggestion{}, nil
+	}
+	suggestions = suggestions[offset:]
+	if limit < len(suggestions) {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions, nil
+}
+
+// Follow makes follower follow followee.
+func (g *MemoryGraph) Follow(ctx context.Context, follower, followee string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if follower == followee {
+		return ErrSelfFollow
+	}
+	if g.friends[follower] == nil || g.friends[followee] == nil {
+		return ErrUserNotFound
+	}
+	if g.blocks[follower][followee] || g.blocks[followee][follower] {
+		return ErrBlocked
+	}
+	if g.follows[follower] == nil {
+		g.follows[follower] = make(map[string]bool)
+	}
+	g.follows[follower][followee] = true
+	return nil
+}
+
+// Unfollow makes follower stop following followee.
+func (g *MemoryGraph) Unfollow(ctx context.Context, follower, followee string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.follows[follower], followee)
+	return nil
+}
+
+// Followers returns a page of the users following uuid, sorted.
+func (g *MemoryGraph) Followers(ctx context.Context, uuid string, offset, limit int) ([]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	followers := []string{}
+	for follower, followees := range g.follows {
+		if followees[uuid] {
+			followers = append(followers, follower)
+		}
+	}
+	return page(followers, offset, limit), nil
+}
+
+// Following returns a page of the users uuid follows, sorted.
+func (g *MemoryGraph) Following(ctx context.Context, uuid string, offset, limit int) ([]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	following := []string{}
+	for followee := range g.follows[uuid] {
+		following = append(following, followee)
+	}
+	return page(following, offset, limit), nil
+}
+
+// FollowCounts returns how many users follow uuid and how many uuid follows.
+func (g *MemoryGraph) FollowCounts(ctx context.Context, uuid string) (FollowCounts, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	counts := FollowCounts{Following: len(g.follows[uuid])}
+	for _, followees := range g.follows {
+		if followees[uuid] {
+			counts.Followers++
+		}
+	}
+	return counts, nil
+}
+
+// page sorts uuids and returns up to limit of them, skipping the first offset.
+func page(uuids []string, offset, limit int) []string {
+	sort.Strings(uuids)
+	if offset >= len(uuids) {
+		return []string{}
+	}
+	uuids = uuids[offset:]
+	if limit < len(uuids) {
+		uuids = uuids[:limit]
+	}
+	return uuids
+}
+
+// Block makes blocker block blocked, ending their friendship and any pending requests between them.
+func (g *MemoryGraph) Block(ctx context.Context, blocker, blocked string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.friends[blocker] == nil || g.friends[blocked] == nil {
+		return ErrUserNotFound
+	}
+	if g.blocks[blocker] == nil {
+		g.blocks[blocker] = make(map[string]bool)
+	}
+	g.blocks[blocker][blocked] = true
+
+	g.removeFriendship(blocker, blocked)
+	delete(g.follows[blocker], blocked)
+	delete(g.follows[blocked], blocker)
+	if g.requests[blocker][blocked] == RequestPending {
+		g.requests[blocker][blocked] = RequestCancelled
+	}
+	if g.requests[blocked][blocker] == RequestPending {
+		g.requests[blocked][blocker] = RequestDeclined
+	}
+	return nil
+}
+
+// Unblock lifts blocker's block on blocked.
+func (g *MemoryGraph) Unblock(ctx context.Context, blocker, blocked string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.blocks[blocker], blocked)
+	return nil
+}
+
+// Blockers returns the UUIDs of everyone who has blocked uuid, sorted.
+func (g *MemoryGraph) Blockers(ctx context.Context, uuid string) ([]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	blockers := []string{}
+	for blocker, blocked := range g.blocks {
+		if blocked[uuid] {
+			blockers = append(blockers, blocker)
+		}
+	}
+	sort.Strings(blockers)
+	return blockers, nil
+}
+
+// CreateList creates an empty friend list for owner.
+func (g *MemoryGraph) CreateList(ctx context.Context, owner, name string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.friends[owner] == nil {
+		return ErrUserNotFound
+	}
+	if g.lists[owner] == nil {
+		g.lists[owner] = make(map[string]map[string]bool)
+	}
+	if g.lists[owner][name] == nil {
+		g.lists[owner][name] = make(map[string]bool)
+	}
+	return nil
+}
+
+// DeleteList deletes one of owner's friend lists.
+func (g *MemoryGraph) DeleteList(ctx context.Context, owner, name string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.lists[owner][name] == nil {
+		return ErrListNotFound
+	}
+	delete(g.lists[owner], name)
+	return nil
+}
+
+// Lists returns all of owner's friend lists, ordered by name.
+func (g *MemoryGraph) Lists(ctx context.Context, owner string) ([]FriendList, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	lists := []FriendList{}
+	for name, members := range g.lists[owner] {
+		lists = append(lists, newFriendList(name, members))
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].Name < lists[j].Name })
+	return lists, nil
+}
+
+// List returns one of owner's friend lists.
+func (g *MemoryGraph) List(ctx context.Context, owner, name string) (FriendList, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	members := g.lists[owner][name]
+	if members == nil {
+		return FriendList{}, ErrListNotFound
+	}
+	return newFriendList(name, members), nil
+}
+
+// newFriendList builds the FriendList with the given name and set of members.
+func newFriendList(name string, members map[string]bool) FriendList {
+	list := FriendList{Name: name, Members: []string{}}
+	for member := range members {
+		list.Members = append(list.Members, member)
+	}
+	sort.Strings(list.Members)
+	return list
+}
+
+// AddToList puts one of owner's friends into one of their lists.
+func (g *MemoryGraph) AddToList(ctx context.Context, owner, name, member string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.lists[owner][name] == nil {
+		return ErrListNotFound
+	}
+	if !g.friendsWith(owner, member) {
+		return ErrNotFriends
+	}
+	g.lists[owner][name][member] = true
+	return nil
+}
+
+// RemoveFromList takes member out of one of owner's friend lists.
+func (g *MemoryGraph) RemoveFromList(ctx context.Context, owner, name, member string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.lists[owner][name] == nil {
+		return ErrListNotFound
+	}
+	delete(g.lists[owner][name], member)
+	return nil
+}
+
+// InList reports whether owner has put member in the list with the given name.
+func (g *MemoryGraph) InList(ctx context.Context, owner, name, member string) (bool, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.lists[owner][name][member], nil
+}
+
+// Users returns the UUIDs of every user in the graph, sorted.
+func (g *MemoryGraph) Users(ctx context.Context) ([]string, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	users := []string{}
+	for user := range g.friends {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// Friendships returns every friendship in the graph once.
+func (g *MemoryGraph) Friendships(ctx context.Context) ([]FriendshipEdge, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	friendships := []FriendshipEdge{}
+	for user, friends := range g.friends {
+		for friend, createdAt := range friends {
+			if user < friend {
+				friendships = append(friendships, FriendshipEdge{A: user, B: friend, CreatedAt: createdAt})
+			}
+		}
+	}
+	sortFriendships(friendships)
+	return friendships, nil
+}
+
+// RestoreFriendship makes edge.A and edge.B friends as of edge.CreatedAt.
+func (g *MemoryGraph) RestoreFriendship(ctx context.Context, edge FriendshipEdge) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.addFriendship(edge.A, edge.B, edge.CreatedAt.UTC().Truncate(time.Millisecond))
+}
@@ -0,0 +1,658 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// MySQLGraph is a FriendGraph backed by the friendsDB MySQL database. Each friendship is stored as
+// two rows of the friendships adjacency table, one in each direction, so lookups only ever need to
+// look at userId.
+type MySQLGraph struct {
+	DB *sql.DB
+}
+
+// NewMySQLGraph creates a MySQLGraph using the given connection to friendsDB.
+func NewMySQLGraph(db *sql.DB) *MySQLGraph {
+	return &MySQLGraph{DB: db}
+}
+
+// AddUser adds a user to the graph.
+func (g *MySQLGraph) AddUser(ctx context.Context, uuid string) error {
+	_, err := g.DB.ExecContext(ctx, "INSERT IGNORE INTO users (uuid) VALUES (?)", uuid)
+	return err
+}
+
+// AddFriendship makes a and b friends with each other. Both directions are inserted in one transaction.
+func (g *MySQLGraph) AddFriendship(ctx context.Context, a, b string) error {
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = addFriendship(ctx, tx, a, b, friendshipTime())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// addFriendship makes a and b friends as of createdAt inside tx, unless they already are.
+func addFriendship(ctx context.Context, tx *sql.Tx, a, b string, createdAt time.Time) error {
+	if a == b {
+		return ErrSelfFriend
+	}
+	err := usersExist(ctx, tx, a, b)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT IGNORE INTO friendships (userId, friendId, createdAt) VALUES (?, ?, ?), (?, ?, ?)",
+		a, b, createdAt, b, a, createdAt)
+	return err
+}
+
+// usersExist returns ErrUserNotFound unless both a and b are in the users table.
+func usersExist(ctx context.Context, tx *sql.Tx, a, b string) error {
+	var count int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE uuid IN (?, ?)", a, b).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count < 2 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// lockUsers is usersExist, but also locks a's and b's rows in the users table until tx ends. Changes
+// between two users that check before they write take these locks first, so two of them at once run
+// one after the other, and the second sees what the first wrote.
+func lockUsers(ctx context.Context, tx *sql.Tx, a, b string) error {
+	var count int
+	err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE uuid IN (?, ?) FOR UPDATE", a, b).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count < 2 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// RemoveFriendship ends the friendship between a and b, deleting both directions and their places in
+// each other's friend lists in one transaction.
+func (g *MySQLGraph) RemoveFriendship(ctx context.Context, a, b string) error {
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = removeFriendship(ctx, tx, a, b)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeFriendship does the work of RemoveFriendship inside tx.
+func removeFriendship(ctx context.Context, tx *sql.Tx, a, b string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM friendships WHERE (userId=? AND friendId=?) OR (userId=? AND friendId=?)", a, b, b, a)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM friendListMembers WHERE (ownerId=? AND memberId=?) OR (ownerId=? AND memberId=?)", a, b, b, a)
+	return err
+}
+
+// Friendship returns b as one of a's friends.
+func (g *MySQLGraph) Friendship(ctx context.Context, a, b string) (Friend, error) {
+	friend := Friend{UUID: b}
+	err := g.DB.QueryRowContext(ctx, "SELECT createdAt FROM friendships WHERE userId=? AND friendId=?", a, b).Scan(&friend.CreatedAt)
+	if err == sql.ErrNoRows {
+		return Friend{}, ErrNotFriends
+	}
+	return friend, err
+}
+
+// AreFriends reports whether a and b are friends.
+func (g *MySQLGraph) AreFriends(ctx context.Context, a, b string) (bool, error) {
+	var exists bool
+	err := g.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM friendships WHERE userId=? AND friendId=?)", a, b).Scan(&exists)
+	return exists, err
+}
+
+// Friends returns the UUIDs of everyone uuid is friends with, sorted.
+func (g *MySQLGraph) Friends(ctx context.Context, uuid string) ([]string, error) {
+	rows, err := g.DB.QueryContext(ctx, "SELECT friendId FROM friendships WHERE userId=? ORDER BY friendId", uuid)
+	if err != nil {
+		return nil, err
+	}
+	return scanUUIDs(rows)
+}
+
+// ListFriends returns a page of uuid's friends. The friendships table is indexed on
+// (userId, createdAt), so pages are found without sorting every friendship.
+func (g *MySQLGraph) ListFriends(ctx context.Context, uuid string, query FriendQuery) ([]Friend, error) {
+	where, orderBy := "", "friendId"
+	args := []interface{}{uuid}
+	switch query.Order {
+	case OrderNewest:
+		orderBy = "createdAt DESC, friendId"
+		if query.After != nil {
+			where = " AND (createdAt < ? OR (createdAt = ? AND friendId > ?))"
+			args = append(args, query.After.CreatedAt, query.After.CreatedAt, query.After.UUID)
+		}
+	case OrderOldest:
+		orderBy = "createdAt, friendId"
+		if query.After != nil {
+			where = " AND (createdAt > ? OR (createdAt = ? AND friendId > ?))"
+			args = append(args, query.After.CreatedAt, query.After.CreatedAt, query.After.UUID)
+		}
+	default:
+		if query.After != nil {
+			where = " AND friendId > ?"
+			args = append(args, query.After.UUID)
+		}
+	}
+	args = append(args, query.Limit)
+
+	rows, err := g.DB.QueryContext(ctx, "SELECT friendId, createdAt FROM friendships WHERE userId=?"+where+
+		" ORDER BY "+orderBy+" LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	friends := []Friend{}
+	for rows.Next() {
+		friend := Friend{}
+		err = rows.Scan(&friend.UUID, &friend.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		friends = append(friends, friend)
+	}
+	return friends, rows.Err()
+}
+
+// Mutuals returns the UUIDs of everyone who is friends with both a and b, sorted.
+func (g *MySQLGraph) Mutuals(ctx context.Context, a, b string) ([]string, error) {
+	rows, err := g.DB.QueryContext(ctx, "SELECT f1.friendId FROM friendships f1 "+
+		"JOIN friendships f2 ON f1.friendId=f2.friendId "+
+		"WHERE f1.userId=? AND f2.userId=? ORDER BY f1.friendId", a, b)
+	if err != nil {
+		return nil, err
+	}
+	return scanUUIDs(rows)
+}
+
+// SendRequest sends a friend request from one user to another. The request is stored in the
+// friendRequests table, replacing any earlier request that was declined or cancelled.
+func (g *MySQLGraph) SendRequest(ctx context.Context, from, to string) error {
+	if from == to {
+		return ErrSelfFriend
+	}
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock both users, so that if they send each other requests at once, the second one finds the
+	// first pending rather than both being sent
+	err = lockUsers(ctx, tx, from, to)
+	if err != nil {
+		return err
+	}
+
+	var blocked, friends bool
+	var sent, received RequestState
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM blocks WHERE "+
+		"(blockerId=? AND blockedId=?) OR (blockerId=? AND blockedId=?))", from, to, to, from).Scan(&blocked)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM friendships WHERE userId=? AND friendId=?)", from, to).Scan(&friends)
+	if err != nil {
+		return err
+	}
+	if friends {
+		return ErrAlreadyFriends
+	}
+	err = tx.QueryRowContext(ctx, "SELECT state FROM friendRequests WHERE senderId=? AND recipientId=? FOR UPDATE", from, to).Scan(&sent)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if sent == RequestPending {
+		return nil
+	}
+	err = tx.QueryRowContext(ctx, "SELECT state FROM friendRequests WHERE senderId=? AND recipientId=? FOR UPDATE", to, from).Scan(&received)
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if received == RequestPending {
+		return ErrRequestPending
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO friendRequests (senderId, recipientId, state) VALUES (?, ?, ?) "+
+		"ON DUPLICATE KEY UPDATE state=VALUES(state)", from, to, RequestPending)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateRequest moves the pending request from one user to another into state. Accepting the request
+// adds the friendship in the same transaction.
+func (g *MySQLGraph) UpdateRequest(ctx context.Context, from, to string, state RequestState) error {
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "UPDATE friendRequests SET state=? WHERE senderId=? AND recipientId=? AND state=?",
+		state, from, to, RequestPending)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrRequestNotFound
+	}
+
+	if state == RequestAccepted {
+		err = addFriendship(ctx, tx, from, to, friendshipTime())
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// IncomingRequests returns the UUIDs of everyone with a pending friend request to uuid, sorted.
+func (g *MySQLGraph) IncomingRequests(ctx context.Context, uuid string) ([]string, error) {
+	rows, err := g.DB.QueryContext(ctx, "SELECT senderId FROM friendRequests WHERE recipientId=? AND state=? ORDER BY senderId", uuid, RequestPending)
+	if err != nil {
+		return nil, err
+	}
+	return scanUUIDs(rows)
+}
+
+// OutgoingRequests returns the UUIDs of everyone uuid has a pending friend request to, sorted.
+func (g *MySQLGraph) OutgoingRequests(ctx context.Context, uuid string) ([]string, error) {
+	rows, err := g.DB.QueryContext(ctx, "SELECT recipientId FROM friendRequests WHERE senderId=? AND state=? ORDER BY recipientId", uuid, RequestPending)
+	if err != nil {
+		return nil, err
+	}
+	return scanUUIDs(rows)
+}
+
+// Suggestions returns up to limit friends of uuid's friends, skipping the first offset.
+func (g *MySQLGraph) Suggestions(ctx context.Context, uuid string, offset, limit int) ([]Suggestion, error) {
+	rows, err := g.DB.QueryContext(ctx, "SELECT f2.friendId, COUNT(*) AS mutuals FROM friendships f1 "+
+		"JOIN friendships f2 ON f2.userId=f1.friendId "+
+		"WHERE f1.userId=? AND f2.friendId<>? "+
+		"AND f2.friendId NOT IN (SELECT friendId FROM friendships WHERE userId=?) "+
+		"AND f2.friendId NOT IN (SELECT recipientId FROM friendRequests WHERE senderId=? AND state=?) "+
+		"AND f2.friendId NOT IN (SELECT senderId FROM friendRequests WHERE recipientId=? AND state=?) "+
+		"AND f2.friendId NOT IN (SELECT blockedId FROM blocks WHERE blockerId=?) "+
+		"AND f2.friendId NOT IN (SELECT blockerId FROM blocks WHERE blockedId=?) "+
+		"GROUP BY f2.friendId ORDER BY mutuals DESC, f2.friendId LIMIT ? OFFSET ?",
+		uuid, uuid, uuid, uuid, RequestPending, uuid, RequestPending, uuid, uuid, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []Suggestion{}
+	for rows.Next() {
+		var suggestion Suggestion
+		err := rows.Scan(&suggestion.UUID, &suggestion.MutualFriends)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, rows.Err()
+}
+
+// Follow makes follower follow followee. The checks and the insert run in one transaction.
+func (g *MySQLGraph) Follow(ctx context.Context, follower, followee string) error {
+	if follower == followee {
+		return ErrSelfFollow
+	}
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = usersExist(ctx, tx, follower, followee)
+	if err != nil {
+		return err
+	}
+	var blocked bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM blocks WHERE "+
+		"(blockerId=? AND blockedId=?) OR (blockerId=? AND blockedId=?))", follower, followee, followee, follower).Scan(&blocked)
+	if err != nil {
+		return err
+	}
+	if blocked {
+		return ErrBlocked
+	}
+	_, err = tx.ExecContext(ctx, "INSERT IGNORE INTO follows (followerId, followeeId) VALUES (?, ?)", follower, followee)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Unfollow makes follower stop following followee.
+func (g *MySQLGraph) Unfollow(ctx context.Context, follower, followee string) error {
+	_, err := g.DB.ExecContext(ctx, "DELETE FROM follows WHERE followerId=? AND followeeId=?", follower, followee)
+	return err
+}
+
+// Followers returns a page of the users following uuid, sorted.
+func (g *MySQLGraph) Followers(ctx context.Context, uuid string, offset, limit int) ([]string, error) {
+	rows, err := g.DB.QueryContext(ctx, "SELECT followerId FROM follows WHERE followeeId=? ORDER BY followerId LIMIT ? OFFSET ?", uuid, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanUUIDs(rows)
+}
+
+// Following returns a page of the users uuid follows, sorted.
+func (g *MySQLGraph) Following(ctx context.Context, uuid string, offset, limit int) ([]string, error) {
+	rows, err := g.DB.QueryContext(ctx, "SELECT followeeId FROM follows WHERE followerId=? ORDER BY followeeId LIMIT ? OFFSET ?", uuid, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	return scanUUIDs(rows)
+}
+
+// FollowCounts returns how many users follow uuid and how many uuid follows.
+func (g *MySQLGraph) FollowCounts(ctx context.Context, uuid string) (FollowCounts, error) {
+	counts := FollowCounts{}
+	err := g.DB.QueryRowContext(ctx, "SELECT (SELECT COUNT(*) FROM follows WHERE followeeId=?), (SELECT COUNT(*) FROM follows WHERE followerId=?)",
+		uuid, uuid).Scan(&counts.Followers, &counts.Following)
+	return counts, err
+}
+
+// Block makes blocker block blocked. The block, the end of their friendship and the end of any pending
+// requests between them happen in one transaction.
+func (g *MySQLGraph) Block(ctx context.Context, blocker, blocked string) error {
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = usersExist(ctx, tx, blocker, blocked)
+	if err != nil {
+		return err
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT IGNORE INTO blocks (blockerId, blockedId) VALUES (?, ?)", []interface{}{blocker, blocked}},
+		{"UPDATE friendRequests SET state=? WHERE senderId=? AND recipientId=? AND state=?", []interface{}{RequestCancelled, blocker, blocked, RequestPending}},
+		{"UPDATE friendRequests SET state=? WHERE senderId=? AND recipientId=? AND state=?", []interface{}{RequestDeclined, blocked, blocker, RequestPending}},
+		{"DELETE FROM follows WHERE (followerId=? AND followeeId=?) OR (followerId=? AND followeeId=?)", []interface{}{blocker, blocked, blocked, blocker}},
+	}
+	for _, statement := range statements {
+		_, err = tx.ExecContext(ctx, statement.query, statement.args...)
+		if err != nil {
+			return err
+		}
+	}
+	err = removeFriendship(ctx, tx, blocker, blocked)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Unblock lifts blocker's block on blocked.
+func (g *MySQLGraph) Unblock(ctx context.Context, blocker, blocked string) error {
+	_, err := g.DB.ExecContext(ctx, "DELETE FROM blocks WHERE blockerId=? AND blockedId=?", blocker, blocked)
+	return err
+}
+
+// Blockers returns the UUIDs of everyone who has blocked uuid, sorted.
+func (g *MySQLGraph) Blockers(ctx context.Context, uuid string) ([]string, error) {
+	rows, err := g.DB.QueryContext(ctx, "SELECT blockerId FROM blocks WHERE blockedId=? ORDER BY blockerId", uuid)
+	if err != nil {
+		return nil, err
+	}
+	return scanUUIDs(rows)
+}
+
+// CreateList creates an empty friend list for owner.
+func (g *MySQLGraph) CreateList(ctx context.Context, owner, name string) error {
+	var exists bool
+	err := g.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM users WHERE uuid=?)", owner).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUserNotFound
+	}
+	_, err = g.DB.ExecContext(ctx, "INSERT IGNORE INTO friendLists (ownerId, name) VALUES (?, ?)", owner, name)
+	return err
+}
+
+// DeleteList deletes one of owner's friend lists along with its members, in one transaction.
+func (g *MySQLGraph) DeleteList(ctx context.Context, owner, name string) error {
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM friendLists WHERE ownerId=? AND name=?", owner, name)
+	if err != nil {
+		return err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrListNotFound
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM friendListMembers WHERE ownerId=? AND name=?", owner, name)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Lists returns all of owner's friend lists, ordered by name.
+func (g *MySQLGraph) Lists(ctx context.Context, owner string) ([]FriendList, error) {
+	return g.lists(ctx, "SELECT l.name, m.memberId FROM friendLists l "+
+		"LEFT JOIN friendListMembers m ON m.ownerId=l.ownerId AND m.name=l.name "+
+		"WHERE l.ownerId=? ORDER BY l.name, m.memberId", owner)
+}
+
+// List returns one of owner's friend lists.
+func (g *MySQLGraph) List(ctx context.Context, owner, name string) (FriendList, error) {
+	lists, err := g.lists(ctx, "SELECT l.name, m.memberId FROM friendLists l "+
+		"LEFT JOIN friendListMembers m ON m.ownerId=l.ownerId AND m.name=l.name "+
+		"WHERE l.ownerId=? AND l.name=? ORDER BY m.memberId", owner, name)
+	if err != nil {
+		return FriendList{}, err
+	}
+	if len(lists) == 0 {
+		return FriendList{}, ErrListNotFound
+	}
+	return lists[0], nil
+}
+
+// lists runs a query for (list name, member) rows, ordered by list name, and groups them into lists.
+// Empty lists have a single row with a NULL member.
+func (g *MySQLGraph) lists(ctx context.Context, query string, args ...interface{}) ([]FriendList, error) {
+	rows, err := g.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []FriendList{}
+	for rows.Next() {
+		var name string
+		var member sql.NullString
+		err = rows.Scan(&name, &member)
+		if err != nil {
+			return nil, err
+		}
+		if len(lists) == 0 || lists[len(lists)-1].Name != name {
+			lists = append(lists, FriendList{Name: name, Members: []string{}})
+		}
+		if member.Valid {
+			lists[len(lists)-1].Members = append(lists[len(lists)-1].Members, member.String)
+		}
+	}
+	return lists, rows.Err()
+}
+
+// AddToList puts one of owner's friends into one of their lists. The checks and the insert run in one
+// transaction.
+func (g *MySQLGraph) AddToList(ctx context.Context, owner, name, member string) error {
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = listExists(ctx, tx, owner, name)
+	if err != nil {
+		return err
+	}
+	var friends bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM friendships WHERE userId=? AND friendId=?)", owner, member).Scan(&friends)
+	if err != nil {
+		return err
+	}
+	if !friends {
+		return ErrNotFriends
+	}
+	_, err = tx.ExecContext(ctx, "INSERT IGNORE INTO friendListMembers (ownerId, name, memberId) VALUES (?, ?, ?)", owner, name, member)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveFromList takes member out of one of owner's friend lists.
+func (g *MySQLGraph) RemoveFromList(ctx context.Context, owner, name, member string) error {
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = listExists(ctx, tx, owner, name)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM friendListMembers WHERE ownerId=? AND name=? AND memberId=?", owner, name, member)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// listExists returns ErrListNotFound unless owner has a friend list with the given name.
+func listExists(ctx context.Context, tx *sql.Tx, owner, name string) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM friendLists WHERE ownerId=? AND name=?)", owner, name).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrListNotFound
+	}
+	return nil
+}
+
+// InList reports whether owner has put member in the list with the given name.
+func (g *MySQLGraph) InList(ctx context.Context, owner, name, member string) (bool, error) {
+	var exists bool
+	err := g.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT * FROM friendListMembers WHERE ownerId=? AND name=? AND memberId=?)",
+		owner, name, member).Scan(&exists)
+	return exists, err
+}
+
+// scanUUIDs reads a single column of UUIDs from rows and closes them.
+func scanUUIDs(rows *sql.Rows) ([]string, error) {
+	defer rows.Close()
+	uuids := []string{}
+	for rows.Next() {
+		var uuid string
+		err := rows.Scan(&uuid)
+		if err != nil {
+			return nil, err
+		}
+		uuids = append(uuids, uuid)
+	}
+	return uuids, rows.Err()
+}
+
+// Users returns the UUIDs of every user in the graph, sorted.
+func (g *MySQLGraph) Users(ctx context.Context) ([]string, error) {
+	rows, err := g.DB.QueryContext(ctx, "SELECT uuid FROM users ORDER BY uuid")
+	if err != nil {
+		return nil, err
+	}
+	return scanUUIDs(rows)
+}
+
+// Friendships returns every friendship in the graph once, reading only the row of each pair whose
+// userId is the lesser UUID.
+func (g *MySQLGraph) Friendships(ctx context.Context) ([]FriendshipEdge, error) {
+	rows, err := g.DB.QueryContext(ctx, "SELECT userId, friendId, createdAt FROM friendships WHERE userId < friendId ORDER BY userId, friendId")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	friendships := []FriendshipEdge{}
+	for rows.Next() {
+		edge := FriendshipEdge{}
+		err = rows.Scan(&edge.A, &edge.B, &edge.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		friendships = append(friendships, edge)
+	}
+	return friendships, rows.Err()
+}
+
+// RestoreFriendship makes edge.A and edge.B friends as of edge.CreatedAt. Both directions are inserted
+// in one transaction.
+func (g *MySQLGraph) RestoreFriendship(ctx context.Context, edge FriendshipEdge) error {
+	tx, err := g.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = addFriendship(ctx, tx, edge.A, edge.B, edge.CreatedAt.UTC().Truncate(time.Millisecond))
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
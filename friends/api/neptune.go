@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// DefaultNeptuneURL is the Gremlin HTTP endpoint of the Neptune cluster the friends graph lives in.
+const DefaultNeptuneURL = "https://<your_neptune_writer_endpoint>:8182/gremlin"
+
+// NeptuneURL returns the NEPTUNE_URL environment variable, or DefaultNeptuneURL if it isn't set. Set it
+// to a wss:// URL, such as wss://<your_neptune_writer_endpoint>:8182/gremlin, to use the WebSocket
+// endpoint instead.
+func NeptuneURL() string {
+	if url := os.Getenv("NEPTUNE_URL"); url != "" {
+		return url
+	}
+	return DefaultNeptuneURL
+}
+
+// neptuneStats counts the queries sent by every NeptuneClient. They are served with the rest of the
+// expvar variables at /internal/debug/vars.
+var neptuneStats = expvar.NewMap("neptune")
+
+// NeptuneClient sends Gremlin queries to Neptune's HTTP endpoint over a pool of keep-alive
+// connections. Queries that fail because of throttling or conflicting concurrent writes, which Neptune
+// asks clients to retry, are retried with jittered exponential backoff.
+type NeptuneClient struct {
+	URL  string
+	HTTP *http.Client
+	// MaxAttempts is how many times a query is sent before its error is returned.
+	MaxAttempts int
+	// BaseBackoff is the longest wait before the first retry. Each retry after that can wait twice as
+	// long, up to MaxBackoff.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// Stats counts requests, errors, retries and the total time spent on queries.
+	Stats *expvar.Map
+}
+
+// NewNeptuneClient creates a NeptuneClient for the Gremlin HTTP endpoint at url.
+func NewNeptuneClient(url string) *NeptuneClient {
+	return &NeptuneClient{
+		URL: url,
+		HTTP: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				DialContext:         (&net.Dialer{Timeout: 3 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+				MaxIdleConns:        64,
+				MaxIdleConnsPerHost: 64,
+				IdleConnTimeout:     90 * time.Second,
+				TLSHandshakeTimeout: 3 * time.Second,
+			},
+		},
+		MaxAttempts: 4,
+		BaseBackoff: 50 * time.Millisecond,
+		MaxBackoff:  time.Second,
+		Stats:       neptuneStats,
+	}
+}
+
+// Query runs a Gremlin query with the given bindings and returns the decoded response. Errors reported
+// by the Gremlin server are returned as a *GremlinError. Cancelling ctx cancels the query, including
+// any retries still to come.
+func (c *NeptuneClient) Query(ctx context.Context, gremlinQuery string, params bindings) (*gremlinResponse, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"gremlin":  gremlinQuery,
+		"bindings": params,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	defer func() {
+		c.Stats.AddFloat("latencySeconds", time.Since(start).Seconds())
+	}()
+	c.Stats.Add("requests", 1)
+
+	for attempt := 1; ; attempt++ {
+		response, err := c.send(ctx, body)
+		if err == nil {
+			return response, nil
+		}
+		if attempt >= c.MaxAttempts || !retryable(err) {
+			c.Stats.Add("errors", 1)
+			return nil, err
+		}
+
+		c.Stats.Add("retries", 1)
+		select {
+		case <-time.After(c.backoff(attempt)):
+		case <-ctx.Done():
+			c.Stats.Add("errors", 1)
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// send makes a single attempt at a query.
+func (c *NeptuneClient) send(ctx context.Context, body []byte) (*gremlinResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeGremlinResponse(resp.StatusCode, respBody)
+}
+
+// backoff returns how long to wait before retrying after the given attempt: a random duration up to
+// BaseBackoff doubled for every earlier attempt, capped at MaxBackoff.
+func (c *NeptuneClient) backoff(attempt int) time.Duration {
+	ceiling := c.BaseBackoff << uint(attempt-1)
+	if ceiling > c.MaxBackoff || ceiling <= 0 {
+		ceiling = c.MaxBackoff
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// retryable reports whether a query that failed with err can safely be sent again. That is the case
+// when Neptune asked for a retry, or when the connection couldn't even be made, so the query never
+// reached it.
+func retryable(err error) bool {
+	var gremlinErr *GremlinError
+	if errors.As(err, &gremlinErr) {
+		return gremlinErr.HTTPStatus() == http.StatusServiceUnavailable
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
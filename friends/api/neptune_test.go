@@ -0,0 +1,126 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Creates a NeptuneClient for url that retries quickly and keeps its own stats.
+func newTestNeptuneClient(url string) *NeptuneClient {
+	client := NewNeptuneClient(url)
+	client.BaseBackoff = time.Millisecond
+	client.MaxBackoff = 5 * time.Millisecond
+	client.Stats = new(expvar.Map).Init()
+	return client
+}
+
+// Returns the value of the integer stat key, or 0 if it was never set.
+func stat(client *NeptuneClient, key string) int64 {
+	value, ok := client.Stats.Get(key).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return value.Value()
+}
+
+func TestNeptuneRetries(t *testing.T) {
+	concurrent := readFixture(t, "neptune_concurrent.json")
+	scriptError := readFixture(t, "script_error.json")
+	count := readFixture(t, "v3_count.json")
+
+	tests := []struct {
+		name string
+		// failures is how many times the server fails before it answers, and failure is how.
+		failures   int
+		failure    []byte
+		failStatus int
+		attempts   int32
+		wantErr    bool
+	}{
+		{"Succeeds After Conflicts", 2, concurrent, http.StatusInternalServerError, 3, false},
+		{"Gives Up After Max Attempts", 10, concurrent, http.StatusInternalServerError, 4, true},
+		{"Does Not Retry Script Errors", 1, scriptError, http.StatusOK, 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if int(atomic.AddInt32(&attempts, 1)) <= test.failures {
+					w.WriteHeader(test.failStatus)
+					w.Write(test.failure)
+					return
+				}
+				w.Write(count)
+			}))
+			defer server.Close()
+
+			client := newTestNeptuneClient(server.URL)
+			response, err := client.Query(context.Background(), "g.V().count()", nil)
+			if test.wantErr {
+				var gremlinErr *GremlinError
+				assert.True(t, errors.As(err, &gremlinErr), "expected a GremlinError, got %v", err)
+				assert.EqualValues(t, 1, stat(client, "errors"))
+			} else {
+				require.NoError(t, err)
+				n, err := response.count()
+				require.NoError(t, err)
+				assert.EqualValues(t, 2, n)
+				assert.EqualValues(t, 0, stat(client, "errors"))
+			}
+			assert.Equal(t, test.attempts, atomic.LoadInt32(&attempts))
+			assert.EqualValues(t, 1, stat(client, "requests"))
+			assert.EqualValues(t, test.attempts-1, stat(client, "retries"))
+		})
+	}
+}
+
+// Checks that queries that can't connect are retried, since they never reached Neptune.
+func TestNeptuneRetriesDialErrors(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	client := newTestNeptuneClient(url)
+	_, err := client.Query(context.Background(), "g.V().count()", nil)
+	assert.Error(t, err)
+	assert.EqualValues(t, client.MaxAttempts-1, stat(client, "retries"))
+}
+
+// Checks that cancelling the context cancels a query that is waiting on Neptune.
+func TestNeptuneCancellation(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := newTestNeptuneClient(server.URL).Query(ctx, "g.V().count()", nil)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "expected the deadline to be exceeded, got %v", err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "the query kept going after its context was done")
+}
+
+func TestNeptuneURL(t *testing.T) {
+	defer os.Setenv("NEPTUNE_URL", os.Getenv("NEPTUNE_URL"))
+
+	os.Setenv("NEPTUNE_URL", "")
+	assert.Equal(t, DefaultNeptuneURL, NeptuneURL())
+	os.Setenv("NEPTUNE_URL", "http://localhost:8182/gremlin")
+	assert.Equal(t, "http://localhost:8182/gremlin", NeptuneURL())
+}
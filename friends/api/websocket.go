@@ -0,0 +1,296 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// graphSONMimeType is the serialization WebSocketClient asks the Gremlin server to use.
+const graphSONMimeType = "application/vnd.gremlin-v3.0+json"
+
+// defaultWebSocketTimeout bounds queries whose context has no deadline of its own.
+const defaultWebSocketTimeout = 10 * time.Second
+
+// WebSocketClient sends Gremlin queries over the Gremlin Server WebSocket protocol, which Neptune and
+// TinkerPop's Gremlin Server both speak. It keeps a pool of open connections, so queries don't pay for a
+// new connection each time, and it can run queries in sessions whose writes commit together.
+type WebSocketClient struct {
+	URL    string
+	Dialer *websocket.Dialer
+	// Stats counts requests, errors and the total time spent on queries.
+	Stats *expvar.Map
+
+	// idle holds open connections that aren't in use, and slots holds a token for every connection
+	// that is open, so there are never more than the pool size.
+	idle  chan *websocket.Conn
+	slots chan struct{}
+}
+
+// NewWebSocketClient creates a WebSocketClient for the Gremlin WebSocket endpoint at url, such as
+// wss://your-neptune-endpoint:8182/gremlin, keeping up to poolSize connections open.
+func NewWebSocketClient(url string, poolSize int) *WebSocketClient {
+	return &WebSocketClient{
+		URL: url,
+		Dialer: &websocket.Dialer{
+			Proxy:            http.ProxyFromEnvironment,
+			HandshakeTimeout: 3 * time.Second,
+		},
+		Stats: neptuneStats,
+		idle:  make(chan *websocket.Conn, poolSize),
+		slots: make(chan struct{}, poolSize),
+	}
+}
+
+// wsRequest is a request message in the Gremlin Server WebSocket protocol.
+type wsRequest struct {
+	RequestID string                 `json:"requestId"`
+	Op        string                 `json:"op"`
+	Processor string                 `json:"processor"`
+	Args      map[string]interface{} `json:"args"`
+}
+
+// Query runs a Gremlin query with the given bindings outside of any session, so it commits on its own.
+func (c *WebSocketClient) Query(ctx context.Context, gremlinQuery string, params bindings) (*gremlinResponse, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.roundTrip(ctx, conn, evalRequest(gremlinQuery, params, ""))
+	c.put(conn, err)
+	return response, err
+}
+
+// Session starts a session on a connection of its own. Its queries see each other's writes, and
+// commit or roll back together when the session ends.
+func (c *WebSocketClient) Session(ctx context.Context) (GremlinSession, error) {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &webSocketSession{client: c, conn: conn, id: uuid.New().String()}, nil
+}
+
+// Close closes every idle connection in the pool.
+func (c *WebSocketClient) Close() {
+	for {
+		select {
+		case conn := <-c.idle:
+			conn.Close()
+			<-c.slots
+		default:
+			return
+		}
+	}
+}
+
+// evalRequest builds a request that evaluates gremlinQuery, in the given session if there is one.
+func evalRequest(gremlinQuery string, params bindings, session string) wsRequest {
+	req := wsRequest{
+		RequestID: uuid.New().String(),
+		Op:        "eval",
+		Args: map[string]interface{}{
+			"gremlin":  gremlinQuery,
+			"bindings": params,
+			"language": "gremlin-groovy",
+		},
+	}
+	if session != "" {
+		req.Processor = "session"
+		req.Args["session"] = session
+	}
+	return req
+}
+
+// get takes an idle connection from the pool, or opens a new one if the pool isn't full yet. If it is
+// full, get waits for a connection to be put back.
+func (c *WebSocketClient) get(ctx context.Context) (*websocket.Conn, error) {
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	default:
+	}
+
+	select {
+	case conn := <-c.idle:
+		return conn, nil
+	case c.slots <- struct{}{}:
+		conn, _, err := c.Dialer.DialContext(ctx, c.URL, nil)
+		if err != nil {
+			<-c.slots
+			return nil, err
+		}
+		return conn, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// put returns a connection to the pool after a request that ended with err. Connections that may be in
+// a bad state, because the request failed for any reason but a Gremlin error, are closed instead.
+func (c *WebSocketClient) put(conn *websocket.Conn, err error) {
+	var gremlinErr *GremlinError
+	if err != nil && !errors.As(err, &gremlinErr) {
+		c.discard(conn)
+		return
+	}
+	c.idle <- conn
+}
+
+// discard closes a connection and frees its place in the pool.
+func (c *WebSocketClient) discard(conn *websocket.Conn) {
+	conn.Close()
+	<-c.slots
+}
+
+// roundTrip sends req over conn and reads responses to it until the server says it is done. Results
+// streamed over several partial responses are put back together into one response.
+func (c *WebSocketClient) roundTrip(ctx context.Context, conn *websocket.Conn, req wsRequest) (response *gremlinResponse, err error) {
+	start := time.Now()
+	c.Stats.Add("requests", 1)
+	defer func() {
+		c.Stats.AddFloat("latencySeconds", time.Since(start).Seconds())
+		if err != nil {
+			c.Stats.Add("errors", 1)
+		}
+	}()
+
+	// Queries without a deadline of their own get the default timeout. Otherwise reads have no deadline,
+	// and are unblocked as soon as ctx is done instead, so the error says why they stopped.
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultWebSocketTimeout)
+		conn.SetReadDeadline(deadline)
+	} else {
+		conn.SetReadDeadline(time.Time{})
+	}
+	conn.SetWriteDeadline(deadline)
+
+	// Wait for the watcher to stop before returning, so it can't touch the connection once it is reused
+	done, stopped := make(chan struct{}), make(chan struct{})
+	defer func() {
+		close(done)
+		<-stopped
+	}()
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	// Binary messages start with the length of the mime type, then the mime type itself
+	message := append([]byte{byte(len(graphSONMimeType))}, graphSONMimeType...)
+	err = conn.WriteMessage(websocket.BinaryMessage, append(message, body...))
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	var chunks []json.RawMessage
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		response, err := decodeGremlinResponse(http.StatusOK, message)
+		if err != nil {
+			return nil, err
+		}
+		if response.RequestID != req.RequestID {
+			return nil, fmt.Errorf("got a response to request %s while waiting for %s", response.RequestID, req.RequestID)
+		}
+
+		chunks = append(chunks, response.Result.Data)
+		if response.Status.Code != gremlinPartialContent {
+			response.Result.Data, err = mergeGraphSONLists(chunks)
+			return response, err
+		}
+	}
+}
+
+// contextError returns ctx's error if it is done, since that is why err happened, or err otherwise.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// mergeGraphSONLists joins the results of a response that was streamed in several parts into one
+// GraphSON v3 list.
+func mergeGraphSONLists(chunks []json.RawMessage) (json.RawMessage, error) {
+	if len(chunks) == 1 {
+		return chunks[0], nil
+	}
+
+	values := []json.RawMessage{}
+	for _, chunk := range chunks {
+		if len(chunk) == 0 || string(chunk) == "null" {
+			continue
+		}
+		var list struct {
+			Value []json.RawMessage `json:"@value"`
+		}
+		// GraphSON v2 lists are plain arrays, while v3 wraps them in a g:List
+		err := json.Unmarshal(chunk, &list.Value)
+		if err != nil {
+			err = json.Unmarshal(chunk, &list)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("decoding partial gremlin result: %w", err)
+		}
+		values = append(values, list.Value...)
+	}
+	return json.Marshal(map[string]interface{}{"@type": "g:List", "@value": values})
+}
+
+// webSocketSession is a GremlinSession running on a WebSocketClient connection. The connection is
+// closed when the session ends, which makes sure the server ends the session too.
+type webSocketSession struct {
+	client *WebSocketClient
+	conn   *websocket.Conn
+	id     string
+	closed bool
+}
+
+// Query runs a Gremlin query in the session.
+func (s *webSocketSession) Query(ctx context.Context, gremlinQuery string, params bindings) (*gremlinResponse, error) {
+	if s.closed {
+		return nil, errors.New("gremlin session is closed")
+	}
+	return s.client.roundTrip(ctx, s.conn, evalRequest(gremlinQuery, params, s.id))
+}
+
+// Commit commits the session's writes and ends the session.
+func (s *webSocketSession) Commit(ctx context.Context) error {
+	return s.end(ctx, "g.tx().commit()")
+}
+
+// Rollback throws away the session's writes and ends the session.
+func (s *webSocketSession) Rollback(ctx context.Context) error {
+	return s.end(ctx, "g.tx().rollback()")
+}
+
+// end runs gremlinQuery to finish the session's transaction, then closes its connection.
+func (s *webSocketSession) end(ctx context.Context, gremlinQuery string) error {
+	_, err := s.Query(ctx, gremlinQuery, nil)
+	if !s.closed {
+		s.closed = true
+		s.client.discard(s.conn)
+	}
+	return err
+}
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGremlinServer stands in for a TinkerGraph behind Gremlin Server's WebSocket endpoint. It only
+// understands the queries GremlinGraph uses for friendships, friend requests and blocks, which is
+// enough to check that sessions commit and roll back their writes together. Writes made in a session
+// are kept aside until the session is committed.
+type fakeGremlinServer struct {
+	mu    sync.Mutex
+	edges map[[2]string]bool
+	// requests holds the state of the friend request from the first user to the second.
+	requests    map[[2]string]string
+	blocks      map[[2]string]bool
+	sessions    map[string][]func()
+	connections int32
+	// failTo makes inserting any friendship edge to this user fail.
+	failTo string
+	// fail makes every query containing it fail.
+	fail string
+	// hang makes the server never answer queries containing it.
+	hang string
+}
+
+func newFakeGremlinServer(t *testing.T) string {
+	fake := &fakeGremlinServer{
+		edges:    map[[2]string]bool{},
+		requests: map[[2]string]string{},
+		blocks:   map[[2]string]bool{},
+		sessions: map[string][]func(){},
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	fakeServers.Store(server.URL, fake)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// fakeServers finds the fake behind each test server, by its http:// URL.
+var fakeServers sync.Map
+
+// Returns the fake serving the ws:// url.
+func fakeServerAt(url string) *fakeGremlinServer {
+	fake, _ := fakeServers.Load("http" + strings.TrimPrefix(url, "ws"))
+	return fake.(*fakeGremlinServer)
+}
+
+func (f *fakeGremlinServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	atomic.AddInt32(&f.connections, 1)
+
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		// Skip the mime type the message starts with
+		req := wsRequest{}
+		if len(message) == 0 || json.Unmarshal(message[1+int(message[0]):], &req) != nil {
+			return
+		}
+		for _, response := range f.eval(req) {
+			if conn.WriteMessage(websocket.TextMessage, []byte(response)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// eval runs req and returns the response messages to send back.
+func (f *fakeGremlinServer) eval(req wsRequest) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	gq, _ := req.Args["gremlin"].(string)
+	params, _ := req.Args["bindings"].(map[string]interface{})
+	session, _ := req.Args["session"].(string)
+	respond := func(code int, data string) string {
+		return fmt.Sprintf(`{"requestId":%q,"status":{"message":"","code":%d,"attributes":{}},"result":{"data":%s,"meta":{}}}`, req.RequestID, code, data)
+	}
+	count := func(n int) []string {
+		return []string{respond(200, fmt.Sprintf(`{"@type":"g:List","@value":[{"@type":"g:Int64","@value":%d}]}`, n))}
+	}
+	failed := func(message string) []string {
+		return []string{fmt.Sprintf(`{"requestId":%q,"status":{"message":%q,"code":500,"attributes":{}},"result":{"data":null,"meta":{}}}`, req.RequestID, message)}
+	}
+	// Applies a write now, or when the session commits
+	write := func(apply func()) {
+		if session != "" {
+			f.sessions[session] = append(f.sessions[session], apply)
+		} else {
+			apply()
+		}
+	}
+	pair := func(a, b string) [2]string {
+		first, _ := params[a].(string)
+		second, _ := params[b].(string)
+		return [2]string{first, second}
+	}
+
+	switch {
+	case f.hang != "" && strings.Contains(gq, f.hang):
+		return nil
+	case f.fail != "" && strings.Contains(gq, f.fail):
+		return failed("query failed")
+	case gq == "g.tx().commit()":
+		for _, apply := range f.sessions[session] {
+			apply()
+		}
+		delete(f.sessions, session)
+	case gq == "g.tx().rollback()":
+		delete(f.sessions, session)
+	case strings.Contains(gq, "within("):
+		return count(2)
+	case strings.Contains(gq, "addE('friends with')"):
+		edge := pair("fromUUID", "toUUID")
+		if edge[1] == f.failTo {
+			return failed("edge insert failed")
+		}
+		write(func() { f.edges[edge] = true })
+	case strings.Contains(gq, "outE('friend request').has('state', pending)"):
+		request := pair("fromUUID", "toUUID")
+		if f.requests[request] != string(RequestPending) {
+			return count(0)
+		}
+		if strings.Contains(gq, "property('state', state)") {
+			state := params["state"].(string)
+			write(func() { f.requests[request] = state })
+		}
+		return count(1)
+	case strings.Contains(gq, "addE('blocks')"):
+		block := pair("blockerUUID", "blockedUUID")
+		write(func() { f.blocks[block] = true })
+	case strings.Contains(gq, "bothE('friends with')") && strings.Contains(gq, "drop()"):
+		edge := pair("uuid", "otherUUID")
+		write(func() {
+			delete(f.edges, edge)
+			delete(f.edges, [2]string{edge[1], edge[0]})
+		})
+	case strings.Contains(gq, "out('friends with').values('uuid')"):
+		friends := []string{}
+		for edge := range f.edges {
+			if edge[0] == params["uuid"] {
+				friends = append(friends, edge[1])
+			}
+		}
+		sort.Strings(friends)
+		if len(friends) == 0 {
+			return []string{respond(204, "null")}
+		}
+		// Stream the results one at a time, as Gremlin Server does for large results
+		responses := []string{}
+		for i, friend := range friends {
+			code := gremlinPartialContent
+			if i == len(friends)-1 {
+				code = gremlinSuccess
+			}
+			responses = append(responses, respond(code, fmt.Sprintf(`{"@type":"g:List","@value":[%q]}`, friend)))
+		}
+		return responses
+	}
+	return []string{respond(200, `{"@type":"g:List","@value":[]}`)}
+}
+
+// Returns the committed state of the friend request from one user to another.
+func (f *fakeGremlinServer) request(from, to string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.requests[[2]string{from, to}]
+}
+
+// Returns whether blocker has a committed block on blocked.
+func (f *fakeGremlinServer) blocked(blocker, blocked string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.blocks[[2]string{blocker, blocked}]
+}
+
+// Changes one of the fake's settings while it may be serving queries.
+func (f *fakeGremlinServer) set(change func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	change()
+}
+
+// Returns a copy of the fake's committed edges.
+func (f *fakeGremlinServer) committed() map[[2]string]bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	edges := map[[2]string]bool{}
+	for edge := range f.edges {
+		edges[edge] = true
+	}
+	return edges
+}
+
+func TestWebSocketFriendships(t *testing.T) {
+	url := newFakeGremlinServer(t)
+	graph := NewGremlinGraph(url)
+	require.IsType(t, &WebSocketClient{}, graph.Transport)
+	ctx := context.Background()
+
+	require.NoError(t, graph.AddFriendship(ctx, "oski", "bear"))
+	require.NoError(t, graph.AddFriendship(ctx, "oski", "tree"))
+
+	friends, err := graph.Friends(ctx, "oski")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"bear", "tree"}, friends, "results streamed in parts should be put back together")
+	friends, err = graph.Friends(ctx, "bear")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"oski"}, friends)
+	friends, err = graph.Friends(ctx, "nobody")
+	require.NoError(t, err)
+	assert.Empty(t, friends)
+
+	// Every session's connection is closed when it ends, and the rest share one pooled connection
+	assert.Equal(t, int32(3), atomic.LoadInt32(&fakeServerAt(url).connections))
+}
+
+func TestWebSocketFriendshipRollback(t *testing.T) {
+	url := newFakeGremlinServer(t)
+	fake := fakeServerAt(url)
+	graph := NewGremlinGraph(url)
+
+	// The edge from oski to bear is inserted, but the one back fails, so neither should be kept
+	fake.failTo = "oski"
+	err := graph.AddFriendship(context.Background(), "oski", "bear")
+	require.Error(t, err)
+	assert.IsType(t, &GremlinError{}, err)
+	assert.Empty(t, fake.committed())
+
+	fake.mu.Lock()
+	fake.failTo = ""
+	fake.mu.Unlock()
+	require.NoError(t, graph.AddFriendship(context.Background(), "oski", "bear"))
+	assert.Equal(t, map[[2]string]bool{{"oski", "bear"}: true, {"bear", "oski"}: true}, fake.committed())
+}
+
+func TestWebSocketAcceptRollback(t *testing.T) {
+	url := newFakeGremlinServer(t)
+	fake := fakeServerAt(url)
+	graph := NewGremlinGraph(url)
+	ctx := context.Background()
+	fake.requests[[2]string{"oski", "bear"}] = string(RequestPending)
+
+	// Adding the friendship fails, so the request must still be pending for the retry
+	fake.set(func() { fake.failTo = "oski" })
+	err := graph.UpdateRequest(ctx, "oski", "bear", RequestAccepted)
+	require.Error(t, err)
+	assert.Equal(t, string(RequestPending), fake.request("oski", "bear"))
+	assert.Empty(t, fake.committed())
+
+	fake.set(func() { fake.failTo = "" })
+	require.NoError(t, graph.UpdateRequest(ctx, "oski", "bear", RequestAccepted))
+	assert.Equal(t, string(RequestAccepted), fake.request("oski", "bear"))
+	assert.Equal(t, map[[2]string]bool{{"oski", "bear"}: true, {"bear", "oski"}: true}, fake.committed())
+
+	assert.Equal(t, ErrRequestNotFound, graph.UpdateRequest(ctx, "oski", "bear", RequestAccepted))
+}
+
+func TestWebSocketCancellation(t *testing.T) {
+	url := newFakeGremlinServer(t)
+	fake := fakeServerAt(url)
+	fake.hang = "hang"
+	client := NewWebSocketClient(url, 1)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Query(ctx, "g.inject('hang')", nil)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// The connection that was waiting for an answer is thrown away, freeing its place in the pool
+	_, err = client.Query(context.Background(), "g.V().count()", nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&fake.connections))
+}
+
+func TestWebSocketRequests(t *testing.T) {
+	var received wsRequest
+	var mimeType string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+		messageType, message, err := conn.ReadMessage()
+		require.NoError(t, err)
+		assert.Equal(t, websocket.BinaryMessage, messageType)
+		mimeType = string(message[1 : 1+int(message[0])])
+		require.NoError(t, json.NewDecoder(bytes.NewReader(message[1+int(message[0]):])).Decode(&received))
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"requestId":"`+received.RequestID+`","status":{"code":204},"result":{"data":null}}`))
+	}))
+	defer server.Close()
+
+	client := NewWebSocketClient("ws"+strings.TrimPrefix(server.URL, "http"), 1)
+	session, err := client.Session(context.Background())
+	require.NoError(t, err)
+	_, err = session.Query(context.Background(), "g.V().has('uuid', uuid)", bindings{"uuid": "oski"})
+	require.NoError(t, err)
+
+	assert.Equal(t, graphSONMimeType, mimeType)
+	assert.Equal(t, "eval", received.Op)
+	assert.Equal(t, "session", received.Processor)
+	assert.NotEmpty(t, received.Args["session"])
+	assert.Equal(t, "g.V().has('uuid', uuid)", received.Args["gremlin"])
+	assert.Equal(t, map[string]interface{}{"uuid": "oski"}, received.Args["bindings"])
+}
+
+func TestWebSocketBlockRollback(t *testing.T) {
+	url := newFakeGremlinServer(t)
+	fake := fakeServerAt(url)
+	graph := NewGremlinGraph(url)
+	ctx := context.Background()
+	require.NoError(t, graph.AddFriendship(ctx, "oski", "bear"))
+	fake.requests[[2]string{"bear", "oski"}] = string(RequestPending)
+
+	// Dropping the follows fails after the block and the unfriending, so none of it should stick
+	fake.set(func() { fake.fail = "bothE('follows')" })
+	require.Error(t, graph.Block(ctx, "oski", "bear"))
+	assert.False(t, fake.blocked("oski", "bear"))
+	assert.Len(t, fake.committed(), 2, "the friendship should still be there")
+	assert.Equal(t, string(RequestPending), fake.request("bear", "oski"))
+
+	fake.set(func() { fake.fail = "" })
+	require.NoError(t, graph.Block(ctx, "oski", "bear"))
+	assert.True(t, fake.blocked("oski", "bear"))
+	assert.Empty(t, fake.committed())
+	assert.Equal(t, string(RequestDeclined), fake.request("bear", "oski"))
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/BearCloud/fa20-project-dev/backend/friends/api"
+)
+
+// usage describes the friends binary's subcommands.
+const usage = `usage: friends [command] [-file path]
+
+With no command, friends runs the friends service. The commands work on the graph picked by
+FRIENDS_GRAPH directly, so the service doesn't need to be running:
+
+  export   write every user and friendship to -file, or to standard output
+  import   replay an export read from -file, or from standard input. Importing the same export
+           twice changes nothing.`
+
+// runCommand runs the export or import subcommand named by args[0].
+func runCommand(args []string) error {
+	flags := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	file := flags.String("file", "", "file to export to or import from")
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), usage) }
+	err := flags.Parse(args[1:])
+	if err == flag.ErrHelp {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments %v\n%s", flags.Args(), usage)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "export":
+		graph, err := api.NewFriendGraph()
+		if err != nil {
+			return err
+		}
+		if *file == "" {
+			return api.Export(ctx, graph, os.Stdout)
+		}
+		f, err := os.Create(*file)
+		if err != nil {
+			return err
+		}
+		err = api.Export(ctx, graph, f)
+		// A failed close can lose the end of the export, so it counts as a failed export
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	case "import":
+		graph, err := api.NewFriendGraph()
+		if err != nil {
+			return err
+		}
+		var r io.Reader = os.Stdin
+		if *file != "" {
+			f, err := os.Open(*file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		stats, err := api.Import(ctx, graph, r)
+		log.Printf("imported %d users and %d friendships", stats.Users, stats.Friendships)
+		return err
+	default:
+		return errors.New(usage)
+	}
+}
@@ -3,6 +3,12 @@ module github.com/BearCloud/fa20-project-dev/backend/friends
 go 1.15
 
 require (
-	github.com/dgrijalva/jwt-go v3.2.0+incompatible
+	github.com/BearCloud/sp21-bearchat/common v0.0.0-00010101000000-000000000000
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/google/uuid v1.2.0
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/stretchr/testify v1.7.0
 )
+
+replace github.com/BearCloud/sp21-bearchat/common => ../common
@@ -5,22 +5,56 @@ import (
 	_ "log"
 	"net/http"
 	_ "net/http"
+	"os"
 
 	"github.com/BearCloud/fa20-project-dev/backend/friends/api"
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/BearCloud/sp21-bearchat/common/profiles"
 	"github.com/gorilla/mux"
 )
 
 func main() {
 
+	// "friends export" and "friends import" work on the graph directly, without starting the server
+	if len(os.Args) > 1 {
+		err := runCommand(os.Args[1:])
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	// Initialize our connection to the auth database, used to check for revoked tokens
+	db := api.InitDB("auth")
+	defer db.Close()
+
+	// Set up the graph backend picked by FRIENDS_GRAPH
+	graph, err := api.NewFriendGraph()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	// Create a new mux for routing api calls
 	router := mux.NewRouter()
 	router.Use(CORS)
 	
-	err := api.RegisterRoutes(router)
+	// Check access tokens against the auth service's published keys and its sessions table
+	validator := auth.NewValidator(auth.NewKeyCache(auth.JWKSURL()), auth.SQLSessions{DB: db})
+
+	// Ask the profiles service for friends' profiles when a list of friends includes them
+	directory := profiles.NewClient(profiles.URL(), auth.InternalToken())
+
+	err = api.RegisterRoutes(router, auth.Middleware(validator), graph, directory)
 	if err != nil {
 		log.Fatal("Error registering API endpoints")
 	}
 
+	// Internal endpoints are only for other services, which authenticate with the shared internal token
+	api.RegisterInternalRoutes(router, auth.InternalMiddleware(auth.InternalToken()), graph)
+
+	// Admin endpoints back up and migrate the graph, and are only for the users listed in FRIENDS_ADMINS
+	api.RegisterAdminRoutes(router, auth.Middleware(validator), auth.Admins("FRIENDS_ADMINS"), graph)
+
 	http.ListenAndServe(":80", router)
 }
 
@@ -30,7 +64,7 @@ func CORS(next http.Handler) http.Handler {
 		// Set headers
 		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
 		w.Header().Set("Access-Control-Allow-Origin", "<YOUR EC2 IP HERE>:3000")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
 
 		if r.Method == "OPTIONS" {
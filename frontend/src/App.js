@@ -8,6 +8,8 @@ import Signup from './pages/Signup';
 import Signin from './pages/Signin';
 import LogOut from './pages/LogOut';
 import Profile from './pages/Profile';
+import Verified from './pages/Verified';
+import ResetPassword from './pages/ResetPassword';
 
 function App() {
   return (
@@ -18,6 +20,8 @@ function App() {
         <Route exact path='/signin' component={Signin}></Route>
         <Route exact path='/logout' component={LogOut}></Route>
         <Route path='/profile/:uuid?' component={Profile}></Route>
+        <Route exact path='/verified' component={Verified}></Route>
+        <Route exact path='/reset' component={ResetPassword}></Route>
       </Switch>
     </Layout>
   );
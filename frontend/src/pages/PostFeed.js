@@ -3,6 +3,21 @@ import { Button, Form, Card } from 'react-bootstrap';
 import { request, HOST } from '../common/utils.js';
 import swal from 'sweetalert';
 
+// Fetches every page of the caller's friends with their profiles, following nextCursor until the
+// last page, and adds them to friends.
+function getAllFriends(friends, cursor) {
+  const qs = { include: 'profiles', limit: 100 };
+  if (cursor) {
+    qs.cursor = cursor;
+  }
+  return request('GET', `http://${HOST}:83/api/friends`, qs)
+    .then((res) => {
+      const page = JSON.parse(res.responseText);
+      const all = friends.concat(page.friends);
+      return page.nextCursor ? getAllFriends(all, page.nextCursor) : all;
+    });
+}
+
 function PostFeed(props) {
 
   const [posts, setPosts] = useState(null);
@@ -23,7 +38,7 @@ function PostFeed(props) {
 
   const send = (e) => {
     e.preventDefault();
-    request('POST', `http://${HOST}:81/api/posts/create`, {}, JSON.stringify({ content }))
+    request('POST', `http://${HOST}:81/api/posts/create`, {}, JSON.stringify({ postBody: content }))
       .then((res) => {
         console.log(res.status);
         swal({
@@ -53,13 +68,13 @@ function PostFeed(props) {
       postsHtml.push(
         <Card style={{ width: '35rem' }} key={idx}>
           <Card.Body>
-            <Card.Title><a href={`/profile/${post.authorID}`}>User ID {post.authorID}</a></Card.Title>
+            <Card.Title><a href={`/profile/${post.AuthorID}`}>User ID {post.AuthorID}</a></Card.Title>
             <Card.Subtitle className="mb-2 text-muted">Posted at {post.postTime}</Card.Subtitle>
-            <Card.Text>{post.content}</Card.Text>
+            <Card.Text>{post.postBody}</Card.Text>
           </Card.Body>
         </Card>
       );
-      // postsHtml.push(`${post.authorID}: ${post.content} <br />`);
+      // postsHtml.push(`${post.AuthorID}: ${post.postBody} <br />`);
     }
   } else {
     postsHtml = (<p>No posts in your feed from others.</p>);
@@ -69,9 +84,9 @@ function PostFeed(props) {
   const [friends, setFriends] = useState(null);
 
   if (friends === null) {
-    request('GET', `http://${HOST}:83/api/friends`, {})
-        .then((res) => {
-          setFriends(JSON.parse(res.responseText));
+    getAllFriends([])
+        .then((all) => {
+          setFriends(all);
         })
         .catch(() => {
           setFriends(false);
@@ -83,8 +98,9 @@ function PostFeed(props) {
       friendsHtml = "Error retrieving friends list.";
     } else {
       friendsHtml = [];
-      for (var uuid of friends) {
-        friendsHtml.push(<p><a href={`/profile/${uuid}`}>User ID {uuid}</a></p>);
+      for (var friend of friends) {
+        const name = friend.profile ? `${friend.profile.firstName} ${friend.profile.lastName}` : `User ID ${friend.uuid}`;
+        friendsHtml.push(<p><a href={`/profile/${friend.uuid}`}>{name}</a></p>);
       }
 
       if (!friends.length) {
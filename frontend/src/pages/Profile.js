@@ -112,8 +112,8 @@ function Profile(props) {
               .then((res) => {
                 console.log(res.status);
                 swal({
-                  title: "Added Friend!",
-                  text: `Successfully added ${personName} as a friend!`,
+                  title: "Sent Friend Request!",
+                  text: `Sent ${personName} a friend request!`,
                   icon: "success",
                   timeout: 5000
                 }).then(() => {
@@ -123,8 +123,8 @@ function Profile(props) {
               .catch((res) => {
                 console.log("err: ", res);
                 swal({
-                  title: "Could not add friend!",
-                  text: `Error when attempting to add friend (HTTP Status ${res.status}): ${res?.responseText?.trim()}.`,
+                  title: "Could not send friend request!",
+                  text: `Error when attempting to send friend request (HTTP Status ${res.status}): ${res?.responseText?.trim()}.`,
                   icon: "error"
                 });
               });
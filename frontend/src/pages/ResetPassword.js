@@ -0,0 +1,73 @@
+import React, { useState }  from 'react';
+import { Button, Form } from 'react-bootstrap';
+import { request, HOST } from '../common/utils.js';
+import swal from 'sweetalert';
+
+function ResetPassword(props) {
+
+  const token = new URLSearchParams(window.location.search).get('token') || '';
+  const [password, setPassword] = useState('');
+  const [confirm, setConfirm] = useState('');
+
+  const send = (e) => {
+    e.preventDefault();
+    if (password !== confirm) {
+      swal({
+        title: "Passwords don't match!",
+        text: "Type the same new password in both boxes.",
+        icon: "error"
+      });
+      return;
+    }
+    request('POST', `http://${HOST}:80/api/auth/resetpw`, { token }, JSON.stringify({ password }))
+      .then((res) => {
+        console.log(res.status);
+        swal({
+          title: "Password reset!",
+          text: "You can now sign in with your new password.",
+          icon: "success",
+          timeout: 5000
+        }).then(() => {
+          window.location.href = '/signin';
+        });
+      })
+      .catch((res) => {
+        console.log("err: ", res);
+        swal({
+          title: "Could not reset password!",
+          text: `Error when attempting to reset password (HTTP ${res.status}): ${res?.responseText?.trim()}.`,
+          icon: "error"
+        });
+      });
+  }
+
+  return (
+    <>
+      <Form onSubmit={ send }>
+        <Form.Group controlId="formPassword">
+          <Form.Label>New Password</Form.Label>
+          <Form.Control
+            type="password"
+            name="password"
+            placeholder="New Password"
+            onChange={(e) => setPassword(e.target.value)}
+          />
+        </Form.Group>
+        <Form.Group controlId="formConfirm">
+          <Form.Label>Confirm New Password</Form.Label>
+          <Form.Control
+            type="password"
+            name="confirm"
+            placeholder="Confirm New Password"
+            onChange={(e) => setConfirm(e.target.value)}
+          />
+        </Form.Group>
+        <Button variant="primary" type="submit" disabled={ token === '' }>
+          Reset Password
+        </Button>
+      </Form>
+    </>
+  );
+}
+
+export default ResetPassword;
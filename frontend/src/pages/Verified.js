@@ -0,0 +1,28 @@
+import React from 'react';
+import { Alert } from 'react-bootstrap';
+
+function Verified(props) {
+
+  const error = new URLSearchParams(window.location.search).get('error');
+
+  if (error) {
+    return (
+      <Alert variant="danger">
+        <Alert.Heading>Could not verify your email</Alert.Heading>
+        <p>
+          The link is invalid, has expired or was already used. Sign up again to get a new one.
+        </p>
+      </Alert>
+    );
+  }
+  return (
+    <Alert variant="success">
+      <Alert.Heading>Email verified!</Alert.Heading>
+      <p>
+        Your email has been verified. You can now <Alert.Link href="/signin">sign in</Alert.Link>.
+      </p>
+    </Alert>
+  );
+}
+
+export default Verified;
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/BearCloud/sp21-bearchat/common/friends"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// postsPerPage is how many posts are returned by a single feed or profile request.
+	postsPerPage = 25
+	// maxPostLength matches the size of the content column in postsDB.posts.
+	maxPostLength = 255
+)
+
+// Post represents a single post. AuthorID has no tag so that it keeps the name the legacy tests expect.
+type Post struct {
+	PostBody string    `json:"postBody"`
+	PostID   string    `json:"postID"`
+	AuthorID string
+	PostTime time.Time `json:"postTime"`
+}
+
+// RegisterRoutes initializes the api endpoints and maps the requests to specific functions. Routes
+// wrapped in authenticate only run for requests with a valid access token. Posts by users who have
+// blocked the caller, according to blocks, are left out of everything the caller reads. sources tells
+// whose posts belong in a feed limited to the caller's friends and the users they follow.
+func RegisterRoutes(router *mux.Router, authenticate func(http.Handler) http.Handler, db *sql.DB, blocks friends.BlockList, sources friends.FeedSources) {
+	router.Handle("/api/posts/create", authenticate(createPost(db))).Methods(http.MethodPost, http.MethodOptions)
+	router.Handle("/api/posts/delete/{postID}", authenticate(deletePost(db))).Methods(http.MethodDelete, http.MethodOptions)
+	router.Handle("/api/posts/{offset}", authenticate(getFeed(db, blocks, sources))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/posts/{uuid}/{offset}", authenticate(getPosts(db, blocks))).Methods(http.MethodGet, http.MethodOptions)
+}
+
+// createPost stores a new post written by the authenticated user.
+func createPost(DB *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		post := Post{}
+		err := json.NewDecoder(r.Body).Decode(&post)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			log.Print(err.Error())
+			return
+		}
+
+		// Check for invalid posts
+		if len(post.PostBody) == 0 {
+			http.Error(w, "post body is empty", http.StatusBadRequest)
+			return
+		}
+		if len(post.PostBody) > maxPostLength {
+			http.Error(w, "post body is too long", http.StatusBadRequest)
+			return
+		}
+
+		post.PostID = uuid.New().String()
+		post.AuthorID = auth.UserID(r)
+		post.PostTime = time.Now()
+
+		_, err = DB.Exec("INSERT INTO posts (content, postID, authorID, postTime) VALUES (?, ?, ?, ?)",
+			post.PostBody, post.PostID, post.AuthorID, post.PostTime)
+		if err != nil {
+			http.Error(w, "error storing post", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(post)
+	}
+}
+
+// getFeed returns a page of the most recent posts written by anyone other than the authenticated user,
+// leaving out users who have blocked them. With from=following, the page only has posts by the
+// caller's friends and the users they follow.
+func getFeed(DB *sql.DB, blocks friends.BlockList, sources friends.FeedSources) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offset, err := parseOffset(r)
+		if err != nil {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+		from := r.URL.Query().Get("from")
+		if from != "" && from != "following" {
+			http.Error(w, "from must be following", http.StatusBadRequest)
+			return
+		}
+
+		blockers, err := blocks.Blockers(r.Context(), auth.UserID(r))
+		if err != nil {
+			http.Error(w, "error checking blocked users", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+
+		// The caller is left out of their own feed the same way as the users who blocked them
+		hidden := append([]interface{}{auth.UserID(r)}, uuidArgs(blockers)...)
+		if from == "" {
+			args := append(hidden, postsPerPage, offset)
+			rows, err := DB.Query("SELECT content, postID, authorID, postTime FROM posts WHERE authorID NOT IN ("+placeholders(len(hidden))+") "+
+				"ORDER BY postTime DESC LIMIT ? OFFSET ?", args...)
+			if err != nil {
+				http.Error(w, "error getting posts", http.StatusInternalServerError)
+				log.Print(err.Error())
+				return
+			}
+			writePosts(w, rows)
+			return
+		}
+
+		authors, err := sources.FeedAuthors(r.Context(), auth.UserID(r))
+		if err != nil {
+			http.Error(w, "error finding followed users", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		if len(authors) == 0 {
+			json.NewEncoder(w).Encode([]Post{})
+			return
+		}
+
+		args := append(uuidArgs(authors), hidden...)
+		args = append(args, postsPerPage, offset)
+		rows, err := DB.Query("SELECT content, postID, authorID, postTime FROM posts WHERE authorID IN ("+placeholders(len(authors))+") "+
+			"AND authorID NOT IN ("+placeholders(len(hidden))+") ORDER BY postTime DESC LIMIT ? OFFSET ?", args...)
+		if err != nil {
+			http.Error(w, "error getting posts", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		writePosts(w, rows)
+	}
+}
+
+// getPosts returns a page of the most recent posts written by the user in the path. If that user has
+// blocked the caller, the page is empty.
+func getPosts(DB *sql.DB, blocks friends.BlockList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		offset, err := parseOffset(r)
+		if err != nil {
+			http.Error(w, "invalid offset", http.StatusBadRequest)
+			return
+		}
+
+		blockers, err := blocks.Blockers(r.Context(), auth.UserID(r))
+		if err != nil {
+			http.Error(w, "error checking blocked users", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		for _, blocker := range blockers {
+			if blocker == mux.Vars(r)["uuid"] {
+				json.NewEncoder(w).Encode([]Post{})
+				return
+			}
+		}
+
+		rows, err := DB.Query("SELECT content, postID, authorID, postTime FROM posts WHERE authorID=? ORDER BY postTime DESC LIMIT ? OFFSET ?",
+			mux.Vars(r)["uuid"], postsPerPage, offset)
+		if err != nil {
+			http.Error(w, "error getting posts", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		writePosts(w, rows)
+	}
+}
+
+// deletePost deletes a post. Only the post's author is allowed to delete it.
+func deletePost(DB *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID := mux.Vars(r)["postID"]
+
+		var authorID string
+		err := DB.QueryRow("SELECT authorID FROM posts WHERE postID=?", postID).Scan(&authorID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "post not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "error finding post", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+
+		if authorID != auth.UserID(r) {
+			http.Error(w, "only the author can delete a post", http.StatusUnauthorized)
+			return
+		}
+
+		_, err = DB.Exec("DELETE FROM posts WHERE postID=?", postID)
+		if err != nil {
+			http.Error(w, "error deleting post", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+	}
+}
+
+// parseOffset reads the {offset} path variable, which must be a non-negative integer.
+func parseOffset(r *http.Request) (int, error) {
+	offset, err := strconv.Atoi(mux.Vars(r)["offset"])
+	if err == nil && offset < 0 {
+		err = strconv.ErrRange
+	}
+	return offset, err
+}
+
+// placeholders returns a comma-separated list of n query placeholders, for use in an IN clause.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// uuidArgs converts a list of UUIDs into query arguments.
+func uuidArgs(uuids []string) []interface{} {
+	args := make([]interface{}, len(uuids))
+	for i, id := range uuids {
+		args[i] = id
+	}
+	return args
+}
+
+// writePosts encodes every post in rows as a JSON array.
+func writePosts(w http.ResponseWriter, rows *sql.Rows) {
+	defer rows.Close()
+
+	posts := []Post{}
+	for rows.Next() {
+		post := Post{}
+		err := rows.Scan(&post.PostBody, &post.PostID, &post.AuthorID, &post.PostTime)
+		if err != nil {
+			http.Error(w, "error reading posts", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		http.Error(w, "error reading posts", http.StatusInternalServerError)
+		log.Print(err.Error())
+		return
+	}
+
+	json.NewEncoder(w).Encode(posts)
+}
@@ -0,0 +1,299 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+// TESTS
+
+func TestMain(m *testing.M) {
+	// Makes it so any log statements are discarded. Comment these two lines
+	// if you want to see the logs.
+	log.SetFlags(0)
+	log.SetOutput(io.Discard)
+
+	// Runs the tests to completion then exits.
+	os.Exit(m.Run())
+}
+
+// Runs every test that uses the database.
+func TestAll(t *testing.T) {
+	suite.Run(t, new(PostsTestSuite))
+}
+
+// Makes sure the database starts in a clean state before each test.
+func (s *PostsTestSuite) SetupTest() {
+	err := s.db.Ping()
+	if err != nil {
+		s.T().Logf("could not connect to database. skipping test. %s", err)
+		s.T().SkipNow()
+	}
+
+	err = s.clearDatabase()
+	if err != nil {
+		s.T().Logf("could not clear database. skipping test. %s", err)
+		s.T().SkipNow()
+	}
+}
+
+func (s *PostsTestSuite) TestCreate() {
+	s.Run("Test Basic Create", func() {
+		s.SetupTest()
+		rr := s.createPost(s.oski, "Go Bears!")
+		s.Assert().Equal(http.StatusCreated, rr.Code, "incorrect status code returned")
+
+		// Make sure the post made it into the database with the right author.
+		var exists bool
+		err := s.db.QueryRow("SELECT EXISTS(SELECT * FROM posts WHERE authorID=? AND content=?)", s.oski, "Go Bears!").Scan(&exists)
+		if s.Assert().NoError(err, "an error occurred while checking the database") {
+			s.Assert().True(exists, "could not find the post in the database")
+		}
+	})
+
+	s.Run("Test Empty Post", func() {
+		s.SetupTest()
+		rr := s.createPost(s.oski, "")
+		s.Assert().Equal(http.StatusBadRequest, rr.Code, "incorrect status code returned")
+	})
+}
+
+func (s *PostsTestSuite) TestFeed() {
+	s.SetupTest()
+	s.Require().Equal(http.StatusCreated, s.createPost(s.oski, "first").Code)
+	s.Require().Equal(http.StatusCreated, s.createPost(s.stanfurd, "second").Code)
+
+	// Oski's feed only has posts from other people.
+	r := s.asUser(httptest.NewRequest(http.MethodGet, "/api/posts/0", nil), s.oski)
+	r = mux.SetURLVars(r, map[string]string{"offset": "0"})
+	rr := httptest.NewRecorder()
+	getFeed(s.db, s.blocks, s.sources)(rr, r)
+
+	s.Require().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+	feed := s.decodePosts(rr)
+	if s.Assert().Len(feed, 1, "feed has the wrong number of posts") {
+		s.Assert().Equal(s.stanfurd, feed[0].AuthorID)
+		s.Assert().Equal("second", feed[0].PostBody)
+	}
+
+	// Offsets past the end return an empty page.
+	r = s.asUser(httptest.NewRequest(http.MethodGet, "/api/posts/10", nil), s.oski)
+	r = mux.SetURLVars(r, map[string]string{"offset": "10"})
+	rr = httptest.NewRecorder()
+	getFeed(s.db, s.blocks, s.sources)(rr, r)
+	s.Assert().Empty(s.decodePosts(rr))
+}
+
+func (s *PostsTestSuite) TestGetPosts() {
+	s.SetupTest()
+	s.Require().Equal(http.StatusCreated, s.createPost(s.oski, "first").Code)
+	s.Require().Equal(http.StatusCreated, s.createPost(s.stanfurd, "second").Code)
+
+	r := s.asUser(httptest.NewRequest(http.MethodGet, "/api/posts/"+s.oski+"/0", nil), s.stanfurd)
+	r = mux.SetURLVars(r, map[string]string{"uuid": s.oski, "offset": "0"})
+	rr := httptest.NewRecorder()
+	getPosts(s.db, s.blocks)(rr, r)
+
+	s.Require().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+	posts := s.decodePosts(rr)
+	if s.Assert().Len(posts, 1, "wrong number of posts returned") {
+		s.Assert().Equal(s.oski, posts[0].AuthorID)
+	}
+}
+
+func (s *PostsTestSuite) TestBlockedPosts() {
+	s.SetupTest()
+	s.Require().Equal(http.StatusCreated, s.createPost(s.oski, "first").Code)
+	s.Require().Equal(http.StatusCreated, s.createPost(s.stanfurd, "second").Code)
+	s.blocks[s.stanfurd] = []string{s.oski}
+	defer delete(s.blocks, s.stanfurd)
+
+	s.Run("Test Feed Hides Blockers", func() {
+		r := s.asUser(httptest.NewRequest(http.MethodGet, "/api/posts/0", nil), s.stanfurd)
+		r = mux.SetURLVars(r, map[string]string{"offset": "0"})
+		rr := httptest.NewRecorder()
+		getFeed(s.db, s.blocks, s.sources)(rr, r)
+		s.Require().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+		s.Assert().Empty(s.decodePosts(rr), "feed includes posts by someone who blocked the user")
+	})
+
+	s.Run("Test Blocker Posts Hidden", func() {
+		r := s.asUser(httptest.NewRequest(http.MethodGet, "/api/posts/"+s.oski+"/0", nil), s.stanfurd)
+		r = mux.SetURLVars(r, map[string]string{"uuid": s.oski, "offset": "0"})
+		rr := httptest.NewRecorder()
+		getPosts(s.db, s.blocks)(rr, r)
+		s.Require().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+		s.Assert().Empty(s.decodePosts(rr), "posts by someone who blocked the user were returned")
+	})
+
+	s.Run("Test Blocker Still Sees Posts", func() {
+		r := s.asUser(httptest.NewRequest(http.MethodGet, "/api/posts/0", nil), s.oski)
+		r = mux.SetURLVars(r, map[string]string{"offset": "0"})
+		rr := httptest.NewRecorder()
+		getFeed(s.db, s.blocks, s.sources)(rr, r)
+		s.Assert().Len(s.decodePosts(rr), 1, "blocking should only hide the blocker's posts")
+	})
+}
+
+func (s *PostsTestSuite) TestFollowingFeed() {
+	s.SetupTest()
+	bruin := "3c1d2e4f-5a6b-4c7d-8e9f-0a1b2c3d4e5f"
+	s.Require().Equal(http.StatusCreated, s.createPost(s.oski, "first").Code)
+	s.Require().Equal(http.StatusCreated, s.createPost(s.stanfurd, "second").Code)
+	s.Require().Equal(http.StatusCreated, s.createPost(bruin, "third").Code)
+	s.sources[s.oski] = []string{bruin}
+	defer delete(s.sources, s.oski)
+
+	// Gets oski's feed with the given query.
+	feed := func(query string) *httptest.ResponseRecorder {
+		r := s.asUser(httptest.NewRequest(http.MethodGet, "/api/posts/0?"+query, nil), s.oski)
+		r = mux.SetURLVars(r, map[string]string{"offset": "0"})
+		rr := httptest.NewRecorder()
+		getFeed(s.db, s.blocks, s.sources)(rr, r)
+		return rr
+	}
+
+	s.Run("Test Feed From Followed Users", func() {
+		rr := feed("from=following")
+		s.Require().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+		posts := s.decodePosts(rr)
+		if s.Assert().Len(posts, 1, "feed should only have posts by followed users") {
+			s.Assert().Equal(bruin, posts[0].AuthorID)
+		}
+	})
+
+	s.Run("Test Feed From No One", func() {
+		r := s.asUser(httptest.NewRequest(http.MethodGet, "/api/posts/0?from=following", nil), s.stanfurd)
+		r = mux.SetURLVars(r, map[string]string{"offset": "0"})
+		rr := httptest.NewRecorder()
+		getFeed(s.db, s.blocks, s.sources)(rr, r)
+		s.Require().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+		s.Assert().Empty(s.decodePosts(rr))
+	})
+
+	s.Run("Test Invalid Feed Source", func() {
+		s.Assert().Equal(http.StatusBadRequest, feed("from=everyone").Code, "incorrect status code returned")
+	})
+}
+
+func (s *PostsTestSuite) TestDelete() {
+	s.SetupTest()
+	rr := s.createPost(s.oski, "delete me")
+	s.Require().Equal(http.StatusCreated, rr.Code)
+	post := Post{}
+	s.Require().NoError(json.NewDecoder(rr.Body).Decode(&post))
+
+	s.Run("Test Delete Someone Elses Post", func() {
+		rr := s.deletePost(s.stanfurd, post.PostID)
+		s.Assert().Equal(http.StatusUnauthorized, rr.Code, "incorrect status code returned")
+		s.Assert().True(s.postExists(post.PostID), "post was deleted by someone other than its author")
+	})
+
+	s.Run("Test Delete Own Post", func() {
+		rr := s.deletePost(s.oski, post.PostID)
+		s.Assert().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+		s.Assert().False(s.postExists(post.PostID), "post was not deleted")
+	})
+
+	s.Run("Test Delete Missing Post", func() {
+		rr := s.deletePost(s.oski, post.PostID)
+		s.Assert().Equal(http.StatusNotFound, rr.Code, "incorrect status code returned")
+	})
+}
+
+// HELPER METHODS AND DEFINITIONS
+
+// Makes a Suite for all of the posts tests to live in
+type PostsTestSuite struct {
+	suite.Suite
+	db       *sql.DB
+	blocks   blockList
+	sources  feedSources
+	oski     string
+	stanfurd string
+}
+
+// A BlockList mapping each user to the users who have blocked them.
+type blockList map[string][]string
+
+func (b blockList) Blockers(ctx context.Context, uuid string) ([]string, error) {
+	return b[uuid], nil
+}
+
+// A FeedSources mapping each user to their friends and the users they follow.
+type feedSources map[string][]string
+
+func (f feedSources) FeedAuthors(ctx context.Context, uuid string) ([]string, error) {
+	return f[uuid], nil
+}
+
+// Clears the posts database so the tests remain independent.
+func (s *PostsTestSuite) clearDatabase() (err error) {
+	_, err = s.db.Exec("TRUNCATE TABLE posts")
+	return err
+}
+
+// Attaches claims for the given user to the request, as the auth middleware would.
+func (s *PostsTestSuite) asUser(r *http.Request, userID string) *http.Request {
+	return r.WithContext(auth.NewContext(r.Context(), &auth.AuthClaims{UserID: userID}))
+}
+
+// Creates a post as the given user.
+func (s *PostsTestSuite) createPost(userID string, body string) *httptest.ResponseRecorder {
+	postJSON, err := json.Marshal(Post{PostBody: body})
+	s.Require().NoError(err)
+	r := s.asUser(httptest.NewRequest(http.MethodPost, "/api/posts/create", bytes.NewBuffer(postJSON)), userID)
+	rr := httptest.NewRecorder()
+	createPost(s.db)(rr, r)
+	return rr
+}
+
+// Deletes a post as the given user.
+func (s *PostsTestSuite) deletePost(userID string, postID string) *httptest.ResponseRecorder {
+	r := s.asUser(httptest.NewRequest(http.MethodDelete, "/api/posts/delete/"+postID, nil), userID)
+	r = mux.SetURLVars(r, map[string]string{"postID": postID})
+	rr := httptest.NewRecorder()
+	deletePost(s.db)(rr, r)
+	return rr
+}
+
+// Decodes the JSON array of posts in a response.
+func (s *PostsTestSuite) decodePosts(rr *httptest.ResponseRecorder) []Post {
+	posts := []Post{}
+	s.Require().NoError(json.NewDecoder(rr.Body).Decode(&posts), "response was not a list of posts")
+	return posts
+}
+
+// Checks whether a post with the given ID is in the database.
+func (s *PostsTestSuite) postExists(postID string) bool {
+	var exists bool
+	err := s.db.QueryRow("SELECT EXISTS(SELECT * FROM posts WHERE postID=?)", postID).Scan(&exists)
+	s.Require().NoError(err, "an error occurred while checking the database")
+	return exists
+}
+
+// Setup the db variable before any tests are run.
+func (s *PostsTestSuite) SetupSuite() {
+	// Connects to the MySQL Docker Container. Notice that we use localhost
+	// instead of the container's IP address since it is assumed these
+	// tests run outside of the container network.
+	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/postsDB?parseTime=true")
+	s.Require().NoError(err, "could not connect to the database!")
+	s.db = db
+	s.blocks = blockList{}
+	s.sources = feedSources{}
+	s.oski = "6e9fc8a4-6a0d-4bda-a6b3-2f6e1c0f8a11"
+	s.stanfurd = "0b2b4c3d-9e8f-4a7b-8c6d-5e4f3a2b1c0d"
+}
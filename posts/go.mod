@@ -0,0 +1,13 @@
+module github.com/BearCloud/sp21-bearchat/posts
+
+go 1.16
+
+require (
+	github.com/BearCloud/sp21-bearchat/common v0.0.0-00010101000000-000000000000
+	github.com/go-sql-driver/mysql v1.6.0
+	github.com/google/uuid v1.2.0
+	github.com/gorilla/mux v1.8.0
+	github.com/stretchr/testify v1.7.0
+)
+
+replace github.com/BearCloud/sp21-bearchat/common => ../common
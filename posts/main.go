@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/BearCloud/sp21-bearchat/common/friends"
+	"github.com/BearCloud/sp21-bearchat/posts/api"
+	"github.com/gorilla/mux"
+)
+
+func main() {
+
+	// Initialize our database connections. Posts live in postsDB, while the sessions table used to
+	// check for revoked tokens lives in the auth database.
+	db := api.InitDB("postsDB")
+	defer db.Close()
+	authDB := api.InitDB("auth")
+	defer authDB.Close()
+
+	// Check access tokens against the auth service's published keys and its sessions table
+	validator := auth.NewValidator(auth.NewKeyCache(auth.JWKSURL()), auth.SQLSessions{DB: authDB})
+
+	// Create a new mux for routing api calls
+	router := mux.NewRouter()
+	router.Use(CORS)
+	router.Methods(http.MethodOptions)
+
+	// Ask the friends service who has blocked each caller, so their posts can be hidden, and whose posts
+	// belong in a feed of the people they know
+	friendsClient := friends.NewClient(friends.URL(), auth.InternalToken())
+
+	api.RegisterRoutes(router, auth.Middleware(validator), db, friendsClient, friendsClient)
+
+	log.Println("starting go server")
+	http.ListenAndServe(":80", router)
+}
+
+func CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// Set headers
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Origin", "<YOUR EC2 IP HERE>:3000")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Next
+		next.ServeHTTP(w, r)
+	})
+}
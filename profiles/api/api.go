@@ -0,0 +1,155 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/BearCloud/sp21-bearchat/common/friends"
+	"github.com/BearCloud/sp21-bearchat/common/profiles"
+	"github.com/gorilla/mux"
+)
+
+// Profile represents the public profile of a user.
+type Profile struct {
+	Firstname string `json:"firstName"`
+	Lastname  string `json:"lastName"`
+	Email     string `json:"email"`
+	UUID      string `json:"uuid"`
+}
+
+// RegisterRoutes initializes the api endpoints and maps the requests to specific functions. Routes
+// wrapped in authenticate only run for requests with a valid access token. Profiles of users who have
+// blocked the caller, according to blocks, are hidden from the caller.
+func RegisterRoutes(router *mux.Router, authenticate func(http.Handler) http.Handler, db *sql.DB, blocks friends.BlockList) {
+	router.Handle("/api/profile/{uuid}", authenticate(getProfile(db, blocks))).Methods(http.MethodGet, http.MethodOptions)
+	router.Handle("/api/profile/{uuid}", authenticate(updateProfile(db))).Methods(http.MethodPut, http.MethodOptions)
+}
+
+// RegisterInternalRoutes maps the endpoints other services call onto handlers. Routes wrapped in
+// authorize only run for requests from other services.
+func RegisterInternalRoutes(router *mux.Router, authorize func(http.Handler) http.Handler, db *sql.DB) {
+	router.Handle("/internal/profiles", authorize(getProfiles(db))).Methods(http.MethodGet)
+}
+
+// getProfile returns the profile of the user in the path. If that user has blocked the caller, it
+// responds as if the profile didn't exist.
+func getProfile(DB *sql.DB, blocks friends.BlockList) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		blockers, err := blocks.Blockers(r.Context(), auth.UserID(r))
+		if err != nil {
+			http.Error(w, "error checking blocked users", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		for _, blocker := range blockers {
+			if blocker == mux.Vars(r)["uuid"] {
+				http.Error(w, "profile not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		profile := Profile{}
+		err = DB.QueryRow("SELECT firstName, lastName, email, uuid FROM users WHERE uuid=?", mux.Vars(r)["uuid"]).
+			Scan(&profile.Firstname, &profile.Lastname, &profile.Email, &profile.UUID)
+		if err == sql.ErrNoRows {
+			http.Error(w, "profile not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "error getting profile", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+
+		json.NewEncoder(w).Encode(profile)
+	}
+}
+
+// updateProfile creates or replaces the profile of the user in the path. Users may only update their
+// own profile.
+func updateProfile(DB *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuid := mux.Vars(r)["uuid"]
+		if uuid != auth.UserID(r) {
+			http.Error(w, "you can only update your own profile", http.StatusUnauthorized)
+			return
+		}
+
+		profile := Profile{}
+		err := json.NewDecoder(r.Body).Decode(&profile)
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			log.Print(err.Error())
+			return
+		}
+
+		// The body may leave out the uuid, but it can't point at someone else
+		if profile.UUID != "" && profile.UUID != uuid {
+			http.Error(w, "uuid in body does not match the url", http.StatusBadRequest)
+			return
+		}
+		profile.UUID = uuid
+
+		_, err = DB.Exec("INSERT INTO users (firstName, lastName, email, uuid) VALUES (?, ?, ?, ?) "+
+			"ON DUPLICATE KEY UPDATE firstName=VALUES(firstName), lastName=VALUES(lastName), email=VALUES(email)",
+			profile.Firstname, profile.Lastname, profile.Email, profile.UUID)
+		if err != nil {
+			http.Error(w, "error updating profile", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+	}
+}
+
+// getProfiles returns the profiles of every user listed in the uuid query parameters, which may be
+// repeated up to profiles.MaxBatch times. Users without a profile are left out of the response.
+func getProfiles(DB *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		uuids := r.URL.Query()["uuid"]
+		if len(uuids) > profiles.MaxBatch {
+			http.Error(w, "too many profiles requested", http.StatusBadRequest)
+			return
+		}
+
+		found := []Profile{}
+		if len(uuids) == 0 {
+			json.NewEncoder(w).Encode(found)
+			return
+		}
+
+		args := make([]interface{}, len(uuids))
+		for i, id := range uuids {
+			args[i] = id
+		}
+		rows, err := DB.Query("SELECT firstName, lastName, email, uuid FROM users WHERE uuid IN ("+
+			strings.TrimSuffix(strings.Repeat("?,", len(uuids)), ",")+")", args...)
+		if err != nil {
+			http.Error(w, "error getting profiles", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			profile := Profile{}
+			err = rows.Scan(&profile.Firstname, &profile.Lastname, &profile.Email, &profile.UUID)
+			if err != nil {
+				http.Error(w, "error reading profiles", http.StatusInternalServerError)
+				log.Print(err.Error())
+				return
+			}
+			found = append(found, profile)
+		}
+		if err := rows.Err(); err != nil {
+			http.Error(w, "error reading profiles", http.StatusInternalServerError)
+			log.Print(err.Error())
+			return
+		}
+
+		json.NewEncoder(w).Encode(found)
+	}
+}
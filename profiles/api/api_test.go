@@ -0,0 +1,237 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/BearCloud/sp21-bearchat/common/profiles"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/suite"
+)
+
+// TESTS
+
+func TestMain(m *testing.M) {
+	// Makes it so any log statements are discarded. Comment these two lines
+	// if you want to see the logs.
+	log.SetFlags(0)
+	log.SetOutput(io.Discard)
+
+	// Runs the tests to completion then exits.
+	os.Exit(m.Run())
+}
+
+// Runs every test that uses the database.
+func TestAll(t *testing.T) {
+	suite.Run(t, new(ProfilesTestSuite))
+}
+
+// Makes sure the database starts in a clean state before each test.
+func (s *ProfilesTestSuite) SetupTest() {
+	err := s.db.Ping()
+	if err != nil {
+		s.T().Logf("could not connect to database. skipping test. %s", err)
+		s.T().SkipNow()
+	}
+
+	err = s.clearDatabase()
+	if err != nil {
+		s.T().Logf("could not clear database. skipping test. %s", err)
+		s.T().SkipNow()
+	}
+}
+
+func (s *ProfilesTestSuite) TestGetProfile() {
+	s.Run("Test Get Existing Profile", func() {
+		s.SetupTest()
+		s.Require().Equal(http.StatusOK, s.putProfile(s.oski, s.oskiProfile).Code)
+
+		// Anyone can read the profile once it exists.
+		rr := s.getProfile(s.stanfurd, s.oski)
+		s.Require().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+		profile := Profile{}
+		s.Require().NoError(json.NewDecoder(rr.Body).Decode(&profile))
+		s.Assert().Equal(s.oskiProfile, profile)
+	})
+
+	s.Run("Test Get Unknown Profile", func() {
+		s.SetupTest()
+		rr := s.getProfile(s.oski, s.stanfurd)
+		s.Assert().Equal(http.StatusNotFound, rr.Code, "incorrect status code returned")
+	})
+
+	s.Run("Test Get Blocker Profile", func() {
+		s.SetupTest()
+		s.Require().Equal(http.StatusOK, s.putProfile(s.oski, s.oskiProfile).Code)
+		s.blocks[s.stanfurd] = []string{s.oski}
+		defer delete(s.blocks, s.stanfurd)
+
+		// The blocked user can't tell the profile exists, but everyone else can still see it.
+		rr := s.getProfile(s.stanfurd, s.oski)
+		s.Assert().Equal(http.StatusNotFound, rr.Code, "profile of someone who blocked the user was returned")
+		rr = s.getProfile(s.oski, s.oski)
+		s.Assert().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+	})
+}
+
+func (s *ProfilesTestSuite) TestUpdateProfile() {
+	s.Run("Test Update Own Profile", func() {
+		s.SetupTest()
+		s.Require().Equal(http.StatusOK, s.putProfile(s.oski, s.oskiProfile).Code)
+
+		// Updating again replaces the old values.
+		updated := s.oskiProfile
+		updated.Lastname = "The Bear"
+		s.Require().Equal(http.StatusOK, s.putProfile(s.oski, updated).Code)
+
+		var lastName string
+		err := s.db.QueryRow("SELECT lastName FROM users WHERE uuid=?", s.oski).Scan(&lastName)
+		if s.Assert().NoError(err, "an error occurred while checking the database") {
+			s.Assert().Equal("The Bear", lastName, "profile was not updated")
+		}
+	})
+
+	s.Run("Test Update Someone Elses Profile", func() {
+		s.SetupTest()
+		r := httptest.NewRequest(http.MethodPut, "/api/profile/"+s.oski, bytes.NewBuffer(s.profileJSON(s.oskiProfile)))
+		r = s.asUser(r, s.stanfurd)
+		r = mux.SetURLVars(r, map[string]string{"uuid": s.oski})
+		rr := httptest.NewRecorder()
+		updateProfile(s.db)(rr, r)
+
+		s.Assert().Equal(http.StatusUnauthorized, rr.Code, "incorrect status code returned")
+		s.Assert().Equal(http.StatusNotFound, s.getProfile(s.oski, s.oski).Code, "profile was created by someone else")
+	})
+
+	s.Run("Test Mismatched UUID", func() {
+		s.SetupTest()
+		profile := s.oskiProfile
+		profile.UUID = s.stanfurd
+		rr := s.putProfile(s.oski, profile)
+		s.Assert().Equal(http.StatusBadRequest, rr.Code, "incorrect status code returned")
+	})
+}
+
+func (s *ProfilesTestSuite) TestGetProfiles() {
+	s.Run("Test Get Several Profiles", func() {
+		s.SetupTest()
+		s.Require().Equal(http.StatusOK, s.putProfile(s.oski, s.oskiProfile).Code)
+
+		// Users without a profile are left out rather than failing the whole batch.
+		rr := s.getProfiles(s.oski, s.stanfurd)
+		s.Require().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+		found := []Profile{}
+		s.Require().NoError(json.NewDecoder(rr.Body).Decode(&found))
+		s.Assert().Equal([]Profile{s.oskiProfile}, found)
+	})
+
+	s.Run("Test Get No Profiles", func() {
+		s.SetupTest()
+		rr := s.getProfiles()
+		s.Require().Equal(http.StatusOK, rr.Code, "incorrect status code returned")
+		s.Assert().JSONEq("[]", rr.Body.String())
+	})
+
+	s.Run("Test Get Too Many Profiles", func() {
+		s.SetupTest()
+		uuids := make([]string, profiles.MaxBatch+1)
+		for i := range uuids {
+			uuids[i] = s.oski
+		}
+		rr := s.getProfiles(uuids...)
+		s.Assert().Equal(http.StatusBadRequest, rr.Code, "incorrect status code returned")
+	})
+}
+
+// HELPER METHODS AND DEFINITIONS
+
+// Makes a Suite for all of the profiles tests to live in
+type ProfilesTestSuite struct {
+	suite.Suite
+	db          *sql.DB
+	blocks      blockList
+	oski        string
+	stanfurd    string
+	oskiProfile Profile
+}
+
+// A BlockList mapping each user to the users who have blocked them.
+type blockList map[string][]string
+
+func (b blockList) Blockers(ctx context.Context, uuid string) ([]string, error) {
+	return b[uuid], nil
+}
+
+// Clears the profiles database so the tests remain independent.
+func (s *ProfilesTestSuite) clearDatabase() (err error) {
+	_, err = s.db.Exec("TRUNCATE TABLE users")
+	return err
+}
+
+// Attaches claims for the given user to the request, as the auth middleware would.
+func (s *ProfilesTestSuite) asUser(r *http.Request, userID string) *http.Request {
+	return r.WithContext(auth.NewContext(r.Context(), &auth.AuthClaims{UserID: userID}))
+}
+
+// Returns a byte array with a JSON containing the passed in Profile.
+func (s *ProfilesTestSuite) profileJSON(p Profile) []byte {
+	profileJSON, err := json.Marshal(p)
+	s.Require().NoErrorf(err, "failed to encode profile %s", err)
+	return profileJSON
+}
+
+// Updates userID's own profile.
+func (s *ProfilesTestSuite) putProfile(userID string, p Profile) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPut, "/api/profile/"+userID, bytes.NewBuffer(s.profileJSON(p)))
+	r = s.asUser(r, userID)
+	r = mux.SetURLVars(r, map[string]string{"uuid": userID})
+	rr := httptest.NewRecorder()
+	updateProfile(s.db)(rr, r)
+	return rr
+}
+
+// Gets the profile of owner while signed in as viewer.
+func (s *ProfilesTestSuite) getProfile(viewer string, owner string) *httptest.ResponseRecorder {
+	r := s.asUser(httptest.NewRequest(http.MethodGet, "/api/profile/"+owner, nil), viewer)
+	r = mux.SetURLVars(r, map[string]string{"uuid": owner})
+	rr := httptest.NewRecorder()
+	getProfile(s.db, s.blocks)(rr, r)
+	return rr
+}
+
+// Looks up the profiles of the given users through the internal endpoint.
+func (s *ProfilesTestSuite) getProfiles(uuids ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/internal/profiles?"+url.Values{"uuid": uuids}.Encode(), nil)
+	rr := httptest.NewRecorder()
+	getProfiles(s.db)(rr, r)
+	return rr
+}
+
+// Setup the db variable before any tests are run.
+func (s *ProfilesTestSuite) SetupSuite() {
+	// Connects to the MySQL Docker Container. Notice that we use localhost
+	// instead of the container's IP address since it is assumed these
+	// tests run outside of the container network.
+	db, err := sql.Open("mysql", "root:root@tcp(localhost:3306)/profiles?parseTime=true")
+	s.Require().NoError(err, "could not connect to the database!")
+	s.db = db
+	s.blocks = blockList{}
+	s.oski = "6e9fc8a4-6a0d-4bda-a6b3-2f6e1c0f8a11"
+	s.stanfurd = "0b2b4c3d-9e8f-4a7b-8c6d-5e4f3a2b1c0d"
+	s.oskiProfile = Profile{
+		Firstname: "Oski",
+		Lastname:  "Bear",
+		Email:     "oski@berkeley.edu",
+		UUID:      s.oski,
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/BearCloud/sp21-bearchat/common/auth"
+	"github.com/BearCloud/sp21-bearchat/common/friends"
+	"github.com/BearCloud/sp21-bearchat/profiles/api"
+	"github.com/gorilla/mux"
+)
+
+func main() {
+
+	// Initialize our database connections. Profiles live in the profiles database, while the sessions
+	// table used to check for revoked tokens lives in the auth database.
+	db := api.InitDB("profiles")
+	defer db.Close()
+	authDB := api.InitDB("auth")
+	defer authDB.Close()
+
+	// Check access tokens against the auth service's published keys and its sessions table
+	validator := auth.NewValidator(auth.NewKeyCache(auth.JWKSURL()), auth.SQLSessions{DB: authDB})
+
+	// Create a new mux for routing api calls
+	router := mux.NewRouter()
+	router.Use(CORS)
+	router.Methods(http.MethodOptions)
+
+	// Ask the friends service who has blocked each caller, so their profiles can be hidden
+	blocks := friends.NewClient(friends.URL(), auth.InternalToken())
+
+	api.RegisterRoutes(router, auth.Middleware(validator), db, blocks)
+
+	// Internal endpoints are only for other services, which authenticate with the shared internal token
+	api.RegisterInternalRoutes(router, auth.InternalMiddleware(auth.InternalToken()), db)
+
+	log.Println("starting go server")
+	http.ListenAndServe(":80", router)
+}
+
+func CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		// Set headers
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.Header().Set("Access-Control-Allow-Origin", "<YOUR EC2 IP HERE>:3000")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, PUT, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// Next
+		next.ServeHTTP(w, r)
+	})
+}